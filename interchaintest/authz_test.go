@@ -0,0 +1,97 @@
+package ibc_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/cosmos/cosmos-sdk/x/authz"
+	"github.com/strangelove-ventures/interchaintest/v3/chain/cosmos"
+	"github.com/stretchr/testify/require"
+)
+
+// A grant as `query authz grants` prints it. The authorization is
+// left as JSON, with its type in "@type".
+type authzGrant struct {
+	Authorization json.RawMessage `json:"authorization"`
+	Expiration    time.Time       `json:"expiration"`
+}
+
+// The response to `query authz grants`.
+type authzGrantsQueryResponse struct {
+	Grants []authzGrant `json:"grants"`
+}
+
+// A send authorization as `query authz grants` prints it.
+type sendAuthorization struct {
+	Type       string `json:"@type"`
+	SpendLimit []struct {
+		Denom  string `json:"denom"`
+		Amount string `json:"amount"`
+	} `json:"spend_limit"`
+}
+
+// Queries the grants granter has given grantee on chain.
+func QueryAuthzGrants(ctx context.Context, chain *cosmos.CosmosChain, granter, grantee string) ([]authzGrant, error) {
+	var response authzGrantsQueryResponse
+	if err := QueryHostJSON(ctx, chain, []string{"authz", "grants", granter, grantee}, &response); err != nil {
+		return nil, fmt.Errorf("failed to query grants from %s to %s: %w", granter, grantee, err)
+	}
+	return response.Grants, nil
+}
+
+// Tests that an interchain account can grant another address a send
+// authorization on the host chain, and that the grant is set to
+// expire when it was asked to.
+func TestICAGrantSend(t *testing.T) {
+	env := setupICSTest(t)
+	ctx, atom, neutron := env.ctx, env.atom, env.neutron
+	denom := atom.Config().Denom
+
+	contract := deployICAContract(t, env)
+	icaAddress := registerICA(t, env, contract, "test")
+	grantee := randomAddress(t, atom)
+
+	// Timestamps are printed to the second or finer, so the
+	// expiration should come back as it was sent.
+	expiration := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	sequence, err := SubmitICAGrantSend(ctx, neutron, env.neutronUser.KeyName, contract, "test", grantee, 1_000, denom, expiration, 0)
+	require.NoError(t, err)
+	result, err := WaitForAcknowledgement(ctx, neutron, contract, "test", sequence, 2*time.Minute)
+	require.NoError(t, err)
+	require.Equal(t, []string{"/cosmos.authz.v1beta1.MsgGrant"}, result.Success, "expected success, got %+v", result)
+
+	grants, err := QueryAuthzGrants(ctx, atom, icaAddress, grantee)
+	require.NoError(t, err)
+	require.Len(t, grants, 1)
+	require.True(t, expiration.Equal(grants[0].Expiration), "grant expires at %s, not %s", grants[0].Expiration, expiration)
+	var authorization sendAuthorization
+	require.NoError(t, json.Unmarshal(grants[0].Authorization, &authorization))
+	require.Equal(t, "/cosmos.bank.v1beta1.SendAuthorization", authorization.Type)
+	require.Len(t, authorization.SpendLimit, 1)
+	require.Equal(t, denom, authorization.SpendLimit[0].Denom)
+	require.Equal(t, "1000", authorization.SpendLimit[0].Amount)
+}
+
+func TestSendGrantMsg(t *testing.T) {
+	expiration := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+	granter := "cosmos1hfxm6slsnrhfmcap6q66zl0uwaq8fy3t6xqfmfhfmp6eaupaphnq8yggam"
+	grantee := "cosmos1qypqxpq9qcrsszg2pvxq6rs0zqg3yyc5lzv7xu"
+	msg, err := sendGrantMsg(granter, grantee, 1_000, "uatom", expiration)
+	require.NoError(t, err)
+	require.Equal(t, granter, msg.Granter)
+	require.Equal(t, grantee, msg.Grantee)
+	require.Equal(t, "/cosmos.bank.v1beta1.SendAuthorization", msg.Grant.Authorization.TypeUrl)
+	require.True(t, expiration.Equal(msg.Grant.Expiration))
+
+	// The encoded message carries the expiration to the host.
+	encoded, err := EncodeICAMessage("/cosmos.authz.v1beta1.MsgGrant", msg)
+	require.NoError(t, err)
+	var wrapped ProtobufAny
+	require.NoError(t, json.Unmarshal(encoded, &wrapped))
+	var decoded authz.MsgGrant
+	require.NoError(t, decoded.Unmarshal(wrapped.Value))
+	require.True(t, expiration.Equal(decoded.Grant.Expiration))
+}
@@ -0,0 +1,613 @@
+package ibc_test
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cosmos/cosmos-sdk/crypto/keyring"
+	sdk "github.com/cosmos/cosmos-sdk/types"
+	ibctest "github.com/strangelove-ventures/interchaintest/v3"
+	"github.com/strangelove-ventures/interchaintest/v3/chain/cosmos"
+	"github.com/strangelove-ventures/interchaintest/v3/ibc"
+	"github.com/strangelove-ventures/interchaintest/v3/testreporter"
+	"github.com/strangelove-ventures/interchaintest/v3/testutil"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+)
+
+// The port that the ccvconsumer module binds to on a consumer chain.
+// The CCV channel is the channel on this port.
+const ccvConsumerPort = "consumer"
+
+// The port that the provider module binds to on a provider chain.
+const ccvProviderPort = "provider"
+
+// The response to `query ibc channel channels`.
+type channelsQueryResponse struct {
+	Channels []ibc.ChannelOutput `json:"channels"`
+}
+
+// Reports whether the consumer has an open channel on the
+// ccvconsumer module's port.
+func ccvChannelOpen(ctx context.Context, consumer *cosmos.CosmosChain) (bool, error) {
+	stdout, _, err := consumer.Exec(ctx, queryCommand(consumer, "ibc", "channel", "channels"), nil)
+	if err != nil {
+		return false, err
+	}
+	var response channelsQueryResponse
+	if err := json.Unmarshal(stdout, &response); err != nil {
+		return false, fmt.Errorf("failed to unmarshal channels: %w", err)
+	}
+	for _, channel := range response.Channels {
+		if channel.PortID == ccvConsumerPort && channel.State == "STATE_OPEN" {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Checks that channels, a consumer's channels, include an open CCV
+// channel, and returns it.
+func openCCVChannel(channels []ibc.ChannelOutput, chainID string) (*ibc.ChannelOutput, error) {
+	var found *ibc.ChannelOutput
+	for i, channel := range channels {
+		if channel.PortID != ccvConsumerPort {
+			continue
+		}
+		if channel.State == "STATE_OPEN" {
+			return &channels[i], nil
+		}
+		found = &channels[i]
+	}
+	if found == nil {
+		return nil, fmt.Errorf("%s has no CCV channel on port %s", chainID, ccvConsumerPort)
+	}
+	return nil, fmt.Errorf("the CCV channel %s on %s is %s, not open", found.ChannelID, chainID, found.State)
+}
+
+// The response to `query ibc connection end`.
+type connectionQueryResponse struct {
+	Connection struct {
+		ClientId string `json:"client_id"`
+	} `json:"connection"`
+}
+
+// Returned, wrapped, by `GetProviderClientID` while the consumer's CCV
+// channel is still being opened, or has yet to be.
+var errCCVNotEstablished = errors.New("CCV channel not established")
+
+// Returns the ID of the client that consumer tracks its provider
+// with, which every packet to and from the provider, VSC packets
+// included, is verified against. The ccvconsumer module of ICS v1.0
+// has no query for it, so it is read off the connection the CCV
+// channel runs over: the module refuses to open its channel over any
+// other client. Until the channel is open this returns an error
+// wrapping `errCCVNotEstablished`.
+func GetProviderClientID(ctx context.Context, consumer *cosmos.CosmosChain) (string, error) {
+	return providerClientID(ctx, consumer.Exec, consumer)
+}
+
+func providerClientID(ctx context.Context, exec execFunc, consumer *cosmos.CosmosChain) (string, error) {
+	chainID := consumer.Config().ChainID
+	var channels channelsQueryResponse
+	if err := queryJSON(ctx, exec, queryCommand(consumer, "ibc", "channel", "channels"), &channels); err != nil {
+		return "", err
+	}
+	channel, err := openCCVChannel(channels.Channels, chainID)
+	if err != nil {
+		for _, channel := range channels.Channels {
+			if channel.PortID == ccvConsumerPort && channel.State == "STATE_CLOSED" {
+				return "", err
+			}
+		}
+		return "", fmt.Errorf("%w: %s", errCCVNotEstablished, err)
+	}
+	if len(channel.ConnectionHops) == 0 {
+		return "", fmt.Errorf("the CCV channel %s on %s has no connection", channel.ChannelID, chainID)
+	}
+
+	var connection connectionQueryResponse
+	if err := queryJSON(ctx, exec, queryCommand(consumer, "ibc", "connection", "end", channel.ConnectionHops[0]), &connection); err != nil {
+		return "", err
+	}
+	if connection.Connection.ClientId == "" {
+		return "", fmt.Errorf("the CCV connection %s on %s has no client", channel.ConnectionHops[0], chainID)
+	}
+	return connection.Connection.ClientId, nil
+}
+
+// Checks that the consumer's CCV channel is open and that its client
+// of the provider is active. Interchain accounts stall, rather than
+// fail, when either breaks, so long tests call this between steps to
+// report the cause directly.
+func AssertCCVHealthy(ctx context.Context, consumer *cosmos.CosmosChain) error {
+	chainID := consumer.Config().ChainID
+	clientId, err := GetProviderClientID(ctx, consumer)
+	if err != nil {
+		return err
+	}
+	status, err := ClientStatus(ctx, consumer, clientId)
+	if err != nil {
+		return fmt.Errorf("failed to get status of %s's provider client %s: %w", chainID, clientId, err)
+	}
+	if status != "Active" {
+		return fmt.Errorf("%s's provider client %s is %s", chainID, clientId, status)
+	}
+	return nil
+}
+
+// Blocks until the consumer has established a CCV channel with its
+// provider, or until timeout elapses. Until the CCV channel is open,
+// the consumer can not receive validator set change (VSC) packets.
+func WaitForCCVChannel(ctx context.Context, consumer *cosmos.CosmosChain, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var lastErr error
+	for {
+		open, err := ccvChannelOpen(ctx, consumer)
+		if open {
+			return nil
+		}
+		if err != nil {
+			lastErr = err
+		}
+
+		select {
+		case <-ctx.Done():
+			if lastErr != nil {
+				return fmt.Errorf("timed out after %s waiting for CCV channel on %s: %w", timeout, consumer.Config().ChainID, lastErr)
+			}
+			return fmt.Errorf("timed out after %s waiting for CCV channel on %s", timeout, consumer.Config().ChainID)
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+// Creates a validator on the provider (from a random public key)
+// that will never do anything, triggering a validator set change
+// (VSC) packet. Eventually this validator will become jailed,
+// triggering another one. The validator is operated by keyName,
+// which must hold `throwawayValidatorCost`. Setup uses the faucet,
+// as no users can be funded on Neutron until the packet arrives.
+func TriggerVSC(ctx context.Context, provider *cosmos.CosmosChain, keyName string) error {
+	return createThrowawayValidator(ctx, provider, keyName, triggerVSCPubKey)
+}
+
+// The base64 encoded consensus key of the validator `TriggerVSC`
+// creates.
+const triggerVSCPubKey = "qwrYHaJ7sNHfYBR1nzDr851+wT4ed6p8BbwTeVhaHoA="
+
+// The least a throwaway validator's operator must hold: its self
+// delegation, plus the fee of creating it.
+const throwawayValidatorCost = 1_020_000
+
+// Creates a validator, operated by keyName, with the base64 encoded
+// ed25519 consensus key pubKey. Nothing signs blocks with the key, so
+// like the one `TriggerVSC` creates, the validator will be jailed.
+// Each operator can only create one validator, and each key can only
+// be used once.
+func createThrowawayValidator(ctx context.Context, provider *cosmos.CosmosChain, keyName, pubKey string) error {
+	cmd := []string{provider.Config().Bin, "tx", "staking", "create-validator",
+		"--amount", "1000000" + provider.Config().Denom,
+		"--pubkey", fmt.Sprintf(`{"@type":"/cosmos.crypto.ed25519.PubKey","key":%q}`, pubKey),
+		"--moniker", keyName,
+		"--commission-rate", "0.1",
+		"--commission-max-rate", "0.2",
+		"--commission-max-change-rate", "0.01",
+		"--min-self-delegation", "1000000",
+		"--node", provider.GetRPCAddress(),
+		"--home", provider.HomeDir(),
+		"--chain-id", provider.Config().ChainID,
+		"--from", keyName,
+		"--fees", "20000" + provider.Config().Denom,
+		"--keyring-backend", keyring.BackendTest,
+		"-y",
+	}
+	_, _, err := provider.Exec(ctx, cmd, nil)
+	return err
+}
+
+// Returns a new, random, base64 encoded ed25519 public key for
+// `createThrowawayValidator`.
+func randomConsensusKey() (string, error) {
+	pubKey, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(pubKey), nil
+}
+
+// The response to `query params subspace`. Value is the parameter's
+// amino JSON, so a string parameter is quoted.
+type paramQueryResponse struct {
+	Value string `json:"value"`
+}
+
+// Parses a string parameter from the output of `query params
+// subspace`.
+func parseStringParam(stdout []byte) (string, error) {
+	var response paramQueryResponse
+	if err := json.Unmarshal(stdout, &response); err != nil {
+		return "", fmt.Errorf("failed to unmarshal param: %w", err)
+	}
+	var value string
+	if err := json.Unmarshal([]byte(response.Value), &value); err != nil {
+		return "", fmt.Errorf("param is not a string: %q", response.Value)
+	}
+	return value, nil
+}
+
+// Queries the ccvconsumer module's string param key, for example
+// "SoftOptOutThreshold", through x/params. This works whatever
+// queries the consumer's version of ICS has. Integer params are
+// strings in amino JSON, so can be read this way too.
+func QueryConsumerParam(ctx context.Context, consumer *cosmos.CosmosChain, key string) (string, error) {
+	stdout, _, err := consumer.Exec(ctx, queryCommand(consumer, "params", "subspace", "ccvconsumer", key), nil)
+	if err != nil {
+		return "", err
+	}
+	return parseStringParam(stdout)
+}
+
+// Queries the soft opt-out threshold the consumer is running with.
+func QuerySoftOptOutThreshold(ctx context.Context, consumer *cosmos.CosmosChain) (string, error) {
+	return QueryConsumerParam(ctx, consumer, "SoftOptOutThreshold")
+}
+
+// Parses a list of strings parameter from the output of `query params
+// subspace`.
+func parseStringListParam(stdout []byte) ([]string, error) {
+	var response paramQueryResponse
+	if err := json.Unmarshal(stdout, &response); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal param: %w", err)
+	}
+	var value []string
+	if err := json.Unmarshal([]byte(response.Value), &value); err != nil {
+		return nil, fmt.Errorf("param is not a list of strings: %q", response.Value)
+	}
+	return value, nil
+}
+
+// Reports whether denom is one of the reward denoms the running
+// consumer sends the provider its share of. `setupNeutronGenesis`
+// sets these by path in genesis, which a consumer whose genesis
+// layout has moved on ignores without an error, so this reads them
+// back from the live chain.
+func AssertRewardDenom(ctx context.Context, consumer *cosmos.CosmosChain, denom string) (bool, error) {
+	stdout, _, err := consumer.Exec(ctx, queryCommand(consumer, "params", "subspace", "ccvconsumer", "RewardDenoms"), nil)
+	if err != nil {
+		return false, err
+	}
+	denoms, err := parseStringListParam(stdout)
+	if err != nil {
+		return false, err
+	}
+	for _, rewardDenom := range denoms {
+		if rewardDenom == denom {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// The error the consumer's ante handler rejects non-IBC messages
+// with until it has received its first VSC packet.
+const preCCVRejection = "tx contains unsupported message types"
+
+// Reports whether x/bank transfers are enabled on the consumer. This
+// simulates a send from the faucet to itself, so it works whatever
+// the consumer's bank params say and spends nothing.
+func TransfersEnabled(ctx context.Context, consumer *cosmos.CosmosChain) (bool, error) {
+	faucet, err := consumer.GetAddress(ctx, faucetKeyName)
+	if err != nil {
+		return false, fmt.Errorf("failed to get faucet address: %w", err)
+	}
+	faucetAddress, err := sdk.Bech32ifyAddressBytes(consumer.Config().Bech32Prefix, faucet)
+	if err != nil {
+		return false, err
+	}
+	cmd := []string{consumer.Config().Bin, "tx", "bank", "send",
+		faucetKeyName,
+		faucetAddress,
+		"1" + consumer.Config().Denom,
+		"--dry-run",
+		"--node", consumer.GetRPCAddress(),
+		"--home", consumer.HomeDir(),
+		"--chain-id", consumer.Config().ChainID,
+		"--keyring-backend", keyring.BackendTest,
+	}
+	_, stderr, err := consumer.Exec(ctx, cmd, nil)
+	if err == nil {
+		return true, nil
+	}
+	if strings.Contains(err.Error(), preCCVRejection) || strings.Contains(string(stderr), preCCVRejection) {
+		return false, nil
+	}
+	return false, fmt.Errorf("failed to simulate transfer: %w", err)
+}
+
+// Blocks until x/bank transfers are enabled on the consumer, or until
+// timeout elapses.
+func WaitForTransfersEnabled(ctx context.Context, consumer *cosmos.CosmosChain, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var lastErr error
+	for {
+		enabled, err := TransfersEnabled(ctx, consumer)
+		if enabled {
+			return nil
+		}
+		if err != nil {
+			lastErr = err
+		}
+
+		select {
+		case <-ctx.Done():
+			if lastErr != nil {
+				return fmt.Errorf("timed out after %s waiting for transfers on %s: %w", timeout, consumer.Config().ChainID, lastErr)
+			}
+			return fmt.Errorf("timed out after %s waiting for transfers on %s", timeout, consumer.Config().ChainID)
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+// Tests that transfers on Neutron are disabled until the provider
+// sends it a VSC packet, and enabled afterwards.
+func TestTransfersEnabled(t *testing.T) {
+	env := setupICSTestWithConfig(t, icsTestConfig{skipVSC: true})
+	ctx := env.ctx
+
+	enabled, err := TransfersEnabled(ctx, env.neutron)
+	require.NoError(t, err)
+	require.False(t, enabled, "transfers should be disabled before the first VSC packet")
+
+	// The provider has no such restriction.
+	enabled, err = TransfersEnabled(ctx, env.atom)
+	require.NoError(t, err)
+	require.True(t, enabled)
+
+	err = TriggerVSC(ctx, env.atom, faucetKeyName)
+	require.NoError(t, err, "failed to trigger VSC packet")
+	err = WaitForTransfersEnabled(ctx, env.neutron, 2*time.Minute)
+	require.NoError(t, err)
+}
+
+// Tests that an interchain account keeps working while the provider's
+// validator set changes several times. Each change is sent to Neutron
+// in a VSC packet, and the relayer updates the ICS clients in
+// between. A send is made after each change, and must land.
+func TestICAThroughValidatorChurn(t *testing.T) {
+	const churns = 3
+	env := setupICSTest(t)
+	ctx, atom, neutron := env.ctx, env.atom, env.neutron
+
+	contract := deployICAContract(t, env)
+	icaAddress := registerICA(t, env, contract, "test")
+	fundICA(t, env, icaAddress, 1_000_000)
+
+	operators := ibctest.GetAndFundTestUsers(t, ctx, "operator", 2*throwawayValidatorCost, atom, atom, atom)
+	require.Len(t, operators, churns)
+	atomUserAddress := env.atomUser.Bech32Address(atom.Config().Bech32Prefix)
+	for i, operator := range operators {
+		pubKey, err := randomConsensusKey()
+		require.NoError(t, err)
+		err = createThrowawayValidator(ctx, atom, operator.KeyName, pubKey)
+		require.NoError(t, err, "failed to create validator %d", i)
+		err = testutil.WaitForBlocks(ctx, timing.vscBlocks, atom, neutron)
+		require.NoError(t, err)
+		require.NoError(t, AssertCCVHealthy(ctx, neutron), "after change %d", i)
+
+		before, err := queryBalance(ctx, atom, atomUserAddress, atom.Config().Denom)
+		require.NoError(t, err)
+		sequence, err := SubmitICASend(ctx, neutron, env.neutronUser.KeyName, contract, "test", atomUserAddress, 1_000, atom.Config().Denom, 0)
+		require.NoError(t, err, "failed to submit ICA send after change %d", i)
+		result, err := WaitForAcknowledgement(ctx, neutron, contract, "test", sequence, 2*time.Minute)
+		require.NoError(t, err)
+		require.NotNil(t, result.Success, "send after change %d failed: %+v", i, result)
+		after, err := queryBalance(ctx, atom, atomUserAddress, atom.Config().Denom)
+		require.NoError(t, err)
+		require.Equal(t, before+1_000, after, "send after change %d did not land", i)
+	}
+}
+
+// Tests an ICA packet sent straight after a validator set change.
+// The VSC packet makes the relayer update the ICS clients at the same
+// time as it relays the ICA packet on the connection they track, so
+// this catches the two getting in each other's way. The
+// acknowledgement is polled for rather than waited on, so a stall
+// shows up as a timeout.
+func TestICAAfterVSC(t *testing.T) {
+	env := setupICSTest(t)
+	ctx, atom, neutron := env.ctx, env.atom, env.neutron
+
+	contract := deployICAContract(t, env)
+	icaAddress := registerICA(t, env, contract, "test")
+	fundICA(t, env, icaAddress, 1_000_000)
+
+	// Setup's VSC used `TriggerVSC`'s key, and a key can only be
+	// used once.
+	operator := ibctest.GetAndFundTestUsers(t, ctx, "operator", 2*throwawayValidatorCost, atom)[0]
+	pubKey, err := randomConsensusKey()
+	require.NoError(t, err)
+	err = createThrowawayValidator(ctx, atom, operator.KeyName, pubKey)
+	require.NoError(t, err, "failed to trigger VSC packet")
+
+	atomUserAddress := env.atomUser.Bech32Address(atom.Config().Bech32Prefix)
+	before, err := queryBalance(ctx, atom, atomUserAddress, atom.Config().Denom)
+	require.NoError(t, err)
+	sequence, err := SubmitICASend(ctx, neutron, env.neutronUser.KeyName, contract, "test", atomUserAddress, 1_000, atom.Config().Denom, 0)
+	require.NoError(t, err, "failed to submit ICA send")
+	result, err := WaitForAcknowledgement(ctx, neutron, contract, "test", sequence, 2*time.Minute)
+	require.NoError(t, err, "the send stalled after the VSC")
+	require.NotNil(t, result.Success, "send failed: %+v", result)
+	after, err := queryBalance(ctx, atom, atomUserAddress, atom.Config().Denom)
+	require.NoError(t, err)
+	require.Equal(t, before+1_000, after)
+
+	require.NoError(t, AssertCCVHealthy(ctx, neutron))
+}
+
+func TestOpenCCVChannel(t *testing.T) {
+	channels := []ibc.ChannelOutput{
+		{State: "STATE_OPEN", PortID: "transfer", ChannelID: "channel-1"},
+	}
+	_, err := openCCVChannel(channels, "neutron-2")
+	require.EqualError(t, err, "neutron-2 has no CCV channel on port consumer")
+
+	channels = append(channels, ibc.ChannelOutput{State: "STATE_CLOSED", PortID: ccvConsumerPort, ChannelID: "channel-0", ConnectionHops: []string{"connection-0"}})
+	_, err = openCCVChannel(channels, "neutron-2")
+	require.EqualError(t, err, "the CCV channel channel-0 on neutron-2 is STATE_CLOSED, not open")
+
+	channels[1].State = "STATE_OPEN"
+	channel, err := openCCVChannel(channels, "neutron-2")
+	require.NoError(t, err)
+	require.Equal(t, "channel-0", channel.ChannelID)
+}
+
+// Tests that the consumer's provider client is a client of the
+// provider.
+func TestProviderClientID(t *testing.T) {
+	env := setupICSTest(t)
+	ctx, atom, neutron := env.ctx, env.atom, env.neutron
+
+	clientId, err := GetProviderClientID(ctx, neutron)
+	require.NoError(t, err)
+	require.NotEmpty(t, clientId)
+
+	clients, err := GetClients(ctx, env.relayer, env.eRep, neutron.Config().ChainID)
+	require.NoError(t, err)
+	var tracked string
+	for _, client := range clients {
+		if client.ClientID == clientId {
+			tracked = client.ClientState.ChainID
+		}
+	}
+	require.Equal(t, atom.Config().ChainID, tracked, "provider client %s should track atom", clientId)
+}
+
+func TestProviderClientIDStates(t *testing.T) {
+	ctx := context.Background()
+	consumer := offlineChain(ibc.ChainConfig{Name: "neutron", ChainID: "neutron-2", Bin: "neutrond"})
+	// Answers the channels query with channels, and the connection
+	// query with a connection on 07-tendermint-0.
+	exec := func(channels string) execFunc {
+		return func(ctx context.Context, cmd []string, env []string) ([]byte, []byte, error) {
+			if cmd[3] == "connection" {
+				return []byte(`{"connection":{"client_id":"07-tendermint-0"}}`), nil, nil
+			}
+			return []byte(`{"channels":[` + channels + `]}`), nil, nil
+		}
+	}
+	ccvChannel := func(state string) string {
+		return `{"state":"` + state + `","port_id":"consumer","channel_id":"channel-0","connection_hops":["connection-0"]}`
+	}
+
+	// Before the relayer starts the handshake there is no channel,
+	// and during it the channel is in INIT.
+	_, err := providerClientID(ctx, exec(""), consumer)
+	require.ErrorIs(t, err, errCCVNotEstablished)
+	_, err = providerClientID(ctx, exec(ccvChannel("STATE_INIT")), consumer)
+	require.ErrorIs(t, err, errCCVNotEstablished)
+
+	clientId, err := providerClientID(ctx, exec(ccvChannel("STATE_OPEN")), consumer)
+	require.NoError(t, err)
+	require.Equal(t, "07-tendermint-0", clientId)
+
+	// A closed channel is broken, not pending.
+	_, err = providerClientID(ctx, exec(ccvChannel("STATE_CLOSED")), consumer)
+	require.Error(t, err)
+	require.NotErrorIs(t, err, errCCVNotEstablished)
+}
+
+// Tests that the soft opt-out threshold set in Neutron's genesis is
+// the one Neutron runs with. A typo in the genesis path would leave
+// the module's default in place without any error.
+func TestSoftOptOutThreshold(t *testing.T) {
+	env := setupICSTestWithConfig(t, icsTestConfig{neutronSoftOptOutThreshold: "0.1", skipVSC: true})
+
+	threshold, err := QuerySoftOptOutThreshold(env.ctx, env.neutron)
+	require.NoError(t, err)
+	require.Equal(t, sdk.MustNewDecFromStr("0.1"), sdk.MustNewDecFromStr(threshold))
+}
+
+// Tests that Neutron runs with the reward denoms its genesis was
+// given: its own denom, but not the provider's reward denom.
+func TestRewardDenoms(t *testing.T) {
+	env := setupICSTestWithConfig(t, icsTestConfig{skipVSC: true})
+
+	ok, err := AssertRewardDenom(env.ctx, env.neutron, defaultNeutronDenom)
+	require.NoError(t, err)
+	require.True(t, ok, "%s should be a reward denom", defaultNeutronDenom)
+
+	ok, err = AssertRewardDenom(env.ctx, env.neutron, env.atom.Config().Denom)
+	require.NoError(t, err)
+	require.False(t, ok, "%s is only a provider reward denom", env.atom.Config().Denom)
+}
+
+func TestParseStringListParam(t *testing.T) {
+	value, err := parseStringListParam([]byte(`{"subspace":"ccvconsumer","key":"RewardDenoms","value":"[\"untrn\"]"}`))
+	require.NoError(t, err)
+	require.Equal(t, []string{"untrn"}, value)
+
+	value, err = parseStringListParam([]byte(`{"subspace":"ccvconsumer","key":"RewardDenoms","value":"[]"}`))
+	require.NoError(t, err)
+	require.Empty(t, value)
+
+	_, err = parseStringListParam([]byte(`{"subspace":"ccvconsumer","key":"SoftOptOutThreshold","value":"\"0.05\""}`))
+	require.Error(t, err)
+}
+
+func TestParseStringParam(t *testing.T) {
+	value, err := parseStringParam([]byte(`{"subspace":"ccvconsumer","key":"SoftOptOutThreshold","value":"\"0.05\""}`))
+	require.NoError(t, err)
+	require.Equal(t, "0.05", value)
+
+	_, err = parseStringParam([]byte(`{"subspace":"ccvconsumer","key":"Enabled","value":"true"}`))
+	require.Error(t, err)
+}
+
+// Tests that `WaitForCCVChannel` gives up on a chain that is never
+// linked to a provider. This spins up a lone gaia chain, which has no
+// ccvconsumer module and so can never have a CCV channel.
+func TestWaitForCCVChannelTimeout(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping in short mode")
+	}
+	requireDocker(t)
+
+	t.Parallel()
+	acquireInterchainSlot(t)
+
+	ctx := context.Background()
+
+	cf := ibctest.NewBuiltinChainFactory(zaptest.NewLogger(t), []*ibctest.ChainSpec{
+		{Name: "gaia", Version: "v9.1.0", ChainConfig: ibc.ChainConfig{GasAdjustment: 1.5}},
+	})
+	chains, err := cf.Chains(t.Name())
+	require.NoError(t, err)
+	atom := chains[0].(*cosmos.CosmosChain)
+
+	client, network := ibctest.DockerSetup(t)
+	ic := ibctest.NewInterchain().AddChain(atom)
+	eRep := testreporter.NewNopReporter().RelayerExecReporter(t)
+	err = ic.Build(ctx, eRep, ibctest.InterchainBuildOptions{
+		TestName:  t.Name(),
+		Client:    client,
+		NetworkID: network,
+	})
+	require.NoError(t, err, "failed to build interchain")
+
+	err = WaitForCCVChannel(ctx, atom, 10*time.Second)
+	require.ErrorContains(t, err, "timed out")
+}
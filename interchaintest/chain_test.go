@@ -0,0 +1,626 @@
+package ibc_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	sdk "github.com/cosmos/cosmos-sdk/types"
+	"github.com/strangelove-ventures/interchaintest/v3/chain/cosmos"
+	"github.com/strangelove-ventures/interchaintest/v3/ibc"
+	"github.com/strangelove-ventures/interchaintest/v3/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+// How often polling helpers re-check the state they are waiting on.
+const pollInterval = time.Second
+
+// Builds a query command against chain. For example, to run `gaiad
+// query bank balances <address>` pass ("bank", "balances",
+// <address>). The query's output is JSON.
+func queryCommand(chain *cosmos.CosmosChain, args ...string) []string {
+	cmd := append([]string{chain.Config().Bin, "query"}, args...)
+	return append(cmd,
+		"--output", "json",
+		"--node", chain.GetRPCAddress(),
+		"--home", chain.HomeDir(),
+		"--chain-id", chain.Config().ChainID,
+	)
+}
+
+// Runs a command, as `cosmos.CosmosChain.Exec` does. Tests swap in a
+// fake to feed query helpers fixtures.
+type execFunc func(ctx context.Context, cmd []string, env []string) (stdout, stderr []byte, err error)
+
+// Runs `query <args...>` on chain and unmarshals its JSON output into
+// out. For example, to decode `gaiad query bank balances <address>`
+// pass ("bank", "balances", <address>) and a `*balancesQueryResponse`.
+func QueryHostJSON(ctx context.Context, chain *cosmos.CosmosChain, args []string, out interface{}) error {
+	return queryJSON(ctx, chain.Exec, queryCommand(chain, args...), out)
+}
+
+func queryJSON(ctx context.Context, exec execFunc, cmd []string, out interface{}) error {
+	stdout, _, err := exec(ctx, cmd, nil)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(stdout, out); err != nil {
+		// Name the query by its binary, "query", and module.
+		name := cmd
+		if len(name) > 3 {
+			name = name[:3]
+		}
+		return fmt.Errorf("failed to unmarshal output of %s: %w", strings.Join(name, " "), err)
+	}
+	return nil
+}
+
+// The subset of a transaction response that we care about. This is
+// what `neutrond tx ... --output json` prints.
+type txResponse struct {
+	TxHash string `json:"txhash"`
+	Code   uint32 `json:"code"`
+	RawLog string `json:"raw_log"`
+	// Per-message logs, including the events each message
+	// emitted. Only populated in block mode.
+	Logs sdk.ABCIMessageLogs `json:"logs"`
+}
+
+// Returns the value of the first attribute with key on the first
+// event of type eventType, and false if there is no such attribute.
+func (r *txResponse) attribute(eventType, key string) (string, bool) {
+	for _, log := range r.Logs {
+		for _, event := range log.Events {
+			if event.Type != eventType {
+				continue
+			}
+			for _, attribute := range event.Attributes {
+				if attribute.Key == key {
+					return attribute.Value, true
+				}
+			}
+		}
+	}
+	return "", false
+}
+
+// Runs a transaction command on chain and returns an error if either
+// the command fails or the transaction is rejected. The command
+// should broadcast in block mode (`-b block`) so that the response
+// contains the result of executing the transaction, not only of
+// adding it to the mempool.
+func execTx(ctx context.Context, chain *cosmos.CosmosChain, cmd []string) (*txResponse, error) {
+	stdout, _, err := chain.Exec(ctx, cmd, nil)
+	if err != nil {
+		return nil, err
+	}
+	var response txResponse
+	if err := json.Unmarshal(stdout, &response); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal tx response: %w", err)
+	}
+	if response.Code != 0 {
+		return &response, fmt.Errorf("transaction %s failed with code %d: %s", response.TxHash, response.Code, response.RawLog)
+	}
+	return &response, nil
+}
+
+// Returned by `ExecExpectError` when the command it was given
+// succeeds.
+var errCommandSucceeded = errors.New("command succeeded")
+
+// Runs cmd on chain, expecting it to fail with wantSubstr somewhere
+// in its error or output. A transaction the chain rejects with a
+// non-zero code counts as failing, even though the command itself
+// exits cleanly. Returns an error wrapping `errCommandSucceeded` if
+// the command succeeds, and an error quoting the output if the
+// command fails some other way.
+func ExecExpectError(ctx context.Context, chain *cosmos.CosmosChain, cmd []string, wantSubstr string) error {
+	stdout, stderr, err := chain.Exec(ctx, cmd, nil)
+	return checkExpectedError(stdout, stderr, err, wantSubstr)
+}
+
+// Checks the result of a command `ExecExpectError` ran.
+func checkExpectedError(stdout, stderr []byte, err error, wantSubstr string) error {
+	if err == nil {
+		var response txResponse
+		if json.Unmarshal(stdout, &response) != nil || response.Code == 0 {
+			return fmt.Errorf("%w, expected an error containing %q: %s", errCommandSucceeded, wantSubstr, stdout)
+		}
+		err = fmt.Errorf("transaction %s failed with code %d", response.TxHash, response.Code)
+	}
+	output := strings.Join([]string{err.Error(), string(stderr), string(stdout)}, "\n")
+	if !strings.Contains(output, wantSubstr) {
+		return fmt.Errorf("expected an error containing %q, got: %s", wantSubstr, output)
+	}
+	return nil
+}
+
+// The fee parts of the response to `query tx`.
+type txQueryResponse struct {
+	Tx struct {
+		AuthInfo struct {
+			Fee struct {
+				Amount   sdk.Coins `json:"amount"`
+				GasLimit string    `json:"gas_limit"`
+			} `json:"fee"`
+		} `json:"auth_info"`
+	} `json:"tx"`
+}
+
+// Parses the fee and gas limit of a transaction from the output of
+// `query tx`.
+func parseTxFee(stdout []byte) (sdk.Coins, uint64, error) {
+	var response txQueryResponse
+	if err := json.Unmarshal(stdout, &response); err != nil {
+		return nil, 0, fmt.Errorf("failed to unmarshal tx: %w", err)
+	}
+	fee := response.Tx.AuthInfo.Fee
+	gasLimit, err := strconv.ParseUint(fee.GasLimit, 10, 64)
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid gas limit %q: %w", fee.GasLimit, err)
+	}
+	return fee.Amount, gasLimit, nil
+}
+
+// Queries the fee that the transaction with txHash paid, and its gas
+// limit. Fails until the transaction is included in a block.
+func QueryTxFee(ctx context.Context, chain *cosmos.CosmosChain, txHash string) (sdk.Coins, uint64, error) {
+	stdout, _, err := chain.Exec(ctx, queryCommand(chain, "tx", txHash), nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	return parseTxFee(stdout)
+}
+
+// The fee the Cosmos SDK computes from `--gas-prices` for a
+// transaction with gasLimit: each gas price times the limit, rounded
+// up.
+func feeForGas(gasPrices string, gasLimit uint64) (sdk.Coins, error) {
+	prices, err := sdk.ParseDecCoins(gasPrices)
+	if err != nil {
+		return nil, fmt.Errorf("invalid gas prices %q: %w", gasPrices, err)
+	}
+	limit := sdk.NewDec(int64(gasLimit))
+	fee := sdk.NewCoins()
+	for _, price := range prices {
+		fee = fee.Add(sdk.NewCoin(price.Denom, price.Amount.Mul(limit).Ceil().RoundInt()))
+	}
+	return fee, nil
+}
+
+// Matches the gas estimate a Cosmos SDK CLI prints to stderr for a
+// transaction run with `--dry-run`.
+var gasEstimatePattern = regexp.MustCompile(`gas estimate: (\d+)`)
+
+// Parses the gas estimate from the stderr of a `--dry-run`
+// transaction.
+func parseGasEstimate(stderr []byte) (uint64, error) {
+	match := gasEstimatePattern.FindSubmatch(stderr)
+	if match == nil {
+		return 0, fmt.Errorf("no gas estimate in output: %s", stderr)
+	}
+	return strconv.ParseUint(string(match[1]), 10, 64)
+}
+
+// Simulates the transaction command cmd instead of broadcasting it,
+// and returns the gas it is estimated to use. cmd is a command as
+// would be passed to `execTx`. The estimate is scaled by the
+// command's `--gas-adjustment` flag, if it has one, just like `--gas
+// auto` would scale it.
+func SimulateICATx(ctx context.Context, chain *cosmos.CosmosChain, cmd []string) (uint64, error) {
+	simulate := append(append([]string{}, cmd...), "--dry-run")
+	_, stderr, err := chain.Exec(ctx, simulate, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to simulate transaction: %w", err)
+	}
+	return parseGasEstimate(stderr)
+}
+
+// Runs a transaction command on chain that sends an IBC packet, and
+// returns the sequence number of that packet. Callers can use this to
+// wait for the acknowledgement of that exact packet. Like `execTx`,
+// the command should broadcast in block mode.
+func ExecuteAndGetSequence(ctx context.Context, chain *cosmos.CosmosChain, cmd []string) (uint64, error) {
+	response, err := execTx(ctx, chain, cmd)
+	if err != nil {
+		return 0, err
+	}
+	sequence, ok := response.attribute("send_packet", "packet_sequence")
+	if !ok {
+		return 0, fmt.Errorf("transaction %s did not send a packet", response.TxHash)
+	}
+	return strconv.ParseUint(sequence, 10, 64)
+}
+
+// The response to `query bank balances`.
+type balancesQueryResponse struct {
+	Balances []struct {
+		Denom  string `json:"denom"`
+		Amount string `json:"amount"`
+	} `json:"balances"`
+}
+
+// Returns the balances in r keyed by denom.
+func (r *balancesQueryResponse) byDenom() (map[string]int64, error) {
+	balances := make(map[string]int64, len(r.Balances))
+	for _, balance := range r.Balances {
+		amount, err := strconv.ParseInt(balance.Amount, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid amount of %s: %w", balance.Denom, err)
+		}
+		balances[balance.Denom] = amount
+	}
+	return balances, nil
+}
+
+// Queries the amount of denom that address holds on chain.
+func queryBalance(ctx context.Context, chain *cosmos.CosmosChain, address, denom string) (int64, error) {
+	balances, err := queryBalances(ctx, chain, address)
+	if err != nil {
+		return 0, err
+	}
+	return balances[denom], nil
+}
+
+// Polls until address holds at least amount of denom on chain, or
+// until timeout elapses, and returns the balance. Use this to wait
+// for transfers to arrive rather than waiting a fixed number of
+// blocks.
+func WaitForBalance(ctx context.Context, chain *cosmos.CosmosChain, address, denom string, amount int64, timeout time.Duration) (int64, error) {
+	return waitForBalance(ctx, func(ctx context.Context) (int64, error) {
+		return queryBalance(ctx, chain, address, denom)
+	}, address, denom, amount, timeout)
+}
+
+func waitForBalance(ctx context.Context, query func(context.Context) (int64, error), address, denom string, amount int64, timeout time.Duration) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var last int64
+	for {
+		balance, err := query(ctx)
+		if err == nil && balance >= amount {
+			return balance, nil
+		}
+		if err == nil {
+			last = balance
+		}
+
+		select {
+		case <-ctx.Done():
+			return 0, fmt.Errorf("timed out after %s waiting for %s to hold %d%s, last balance: %d", timeout, address, amount, denom, last)
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+// Queries every balance address holds on chain, keyed by denom.
+func queryBalances(ctx context.Context, chain *cosmos.CosmosChain, address string) (map[string]int64, error) {
+	var response balancesQueryResponse
+	if err := QueryHostJSON(ctx, chain, []string{"bank", "balances", address}, &response); err != nil {
+		return nil, err
+	}
+	return response.byDenom()
+}
+
+// The response to `query ibc client status`.
+type clientStatusQueryResponse struct {
+	Status string `json:"status"`
+}
+
+// Queries the status of clientId on chain. This is one of "Active",
+// "Frozen", "Expired", or "Unknown".
+func ClientStatus(ctx context.Context, chain *cosmos.CosmosChain, clientId string) (string, error) {
+	stdout, _, err := chain.Exec(ctx, queryCommand(chain, "ibc", "client", "status", clientId), nil)
+	if err != nil {
+		return "", err
+	}
+	var response clientStatusQueryResponse
+	if err := json.Unmarshal(stdout, &response); err != nil {
+		return "", fmt.Errorf("failed to unmarshal client status: %w", err)
+	}
+	return response.Status, nil
+}
+
+// The response to `query ibc client state`.
+type clientStateQueryResponse struct {
+	ClientState struct {
+		ChainID        string `json:"chain_id"`
+		TrustingPeriod string `json:"trusting_period"`
+	} `json:"client_state"`
+}
+
+// Queries the trusting period of clientId on chain. Only tendermint
+// clients have a trusting period.
+func ClientTrustingPeriod(ctx context.Context, chain *cosmos.CosmosChain, clientId string) (time.Duration, error) {
+	stdout, _, err := chain.Exec(ctx, queryCommand(chain, "ibc", "client", "state", clientId), nil)
+	if err != nil {
+		return 0, err
+	}
+	var response clientStateQueryResponse
+	if err := json.Unmarshal(stdout, &response); err != nil {
+		return 0, fmt.Errorf("failed to unmarshal client state: %w", err)
+	}
+	if response.ClientState.TrustingPeriod == "" {
+		return 0, fmt.Errorf("client %s has no trusting period", clientId)
+	}
+	return time.ParseDuration(response.ClientState.TrustingPeriod)
+}
+
+// Polls until clientId on chain has status, or until timeout elapses.
+func WaitForClientStatus(ctx context.Context, chain *cosmos.CosmosChain, clientId, status string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var last string
+	for {
+		current, err := ClientStatus(ctx, chain, clientId)
+		if err == nil && current == status {
+			return nil
+		}
+		if err == nil {
+			last = current
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("timed out after %s waiting for client %s to be %s, last status: %q", timeout, clientId, status, last)
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+// Returns the height of chain's latest block.
+func CurrentHeight(ctx context.Context, chain *cosmos.CosmosChain) (int64, error) {
+	height, err := chain.Height(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get %s height: %w", chain.Config().ChainID, err)
+	}
+	return int64(height), nil
+}
+
+// Returns how many blocks chain has produced since sinceHeight, as
+// returned by `CurrentHeight`. Asserting on this rather than on time
+// passing holds however fast the machine running the chain is.
+func BlocksSince(ctx context.Context, chain *cosmos.CosmosChain, sinceHeight int64) (int64, error) {
+	height, err := CurrentHeight(ctx, chain)
+	if err != nil {
+		return 0, err
+	}
+	return blocksBetween(sinceHeight, height)
+}
+
+func blocksBetween(sinceHeight, height int64) (int64, error) {
+	if sinceHeight > height {
+		return 0, fmt.Errorf("height %d is ahead of the chain, which is at %d", sinceHeight, height)
+	}
+	return height - sinceHeight, nil
+}
+
+// Stops and then restarts every node of chain, returning once the
+// chain is producing blocks again. Node state lives in docker volumes
+// that outlive the node containers, so it survives the restart.
+//
+// Relayers connected to chain should be stopped before calling this
+// and restarted afterwards, as their connections to the old
+// containers are not re-established on their own.
+func RestartChain(ctx context.Context, chain *cosmos.CosmosChain) error {
+	if err := chain.StopAllNodes(ctx); err != nil {
+		return fmt.Errorf("failed to stop %s nodes: %w", chain.Config().ChainID, err)
+	}
+	if err := chain.StartAllNodes(ctx); err != nil {
+		return fmt.Errorf("failed to start %s nodes: %w", chain.Config().ChainID, err)
+	}
+	return testutil.WaitForBlocks(ctx, 2, chain)
+}
+
+// Checks that each of users holds exactly amount of the native denom
+// of the chain at the same index in chains.
+func checkFunded(ctx context.Context, amount int64, users []*ibc.Wallet, chains ...ibc.Chain) error {
+	if len(users) != len(chains) {
+		return fmt.Errorf("got %d users for %d chains", len(users), len(chains))
+	}
+	for i, chain := range chains {
+		address := users[i].Bech32Address(chain.Config().Bech32Prefix)
+		balance, err := chain.GetBalance(ctx, address, chain.Config().Denom)
+		if err != nil {
+			return fmt.Errorf("failed to get balance of %s on %s: %w", address, chain.Config().ChainID, err)
+		}
+		if balance != amount {
+			return fmt.Errorf("%s on %s has %d%s, expected %d%s", address, chain.Config().ChainID, balance, chain.Config().Denom, amount, chain.Config().Denom)
+		}
+	}
+	return nil
+}
+
+// Asserts that the users returned by `ibctest.GetAndFundTestUsers`
+// received amount. The faucet pays the fees for funding, so the
+// balances should match amount exactly. Call this before the users
+// send any transactions.
+func AssertFunded(t *testing.T, ctx context.Context, amount int64, users []*ibc.Wallet, chains ...ibc.Chain) {
+	t.Helper()
+	require.NoError(t, checkFunded(ctx, amount, users, chains...), "test users were not funded")
+}
+
+// A chain that reports the same balance for every address. Any
+// method other than `Config` and `GetBalance` panics, as the embedded
+// interface is nil.
+type fixedBalanceChain struct {
+	ibc.Chain
+	balance int64
+}
+
+func (c fixedBalanceChain) Config() ibc.ChainConfig {
+	return ibc.ChainConfig{ChainID: "fixed-1", Bech32Prefix: "cosmos", Denom: "ustake"}
+}
+
+func (c fixedBalanceChain) GetBalance(ctx context.Context, address string, denom string) (int64, error) {
+	return c.balance, nil
+}
+
+func TestCheckFunded(t *testing.T) {
+	ctx := context.Background()
+	users := []*ibc.Wallet{{Address: "cosmos1user"}}
+
+	require.NoError(t, checkFunded(ctx, 100, users, fixedBalanceChain{balance: 100}))
+	require.ErrorContains(t, checkFunded(ctx, 100, users, fixedBalanceChain{balance: 0}), "has 0ustake, expected 100ustake")
+	require.ErrorContains(t, checkFunded(ctx, 100, users), "got 1 users for 0 chains")
+}
+
+func TestParseGasEstimate(t *testing.T) {
+	gas, err := parseGasEstimate([]byte("gas estimate: 184322\n"))
+	require.NoError(t, err)
+	require.Equal(t, uint64(184322), gas)
+
+	_, err = parseGasEstimate([]byte("Error: rpc error: code = Unknown"))
+	require.ErrorContains(t, err, "no gas estimate")
+}
+
+func TestBalancesByDenom(t *testing.T) {
+	ctx := context.Background()
+	stdout, err := os.ReadFile("testdata/bank_balances.json")
+	require.NoError(t, err)
+
+	var cmd []string
+	query := []string{"gaiad", "query", "bank", "balances", "cosmos1qypqxpq9qcrsszg2pvxq6rs0zqg3yyc5lzv7xu"}
+	var response balancesQueryResponse
+	require.NoError(t, queryJSON(ctx, fixtureExec(stdout, &cmd), query, &response))
+	balances, err := response.byDenom()
+	require.NoError(t, err)
+	require.Equal(t, map[string]int64{
+		"ibc/27394FB092D2ECCD56123C74F36E4C1F926001CEADA9CA97EA622B25F41E5EB2": 500,
+		"untrn": 999_999_876_543,
+	}, balances)
+	require.Zero(t, balances["uatom"], "a denom the address doesn't hold should have a zero balance")
+
+	err = queryJSON(ctx, fixtureExec([]byte("not json"), &cmd), query, &response)
+	require.Error(t, err)
+
+	response = balancesQueryResponse{}
+	require.NoError(t, queryJSON(ctx, fixtureExec([]byte(`{"balances":[{"denom":"untrn","amount":"lots"}]}`), &cmd), query, &response))
+	_, err = response.byDenom()
+	require.ErrorContains(t, err, "invalid amount of untrn")
+}
+
+func TestWaitForBalance(t *testing.T) {
+	ctx := context.Background()
+
+	// Funds arrive on the third query.
+	queries := 0
+	balance, err := waitForBalance(ctx, func(context.Context) (int64, error) {
+		queries++
+		if queries < 3 {
+			return 0, nil
+		}
+		return 1_000_000, nil
+	}, "cosmos1ica", "uatom", 1_000_000, time.Minute)
+	require.NoError(t, err)
+	require.Equal(t, int64(1_000_000), balance)
+	require.Equal(t, 3, queries)
+
+	// Funds that never arrive time out, reporting the last balance
+	// seen, even if later queries fail.
+	queries = 0
+	_, err = waitForBalance(ctx, func(context.Context) (int64, error) {
+		queries++
+		if queries > 1 {
+			return 0, errors.New("connection refused")
+		}
+		return 500, nil
+	}, "cosmos1ica", "uatom", 1_000_000, 1500*time.Millisecond)
+	require.ErrorContains(t, err, "timed out after 1.5s waiting for cosmos1ica to hold 1000000uatom, last balance: 500")
+}
+
+func TestParseTxFee(t *testing.T) {
+	stdout, err := os.ReadFile("testdata/query_tx.json")
+	require.NoError(t, err)
+
+	fee, gasLimit, err := parseTxFee(stdout)
+	require.NoError(t, err)
+	require.Equal(t, uint64(276483), gasLimit)
+	require.Equal(t, sdk.NewCoins(sdk.NewInt64Coin("untrn", 6913)), fee)
+
+	// 276483 * 0.025 = 6912.075, which rounds up.
+	expected, err := feeForGas("0.025untrn", gasLimit)
+	require.NoError(t, err)
+	require.Equal(t, fee, expected)
+
+	free, err := feeForGas("0.0untrn", gasLimit)
+	require.NoError(t, err)
+	require.True(t, free.IsZero())
+}
+
+func TestBlocksBetween(t *testing.T) {
+	blocks, err := blocksBetween(10, 15)
+	require.NoError(t, err)
+	require.Equal(t, int64(5), blocks)
+
+	blocks, err = blocksBetween(15, 15)
+	require.NoError(t, err)
+	require.Zero(t, blocks)
+
+	_, err = blocksBetween(16, 15)
+	require.Error(t, err)
+}
+
+func TestCheckExpectedError(t *testing.T) {
+	failed := errors.New("exit code 1: Error: rpc error: code = Unknown desc = connection not found")
+
+	require.NoError(t, checkExpectedError(nil, nil, failed, "connection not found"))
+	// The message may only be in the output.
+	require.NoError(t, checkExpectedError(nil, []byte("Error: active channel already set for this owner"), errors.New("exit code 1"), "active channel already set"))
+
+	err := checkExpectedError(nil, nil, failed, "insufficient funds")
+	require.ErrorContains(t, err, "connection not found", "a mismatch should quote the output")
+	require.NotErrorIs(t, err, errCommandSucceeded)
+
+	err = checkExpectedError([]byte(`{"code":0}`), nil, nil, "connection not found")
+	require.ErrorIs(t, err, errCommandSucceeded)
+
+	// A rejected transaction exits cleanly, with the error in its
+	// raw log.
+	rejected := []byte(`{"txhash":"ABC","code":5,"raw_log":"failed to execute message; message index: 0: active channel already set for this owner"}`)
+	require.NoError(t, checkExpectedError(rejected, nil, nil, "active channel already set"))
+	err = checkExpectedError(rejected, nil, nil, "connection not found")
+	require.ErrorContains(t, err, "active channel already set", "a mismatch should quote the output")
+	require.NotErrorIs(t, err, errCommandSucceeded)
+}
+
+// Returns an `execFunc` that records the command it is given and
+// prints stdout.
+func fixtureExec(stdout []byte, cmd *[]string) execFunc {
+	return func(ctx context.Context, got []string, env []string) ([]byte, []byte, error) {
+		*cmd = got
+		return stdout, nil, nil
+	}
+}
+
+func TestQueryJSON(t *testing.T) {
+	ctx := context.Background()
+	stdout, err := os.ReadFile("testdata/bank_balances.json")
+	require.NoError(t, err)
+
+	var cmd []string
+	query := []string{"gaiad", "query", "bank", "balances", "cosmos1qypqxpq9qcrsszg2pvxq6rs0zqg3yyc5lzv7xu"}
+	var response balancesQueryResponse
+	require.NoError(t, queryJSON(ctx, fixtureExec(stdout, &cmd), query, &response))
+	require.Equal(t, query, cmd)
+	balances, err := response.byDenom()
+	require.NoError(t, err)
+	require.Equal(t, int64(999_999_876_543), balances["untrn"])
+
+	err = queryJSON(ctx, fixtureExec([]byte("Error: not json"), &cmd), query, &response)
+	require.ErrorContains(t, err, "gaiad query bank")
+
+	failing := func(ctx context.Context, cmd []string, env []string) ([]byte, []byte, error) {
+		return nil, nil, errors.New("exit code 1: rpc error")
+	}
+	require.ErrorContains(t, queryJSON(ctx, failing, query, &response), "rpc error")
+}
@@ -0,0 +1,105 @@
+package ibc_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// The trusting period for tests that make the transfer path's
+// clients expire. Shorter than this and the clients risk expiring
+// before the interchain finishes building.
+const clientExpiryTrustingPeriod = "90s"
+
+// Tests that the transfer path's clients expire when nothing updates
+// them within their trusting period, and that interchain accounts
+// can't be created over a connection with an expired client.
+func TestClientExpiry(t *testing.T) {
+	env := setupICSTestWithConfig(t, icsTestConfig{
+		neutronTrustingPeriod: clientExpiryTrustingPeriod,
+	})
+	ctx, neutron := env.ctx, env.neutron
+
+	codeId := storeICAContract(t, env)
+	contract, err := InstantiateICAContract(ctx, neutron, env.neutronUser.KeyName, codeId, "")
+	require.NoError(t, err, "failed to instantiate ICA contract")
+
+	connection, err := transferConnection(ctx, env.relayer, env.eRep, neutron.Config().ChainID)
+	require.NoError(t, err)
+
+	// The relayer updates clients as it relays, so stop it to let
+	// the client expire.
+	err = env.relayer.StopRelayer(ctx, env.eRep)
+	require.NoError(t, err, "failed to stop relayer")
+
+	trustingPeriod, err := time.ParseDuration(clientExpiryTrustingPeriod)
+	require.NoError(t, err)
+	err = WaitForClientStatus(ctx, neutron, connection.ClientID, "Expired", 3*trustingPeriod)
+	require.NoError(t, err)
+
+	// Opening a channel requires an active client, so the ICA
+	// can't be registered.
+	err = RegisterICA(ctx, neutron, env.neutronUser.KeyName, contract, connection.ID, "expired")
+	require.Error(t, err, "registering an ICA over an expired client should fail")
+}
+
+// Tests what happens to an open interchain account when relaying
+// stops for long enough that its connection's client expires.
+//
+// The contract is never told about the expiry: it keeps reporting the
+// account's address. What changes is that Neutron refuses to send
+// packets over a connection whose client is not active, so
+// submitting through the account fails immediately, as does
+// registering on that connection again. The account can only be
+// replaced by registering on a new connection.
+//
+// Timing: the transfer path's clients have a trusting period of
+// `clientExpiryTrustingPeriod` (90s). The clients are updated right
+// before registration, and the channel handshake updates them again,
+// so the account opens well within that window. Once the relayer is
+// stopped nothing updates the clients, and they expire 90s later.
+// Polling for the expiry for three trusting periods leaves slack for
+// slow machines without making the test excessively long.
+func TestICAAfterClientExpiry(t *testing.T) {
+	env := setupICSTestWithConfig(t, icsTestConfig{
+		neutronTrustingPeriod: clientExpiryTrustingPeriod,
+	})
+	ctx, neutron := env.ctx, env.neutron
+
+	contract := deployICAContract(t, env)
+
+	// Only the transfer path's clients have the short trusting
+	// period, so the account has to be created on its connection.
+	connection, err := transferConnection(ctx, env.relayer, env.eRep, neutron.Config().ChainID)
+	require.NoError(t, err)
+
+	err = env.relayer.UpdateClients(ctx, env.eRep, ibcPath)
+	require.NoError(t, err, "failed to update transfer path clients")
+	err = RegisterICA(ctx, neutron, env.neutronUser.KeyName, contract, connection.ID, "test")
+	require.NoError(t, err)
+	address, err := WaitForICAAddress(ctx, neutron, contract, "test", connection.ID, time.Minute)
+	require.NoError(t, err)
+
+	err = env.relayer.StopRelayer(ctx, env.eRep)
+	require.NoError(t, err, "failed to stop relayer")
+
+	trustingPeriod, err := time.ParseDuration(clientExpiryTrustingPeriod)
+	require.NoError(t, err)
+	err = WaitForClientStatus(ctx, neutron, connection.ClientID, "Expired", 3*trustingPeriod)
+	require.NoError(t, err)
+
+	// The contract still thinks the account is usable.
+	stale, err := QueryICAAddressFromContract(ctx, neutron, contract, "test")
+	require.NoError(t, err)
+	require.Equal(t, address, stale)
+
+	// But nothing can be sent through it.
+	atomUserAddress := env.atomUser.Bech32Address(env.atom.Config().Bech32Prefix)
+	_, err = SubmitICASend(ctx, neutron, env.neutronUser.KeyName, contract, "test", atomUserAddress, 1, env.atom.Config().Denom, 0)
+	require.Error(t, err, "sending over an expired client should fail")
+
+	// Nor can it be registered again on the same connection.
+	err = RegisterICA(ctx, neutron, env.neutronUser.KeyName, contract, connection.ID, "test")
+	require.Error(t, err, "registering over an expired client should fail")
+}
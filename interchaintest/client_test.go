@@ -0,0 +1,56 @@
+package ibc_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Tests that the clients on Neutron have the trusting periods the
+// setup configures. The ICS client comes from Neutron's genesis, so
+// its trusting period is derived from Gaia's unbonding period, while
+// the transfer path's client is created by the relayer with
+// `icsTestConfig.neutronTrustingPeriod`.
+func TestClientTrustingPeriods(t *testing.T) {
+	const transferTrustingPeriod = "1000000s"
+	env := setupICSTestWithConfig(t, icsTestConfig{
+		neutronTrustingPeriod: transferTrustingPeriod,
+	})
+	ctx, neutron := env.ctx, env.neutron
+	neutronID := neutron.Config().ChainID
+
+	clients, err := GetClients(ctx, env.relayer, env.eRep, neutronID)
+	require.NoError(t, err)
+	require.GreaterOrEqual(t, len(clients), 2, "expected an ICS and a transfer client")
+	clientIds := make(map[string]bool)
+	for _, client := range clients {
+		require.Equal(t, env.atom.Config().ChainID, client.ClientState.ChainID, "every client on neutron should track atom")
+		clientIds[client.ClientID] = true
+	}
+
+	// Neutron's CCV channel leads to the ICS client. The
+	// environment's connection may be either path's.
+	icsClientId, err := GetProviderClientID(ctx, neutron)
+	require.NoError(t, err)
+	require.True(t, clientIds[icsClientId], "ICS client %q not reported", icsClientId)
+	transfer, err := transferConnection(ctx, env.relayer, env.eRep, neutronID)
+	require.NoError(t, err)
+	require.NotEqual(t, icsClientId, transfer.ClientID, "the ICS and transfer paths should have their own clients")
+
+	// The provider sets the trusting period of the consumer's client
+	// to its own unbonding period times the trusting period
+	// fraction. With Gaia's defaults, this is 21 days * 0.66.
+	expected, err := time.ParseDuration(defaultNeutronTrustingPeriod)
+	require.NoError(t, err)
+	trustingPeriod, err := ClientTrustingPeriod(ctx, neutron, icsClientId)
+	require.NoError(t, err)
+	require.Equal(t, expected, trustingPeriod, "ICS client trusting period")
+
+	require.True(t, clientIds[transfer.ClientID], "transfer connection's client %q not reported", transfer.ClientID)
+	expected, err = time.ParseDuration(transferTrustingPeriod)
+	require.NoError(t, err)
+	trustingPeriod, err = ClientTrustingPeriod(ctx, neutron, transfer.ClientID)
+	require.NoError(t, err)
+	require.Equal(t, expected, trustingPeriod, "transfer client trusting period")
+}
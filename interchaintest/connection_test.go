@@ -0,0 +1,156 @@
+package ibc_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/strangelove-ventures/interchaintest/v3/ibc"
+	"github.com/stretchr/testify/require"
+)
+
+// The paths that tests in this file create after the interchain is
+// built.
+const (
+	newConnectionPath = "new-connection-path"
+	newTransferPath   = "new-transfer-path"
+)
+
+// Tests registering an interchain account on a connection that did
+// not exist when the chains started, rather than one `ic.Build`
+// created.
+func TestICAOnNewConnection(t *testing.T) {
+	env := setupICSTestWithConfig(t, icsTestConfig{skipPathCreation: true})
+	ctx, r, eRep := env.ctx, env.relayer, env.eRep
+	atomID, neutronID := env.atom.Config().ChainID, env.neutron.Config().ChainID
+
+	// Interchain accounts bring their own channels, so clients and
+	// a connection are all that's needed.
+	newConnectionId, err := CreateConnection(ctx, r, eRep, neutronID, atomID, newConnectionPath)
+	require.NoError(t, err)
+	require.NotContains(t, env.connectionIds, newConnectionId)
+
+	// The relayer was started before the path existed.
+	err = r.StopRelayer(ctx, eRep)
+	require.NoError(t, err, "failed to stop relayer")
+	err = r.StartRelayer(ctx, eRep, icsPath, newConnectionPath)
+	require.NoError(t, err, "failed to restart relayer")
+
+	contract := deployICAContract(t, env)
+	err = RegisterICA(ctx, env.neutron, env.neutronUser.KeyName, contract, newConnectionId, "test")
+	require.NoError(t, err)
+	address, err := WaitForICAAddress(ctx, env.neutron, contract, "test", newConnectionId, 2*time.Minute)
+	require.NoError(t, err)
+	require.NotEmpty(t, address)
+
+	stored, err := QueryICAAddressFromContract(ctx, env.neutron, contract, "test")
+	require.NoError(t, err)
+	require.Equal(t, address, stored)
+}
+
+// Tests building the topology usually left to `ic.Build` by hand: the
+// interchain is built without paths, setup links just the ICS path,
+// and this adds a connection with a transfer channel. Both the
+// channel and an interchain account on the connection must work.
+func TestBuildTopology(t *testing.T) {
+	env := setupICSTestWithConfig(t, icsTestConfig{skipPathCreation: true})
+	ctx, r, eRep, atom, neutron := env.ctx, env.relayer, env.eRep, env.atom, env.neutron
+	atomID, neutronID := atom.Config().ChainID, neutron.Config().ChainID
+
+	connectionId, err := CreateConnection(ctx, r, eRep, neutronID, atomID, newTransferPath)
+	require.NoError(t, err)
+	neutronChannelId, err := CreateTransferChannel(ctx, r, eRep, neutronID, newTransferPath)
+	require.NoError(t, err)
+	channels, err := ListChannels(ctx, r, eRep, neutronID)
+	require.NoError(t, err)
+	var atomChannelId string
+	for _, channel := range channels {
+		if channel.ChannelID == neutronChannelId {
+			require.Equal(t, []string{connectionId}, channel.ConnectionHops)
+			atomChannelId = channel.Counterparty.ChannelID
+		}
+	}
+	require.NotEmpty(t, atomChannelId)
+
+	err = r.StopRelayer(ctx, eRep)
+	require.NoError(t, err, "failed to stop relayer")
+	err = r.StartRelayer(ctx, eRep, icsPath, newTransferPath)
+	require.NoError(t, err, "failed to restart relayer")
+
+	// Transfers work over the new channel.
+	denom := atom.Config().Denom
+	neutronUserAddress := env.neutronUser.Bech32Address(neutron.Config().Bech32Prefix)
+	_, err = atom.SendIBCTransfer(ctx, atomChannelId, env.atomUser.KeyName, ibc.WalletAmount{
+		Address: neutronUserAddress,
+		Denom:   denom,
+		Amount:  1_000,
+	}, ibc.TransferOptions{})
+	require.NoError(t, err, "failed to transfer atom to neutron")
+	_, err = WaitForBalance(ctx, neutron, neutronUserAddress, IBCDenom("transfer", neutronChannelId, denom), 1_000, 2*time.Minute)
+	require.NoError(t, err)
+
+	// And so do interchain accounts on the new connection.
+	contract := deployICAContract(t, env)
+	err = RegisterICA(ctx, neutron, env.neutronUser.KeyName, contract, connectionId, "test")
+	require.NoError(t, err)
+	icaAddress, err := WaitForICAAddress(ctx, neutron, contract, "test", connectionId, 2*time.Minute)
+	require.NoError(t, err)
+	fundICA(t, env, icaAddress, 1_000_000)
+	atomUserAddress := env.atomUser.Bech32Address(atom.Config().Bech32Prefix)
+	sequence, err := SubmitICASend(ctx, neutron, env.neutronUser.KeyName, contract, "test", atomUserAddress, 1_000, denom, 0)
+	require.NoError(t, err, "failed to submit ICA send")
+	result, err := WaitForAcknowledgement(ctx, neutron, contract, "test", sequence, 2*time.Minute)
+	require.NoError(t, err)
+	require.Equal(t, []string{"/cosmos.bank.v1beta1.MsgSend"}, result.Success)
+}
+
+// Tests that accounts registered on two connections are two
+// independent interchain accounts on the host, each spending only its
+// own funds. Each connection gets its own account ID: the port is
+// derived from the ID alone, so reusing one would leave the contract
+// with a single account and a single acknowledgement per sequence.
+func TestAccountsAcrossConnections(t *testing.T) {
+	env := setupICSTest(t)
+	ctx, r, eRep, atom, neutron := env.ctx, env.relayer, env.eRep, env.atom, env.neutron
+	atomID, neutronID := atom.Config().ChainID, neutron.Config().ChainID
+	keyName, denom := env.neutronUser.KeyName, atom.Config().Denom
+
+	secondConnectionId, err := CreateConnection(ctx, r, eRep, neutronID, atomID, newConnectionPath)
+	require.NoError(t, err)
+	require.NotContains(t, env.connectionIds, secondConnectionId)
+	err = r.StopRelayer(ctx, eRep)
+	require.NoError(t, err, "failed to stop relayer")
+	err = r.StartRelayer(ctx, eRep, icsPath, ibcPath, newConnectionPath)
+	require.NoError(t, err, "failed to restart relayer")
+
+	contract := deployICAContract(t, env)
+	connectionIds := []string{env.connectionIds[0], secondConnectionId}
+	accountIds := []string{"first", "second"}
+	// What each account is funded with and sends, so that a send
+	// from the wrong account shows in the balances.
+	funds := []int64{1_000_000, 2_000_000}
+	sends := []int64{1_000, 3_000}
+	addresses := make([]string, len(connectionIds))
+	for i, connectionId := range connectionIds {
+		err := RegisterICA(ctx, neutron, keyName, contract, connectionId, accountIds[i])
+		require.NoError(t, err, "failed to register on %s", connectionId)
+		addresses[i], err = WaitForICAAddress(ctx, neutron, contract, accountIds[i], connectionId, 2*time.Minute)
+		require.NoError(t, err)
+		require.NotContains(t, addresses[:i], addresses[i], "the account on %s should be new", connectionId)
+
+		fundICA(t, env, addresses[i], funds[i])
+		recipient := randomAddress(t, atom)
+		sequence, err := SubmitICASend(ctx, neutron, keyName, contract, accountIds[i], recipient, sends[i], denom, 0)
+		require.NoError(t, err, "failed to submit ICA send on %s", connectionId)
+		result, err := WaitForAcknowledgement(ctx, neutron, contract, accountIds[i], sequence, 2*time.Minute)
+		require.NoError(t, err)
+		require.Equal(t, []string{"/cosmos.bank.v1beta1.MsgSend"}, result.Success, "send on %s failed: %+v", connectionId, result)
+		_, err = WaitForBalance(ctx, atom, recipient, denom, sends[i], time.Minute)
+		require.NoError(t, err)
+	}
+
+	for i, address := range addresses {
+		balance, err := queryBalance(ctx, atom, address, denom)
+		require.NoError(t, err)
+		require.Equal(t, funds[i]-sends[i], balance, "the account on %s spent someone else's funds", connectionIds[i])
+	}
+}
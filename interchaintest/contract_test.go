@@ -0,0 +1,940 @@
+package ibc_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/strangelove-ventures/interchaintest/v3/chain/cosmos"
+	"github.com/stretchr/testify/require"
+)
+
+// The location of the Neutron ICA example contract. The wasm file is
+// placed here by the `just test` command, or by `requireContractWasm`
+// when NEUTRON_ICA_BUILD is set. `requireContractWasm` replaces this
+// with the value of NEUTRON_ICA_WASM if that is set.
+var icaContractWasm = "wasms/neutron_interchain_txs.wasm"
+
+// Returns the hex encoded SHA-256 of the wasm file at path. This is
+// the checksum wasmd records for code when it is stored.
+func wasmChecksum(path string) (string, error) {
+	bz, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read wasm: %w", err)
+	}
+	sum := sha256.Sum256(bz)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// The response to `query wasm code-info`.
+type codeInfoQueryResponse struct {
+	// The code's SHA-256, in upper case hex.
+	DataHash string `json:"data_hash"`
+}
+
+// Checks that the code stored on chain as codeID has the hex encoded
+// SHA-256 expectedSha256, as `wasmChecksum` returns. This catches a
+// test storing a stale or wrong wasm, which otherwise shows up as the
+// contract misbehaving.
+func AssertStoredCodeChecksum(ctx context.Context, chain *cosmos.CosmosChain, codeID, expectedSha256 string) error {
+	var response codeInfoQueryResponse
+	if err := QueryHostJSON(ctx, chain, []string{"wasm", "code-info", codeID}, &response); err != nil {
+		return err
+	}
+	if !strings.EqualFold(response.DataHash, expectedSha256) {
+		return fmt.Errorf("code %s has checksum %s, expected %s", codeID, strings.ToLower(response.DataHash), strings.ToLower(expectedSha256))
+	}
+	return nil
+}
+
+// A wasm file stored on a chain by `StoreOnce`.
+type storedCode struct {
+	chain *cosmos.CosmosChain
+	path  string
+}
+
+// The code ID a wasm file was stored under. The lock is held while
+// storing, so that tests storing the same file at once wait for the
+// first rather than storing it again.
+type storedCodeEntry struct {
+	sync.Mutex
+	codeId string
+}
+
+var (
+	storedCodesMu sync.Mutex
+	storedCodes   = make(map[storedCode]*storedCodeEntry)
+)
+
+// Stores the wasm file at path on chain from keyName and returns its
+// code ID. The code ID is remembered for the rest of the run, and
+// later calls for the same file and chain return it without storing
+// the file again. To get a second code ID for the same wasm, as
+// migrating needs, call `StoreContract` directly.
+func StoreOnce(ctx context.Context, chain *cosmos.CosmosChain, keyName, path string) (string, error) {
+	storedCodesMu.Lock()
+	entry, ok := storedCodes[storedCode{chain, path}]
+	if !ok {
+		entry = &storedCodeEntry{}
+		storedCodes[storedCode{chain, path}] = entry
+	}
+	storedCodesMu.Unlock()
+
+	entry.Lock()
+	defer entry.Unlock()
+	if entry.codeId != "" {
+		return entry.codeId, nil
+	}
+	codeId, err := chain.StoreContract(ctx, keyName, path)
+	if err != nil {
+		return "", err
+	}
+	entry.codeId = codeId
+	return codeId, nil
+}
+
+// Instantiates the Neutron ICA example contract from codeId. If admin
+// is non-empty, it is set as the contract's admin and may later
+// migrate the contract with `MigrateICAContract`. Otherwise, the
+// contract is instantiated without an admin and can never be
+// migrated.
+func InstantiateICAContract(ctx context.Context, chain *cosmos.CosmosChain, keyName, codeId, admin string) (string, error) {
+	if admin == "" {
+		return chain.InstantiateContract(ctx, keyName, codeId, `{}`, true)
+	}
+	return chain.InstantiateContract(ctx, keyName, codeId, `{}`, false, "--admin", admin)
+}
+
+// The response to `query wasm contract`.
+type contractInfoQueryResponse struct {
+	Address      string `json:"address"`
+	ContractInfo struct {
+		CodeId  string `json:"code_id"`
+		Creator string `json:"creator"`
+		Admin   string `json:"admin"`
+	} `json:"contract_info"`
+}
+
+// Queries the address that instantiated contract.
+//
+// Note that this is not the owner of the contract's interchain
+// accounts. Neutron's interchain transactions module makes whoever
+// sends the register message the owner, and that is always the
+// contract itself. Use `ICAPortID` for the port an account's channel
+// is on.
+func QueryContractOwner(ctx context.Context, chain *cosmos.CosmosChain, contract string) (string, error) {
+	response, err := queryContractInfo(ctx, chain, contract)
+	if err != nil {
+		return "", err
+	}
+	return response.ContractInfo.Creator, nil
+}
+
+// Queries the ID of the code contract was instantiated from, or
+// migrated to last.
+func QueryContractCodeID(ctx context.Context, chain *cosmos.CosmosChain, contract string) (string, error) {
+	response, err := queryContractInfo(ctx, chain, contract)
+	if err != nil {
+		return "", err
+	}
+	return response.ContractInfo.CodeId, nil
+}
+
+func queryContractInfo(ctx context.Context, chain *cosmos.CosmosChain, contract string) (*contractInfoQueryResponse, error) {
+	stdout, _, err := chain.Exec(ctx, queryCommand(chain, "wasm", "contract", contract), nil)
+	if err != nil {
+		return nil, err
+	}
+	var response contractInfoQueryResponse
+	if err := json.Unmarshal(stdout, &response); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal contract info: %w", err)
+	}
+	return &response, nil
+}
+
+// The key the cw2 crate stores a contract's name and version under,
+// which `formatStateKey` leaves as is.
+const contractVersionKey = "contract_info"
+
+// What a contract was instantiated with. The example contract has no
+// config of its own to query: its instantiate message is empty, and
+// its default packet timeout is a constant. So this is assembled from
+// what the chain records about the contract and the cw2 version the
+// contract saves in its storage on instantiation.
+type ContractConfig struct {
+	CodeId  string
+	Creator string
+	// Empty if the contract was instantiated without an admin.
+	Admin string
+	// The cw2 contract name, for example
+	// "crates.io:neutron-sdk__neutron_interchain_txs".
+	Contract string
+	Version  string
+}
+
+// The value cw2 stores under `contractVersionKey`.
+type contractVersion struct {
+	Contract string `json:"contract"`
+	Version  string `json:"version"`
+}
+
+// Parses the cw2 version from state, the raw storage of a contract as
+// `DumpContractState` returns it.
+func parseContractVersion(state map[string]string) (*contractVersion, error) {
+	value, ok := state[contractVersionKey]
+	if !ok {
+		return nil, errors.New("contract has no cw2 version")
+	}
+	var version contractVersion
+	if err := json.Unmarshal([]byte(value), &version); err != nil {
+		return nil, fmt.Errorf("invalid cw2 version %q: %w", value, err)
+	}
+	return &version, nil
+}
+
+// Queries what contract was instantiated with. See `ContractConfig`.
+func QueryContractConfig(ctx context.Context, chain *cosmos.CosmosChain, contract string) (*ContractConfig, error) {
+	info, err := queryContractInfo(ctx, chain, contract)
+	if err != nil {
+		return nil, err
+	}
+	state, err := DumpContractState(ctx, chain, contract)
+	if err != nil {
+		return nil, err
+	}
+	version, err := parseContractVersion(state)
+	if err != nil {
+		return nil, err
+	}
+	return &ContractConfig{
+		CodeId:   info.ContractInfo.CodeId,
+		Creator:  info.ContractInfo.Creator,
+		Admin:    info.ContractInfo.Admin,
+		Contract: version.Contract,
+		Version:  version.Version,
+	}, nil
+}
+
+// Returns the port that the channel of the interchain account with
+// ID accountId, owned by owner, is bound to. For accounts registered
+// by the example contract, owner is the contract's address.
+func ICAPortID(owner, accountId string) string {
+	return "icacontroller-" + owner + "." + accountId
+}
+
+// Executes a message to create an interchain account with ID
+// accountId on connectionId, returning an error if the transaction is
+// rejected. The account has no address until the channel handshake
+// completes; see `WaitForICAAddress`.
+//
+// The channel version can not be chosen: neither Neutron v1's
+// register message nor the contract take one, so Neutron always
+// proposes the ICS-27 defaults. See `TestICAChannelVersion`.
+func RegisterICA(ctx context.Context, chain *cosmos.CosmosChain, keyName, contract, connectionId, accountId string) error {
+	cmd, err := registerCommand(chain, keyName, contract, connectionId, accountId)
+	if err != nil {
+		return err
+	}
+	_, err = execTx(ctx, chain, cmd)
+	return err
+}
+
+// Builds the command `RegisterICA` runs.
+func registerCommand(chain *cosmos.CosmosChain, keyName, contract, connectionId, accountId string) ([]string, error) {
+	return executeContractCommand(chain, keyName, contract, IcaExampleContractExecute{
+		Register: &RegisterMsg{
+			ConnectionId:        connectionId,
+			InterchainAccountId: accountId,
+		},
+	}, TxOptions{})
+}
+
+// Migrates contract to newCodeId, sending it migrateMsg. The
+// transaction must be signed by the contract's admin (see
+// `InstantiateICAContract`), and is rejected if the contract has
+// none.
+func MigrateICAContract(ctx context.Context, chain *cosmos.CosmosChain, keyName, contract, newCodeId string, migrateMsg interface{}) error {
+	cmd, err := migrateContractCommand(chain, keyName, contract, newCodeId, migrateMsg, TxOptions{})
+	if err != nil {
+		return err
+	}
+	_, err = execTx(ctx, chain, cmd)
+	return err
+}
+
+// Builds a command that migrates contract to newCodeId, signing with
+// keyName. See `MigrateICAContract`.
+func migrateContractCommand(chain *cosmos.CosmosChain, keyName, contract, newCodeId string, migrateMsg interface{}, opts TxOptions) ([]string, error) {
+	msg, err := json.Marshal(migrateMsg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal migrate message: %w", err)
+	}
+	cmd := []string{chain.Config().Bin, "tx", "wasm", "migrate",
+		contract,
+		newCodeId,
+		string(msg),
+	}
+	return append(cmd, txFlags(chain, keyName, opts)...), nil
+}
+
+// How many times the address queries try before giving up on a
+// transient error. See `QueryContractWithRetry`.
+const addressQueryAttempts = 3
+
+// How long `QueryContractWithRetry` waits before its first retry. It
+// waits twice as long before each one after.
+const queryRetryBackoff = 250 * time.Millisecond
+
+// Parts of the errors a query gets when the node it asks is briefly
+// unavailable, as it can be between blocks, rather than when the
+// query itself fails. The node hanging up shows as a bare EOF at the
+// end of the error; an "unexpected EOF" is a truncated response and
+// is not retried.
+var transientQueryErrors = []string{
+	"post failed",
+	"connection refused",
+	"connection reset",
+	"i/o timeout",
+	": EOF",
+}
+
+// Reports whether a query that failed with err may succeed if run
+// again.
+func isTransientQueryError(err error) bool {
+	if errors.Is(err, io.EOF) {
+		return true
+	}
+	for _, transient := range transientQueryErrors {
+		if strings.Contains(err.Error(), transient) {
+			return true
+		}
+	}
+	return false
+}
+
+// Queries contract with query as `cosmos.CosmosChain.QueryContract`
+// does, trying up to attempts times while it fails with a transient
+// error, such as the node's RPC being briefly unavailable. Other
+// errors, including the contract rejecting the query, are returned
+// straight away.
+func QueryContractWithRetry(ctx context.Context, chain *cosmos.CosmosChain, contract string, query interface{}, out interface{}, attempts int) error {
+	return retryTransient(ctx, attempts, queryRetryBackoff, func() error {
+		return chain.QueryContract(ctx, contract, query, out)
+	})
+}
+
+func retryTransient(ctx context.Context, attempts int, backoff time.Duration, query func() error) error {
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = query()
+		if err == nil || !isTransientQueryError(err) {
+			return err
+		}
+		if attempt >= attempts {
+			return fmt.Errorf("failed after %d attempts: %w", attempts, err)
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%w, last error: %s", ctx.Err(), err)
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// Queries the address of the interchain account with ID accountId
+// that the contract saved in its storage once the ICA channel opened.
+// Returns an error if the account has not been registered.
+func QueryICAAddressFromContract(ctx context.Context, chain *cosmos.CosmosChain, contract, accountId string) (string, error) {
+	var response InterchainAccountAddressFromContractQueryResponse
+	err := QueryContractWithRetry(ctx, chain, contract, IcaExampleContractQuery{
+		InterchainAccountAddressFromContract: &InterchainAccountAddressFromContractQuery{
+			InterchainAccountId: accountId,
+		},
+	}, &response, addressQueryAttempts)
+	if err != nil {
+		return "", err
+	}
+	if len(response.Data) != 2 {
+		return "", fmt.Errorf("expected an (address, connection_id) pair, got: %v", response.Data)
+	}
+	return response.Data[0], nil
+}
+
+// Queries Neutron's interchain transactions module, via the contract,
+// for the address of the interchain account with ID accountId on
+// connectionId. The address is empty until the ICA channel opens.
+func QueryICAAddress(ctx context.Context, chain *cosmos.CosmosChain, contract, accountId, connectionId string) (string, error) {
+	var response QueryResponse
+	err := QueryContractWithRetry(ctx, chain, contract, IcaExampleContractQuery{
+		InterchainAccountAddress: &InterchainAccountAddressQuery{
+			InterchainAccountId: accountId,
+			ConnectionId:        connectionId,
+		},
+	}, &response, addressQueryAttempts)
+	if err != nil {
+		return "", err
+	}
+	return response.Data.InterchainAccountAddress, nil
+}
+
+// Polls the contract until the interchain account with ID accountId
+// on connectionId has an address, or until timeout elapses. This
+// takes a while after registration as the relayer has to complete an
+// entire IBC channel handshake.
+func WaitForICAAddress(ctx context.Context, chain *cosmos.CosmosChain, contract, accountId, connectionId string, timeout time.Duration) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var lastErr error
+	for {
+		address, err := QueryICAAddress(ctx, chain, contract, accountId, connectionId)
+		if err == nil && address != "" {
+			return address, nil
+		}
+		if err != nil {
+			lastErr = err
+		}
+
+		select {
+		case <-ctx.Done():
+			if lastErr != nil {
+				return "", fmt.Errorf("timed out after %s waiting for address of ICA %s on %s: %w", timeout, accountId, connectionId, lastErr)
+			}
+			return "", fmt.Errorf("timed out after %s waiting for address of ICA %s on %s", timeout, accountId, connectionId)
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+// Queries the contract for the result of the packet with sequence
+// sent by the interchain account with ID accountId. Returns nil if
+// the contract has not received a response to that packet yet.
+func QueryAcknowledgementResult(ctx context.Context, chain *cosmos.CosmosChain, contract, accountId string, sequence uint64) (*AcknowledgementResult, error) {
+	var response AcknowledgementResultQueryResponse
+	err := chain.QueryContract(ctx, contract, IcaExampleContractQuery{
+		AcknowledgementResult: &AcknowledgementResultQuery{
+			InterchainAccountId: accountId,
+			SequenceId:          sequence,
+		},
+	}, &response)
+	if err != nil {
+		return nil, err
+	}
+	return response.Data, nil
+}
+
+// Queries the contract for at most limit results of packets sent by
+// the interchain account with ID accountId, in order of sequence,
+// starting after sequence startAfter. Pass nil to start from the
+// first.
+func QueryAcknowledgementResults(ctx context.Context, chain *cosmos.CosmosChain, contract, accountId string, startAfter *uint64, limit uint32) ([]SequencedAcknowledgementResult, error) {
+	var response AcknowledgementResultsQueryResponse
+	err := chain.QueryContract(ctx, contract, IcaExampleContractQuery{
+		AcknowledgementResults: &AcknowledgementResultsQuery{
+			InterchainAccountId: accountId,
+			StartAfter:          startAfter,
+			Limit:               &limit,
+		},
+	}, &response)
+	if err != nil {
+		return nil, err
+	}
+	return response.Data, nil
+}
+
+// Collects every page of pageSize results of the interchain account
+// with ID accountId. It stops at the first page shorter than
+// pageSize, which is empty if the number of results is a multiple of
+// it.
+func ListAcknowledgementResults(ctx context.Context, chain *cosmos.CosmosChain, contract, accountId string, pageSize uint32) ([]SequencedAcknowledgementResult, error) {
+	return collectAcknowledgementResults(pageSize, func(startAfter *uint64) ([]SequencedAcknowledgementResult, error) {
+		return QueryAcknowledgementResults(ctx, chain, contract, accountId, startAfter, pageSize)
+	})
+}
+
+func collectAcknowledgementResults(pageSize uint32, page func(startAfter *uint64) ([]SequencedAcknowledgementResult, error)) ([]SequencedAcknowledgementResult, error) {
+	if pageSize == 0 {
+		return nil, fmt.Errorf("page size must be positive")
+	}
+	var results []SequencedAcknowledgementResult
+	var startAfter *uint64
+	for {
+		next, err := page(startAfter)
+		if err != nil {
+			return nil, err
+		}
+		if uint32(len(next)) > pageSize {
+			return nil, fmt.Errorf("got %d results, more than the page size of %d", len(next), pageSize)
+		}
+		results = append(results, next...)
+		if uint32(len(next)) < pageSize {
+			return results, nil
+		}
+		last := next[len(next)-1].SequenceId
+		if startAfter != nil && last <= *startAfter {
+			return nil, fmt.Errorf("page after sequence %d did not move past it", *startAfter)
+		}
+		startAfter = &last
+	}
+}
+
+// Queries the contract for the port and channel of the interchain
+// account with ID accountId. Returns an error if the account has not
+// been registered.
+func QueryICAChannel(ctx context.Context, chain *cosmos.CosmosChain, contract, accountId string) (*InterchainAccountChannel, error) {
+	var response InterchainAccountChannelQueryResponse
+	err := chain.QueryContract(ctx, contract, IcaExampleContractQuery{
+		InterchainAccountChannel: &InterchainAccountChannelQuery{
+			InterchainAccountId: accountId,
+		},
+	}, &response)
+	if err != nil {
+		return nil, err
+	}
+	return &response.Data, nil
+}
+
+// Parses the IDs of contract's interchain accounts from the output of
+// `query ibc channel connections`. Each account has a channel on its
+// own controller port, see `ICAPortID`, and keeps that port when its
+// channel is closed and reopened, so an account may appear more than
+// once. The IDs are returned sorted, without duplicates.
+func parseConnectionAccounts(stdout []byte, contract string) ([]string, error) {
+	var response channelsQueryResponse
+	if err := json.Unmarshal(stdout, &response); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal channels: %w", err)
+	}
+	prefix := ICAPortID(contract, "")
+	seen := make(map[string]bool)
+	accountIds := []string{}
+	for _, channel := range response.Channels {
+		accountId := strings.TrimPrefix(channel.PortID, prefix)
+		if accountId == channel.PortID || seen[accountId] {
+			continue
+		}
+		seen[accountId] = true
+		accountIds = append(accountIds, accountId)
+	}
+	sort.Strings(accountIds)
+	return accountIds, nil
+}
+
+// Returns the IDs of the interchain accounts that contract has
+// registered on connectionId. The contract can only look accounts up
+// by ID, so this lists the channels on the connection instead.
+// Accounts whose channel handshake has started are included, whether
+// or not their channel is open.
+func QueryAccountsByConnection(ctx context.Context, chain *cosmos.CosmosChain, contract, connectionId string) ([]string, error) {
+	stdout, _, err := chain.Exec(ctx, queryCommand(chain, "ibc", "channel", "connections", connectionId), nil)
+	if err != nil {
+		return nil, err
+	}
+	return parseConnectionAccounts(stdout, contract)
+}
+
+// Queries the contract for the highest sequence of the packets sent
+// by the interchain account with ID accountId that it has received a
+// response to. Returns 0 if it has received none, as sequences start
+// at 1.
+func QueryLastAckedSequence(ctx context.Context, chain *cosmos.CosmosChain, contract, accountId string) (uint64, error) {
+	var response LastAcknowledgedSequenceQueryResponse
+	err := chain.QueryContract(ctx, contract, IcaExampleContractQuery{
+		LastAcknowledgedSequence: &LastAcknowledgedSequenceQuery{
+			InterchainAccountId: accountId,
+		},
+	}, &response)
+	if err != nil {
+		return 0, err
+	}
+	if response.Data == nil {
+		return 0, nil
+	}
+	return *response.Data, nil
+}
+
+// The most storage entries `DumpContractState` fetches. The example
+// contract keeps a handful per account, so this is every entry for
+// any test here.
+const contractStateLimit = 1000
+
+// The response to `query wasm contract-state all`. Keys are printed
+// in hex and values in base64.
+type contractStateQueryResponse struct {
+	Models []struct {
+		Key   string `json:"key"`
+		Value []byte `json:"value"`
+	} `json:"models"`
+}
+
+// Returns the contract's storage, raw key to value, with keys made
+// readable by `formatStateKey`. Values are stored as JSON by the
+// contract, so are returned as is.
+func (r *contractStateQueryResponse) entries() (map[string]string, error) {
+	state := make(map[string]string, len(r.Models))
+	for _, model := range r.Models {
+		key, err := hex.DecodeString(model.Key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key %q: %w", model.Key, err)
+		}
+		state[formatStateKey(key)] = string(model.Value)
+	}
+	return state, nil
+}
+
+// Formats a key of the contract's storage for reading. cw-storage-plus
+// prefixes keys in a map with the map's length-prefixed namespace,
+// say "\x00\x13interchain_accounts", which is written as
+// "interchain_accounts/" followed by the rest of the key. Bytes that
+// aren't printable, as in the big-endian integers of composite keys,
+// are escaped.
+func formatStateKey(key []byte) string {
+	if len(key) > 2 {
+		n := int(binary.BigEndian.Uint16(key))
+		if n > 0 && n <= len(key)-2 && strconv.CanBackquote(string(key[2:2+n])) {
+			return string(key[2:2+n]) + "/" + escapeStateKey(key[2+n:])
+		}
+	}
+	return escapeStateKey(key)
+}
+
+func escapeStateKey(key []byte) string {
+	quoted := strconv.QuoteToASCII(string(key))
+	return quoted[1 : len(quoted)-1]
+}
+
+// Queries the raw storage of contract, for debugging. See
+// `contractStateQueryResponse.entries` for the format.
+func DumpContractState(ctx context.Context, chain *cosmos.CosmosChain, contract string) (map[string]string, error) {
+	var response contractStateQueryResponse
+	args := []string{"wasm", "contract-state", "all", contract, "--limit", strconv.Itoa(contractStateLimit)}
+	if err := QueryHostJSON(ctx, chain, args, &response); err != nil {
+		return nil, err
+	}
+	return response.entries()
+}
+
+// Logs the raw storage of contract if t fails, so that the accounts,
+// acknowledgements, and errors the contract recorded can be read
+// without re-running the test.
+func logContractStateOnFailure(t *testing.T, ctx context.Context, chain *cosmos.CosmosChain, contract string) {
+	t.Cleanup(func() {
+		if !t.Failed() {
+			return
+		}
+		state, err := DumpContractState(ctx, chain, contract)
+		if err != nil {
+			t.Logf("failed to dump state of contract %s: %s", contract, err)
+			return
+		}
+		keys := make([]string, 0, len(state))
+		for key := range state {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		t.Logf("state of contract %s:", contract)
+		for _, key := range keys {
+			t.Logf("  %s = %s", key, state[key])
+		}
+	})
+}
+
+// Polls the contract until it has received a response (success,
+// error, or timeout) to the packet with sequence, or until timeout
+// elapses.
+func WaitForAcknowledgement(ctx context.Context, chain *cosmos.CosmosChain, contract, accountId string, sequence uint64, timeout time.Duration) (*AcknowledgementResult, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var lastErr error
+	for {
+		result, err := QueryAcknowledgementResult(ctx, chain, contract, accountId, sequence)
+		if err == nil && result != nil {
+			return result, nil
+		}
+		if err != nil {
+			lastErr = err
+		}
+
+		select {
+		case <-ctx.Done():
+			if lastErr != nil {
+				return nil, fmt.Errorf("timed out after %s waiting for acknowledgement of packet %d from ICA %s: %w", timeout, sequence, accountId, lastErr)
+			}
+			return nil, fmt.Errorf("timed out after %s waiting for acknowledgement of packet %d from ICA %s", timeout, sequence, accountId)
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+// An error acknowledgement the contract recorded for a packet sent by
+// an interchain account.
+type ICAError struct {
+	Sequence uint64
+	// The payload message of the packet.
+	Message string
+	// The error the host chain returned.
+	Details string
+}
+
+// Queries the most recent error of the interchain account with ID
+// accountId.
+//
+// The contract does not track a last error per account. It records
+// the result of each packet under its sequence, and never clears
+// one, so an old error stays queryable after later packets succeed.
+// This instead reads the result of the last packet the contract has
+// a response to (see `QueryLastAckedSequence`), and returns its
+// error. A nil error means that packet succeeded or timed out,
+// superseding any earlier errors, or that there is no response yet.
+func QueryLastICAError(ctx context.Context, chain *cosmos.CosmosChain, contract, accountId string) (*ICAError, error) {
+	latest, err := QueryLastAckedSequence(ctx, chain, contract, accountId)
+	if err != nil || latest == 0 {
+		return nil, err
+	}
+	result, err := QueryAcknowledgementResult(ctx, chain, contract, accountId, latest)
+	if err != nil {
+		return nil, err
+	}
+	if result == nil || result.Error == nil {
+		return nil, nil
+	}
+	if len(result.Error) != 2 {
+		return nil, fmt.Errorf("expected a (message, details) pair, got: %v", result.Error)
+	}
+	return &ICAError{Sequence: latest, Message: result.Error[0], Details: result.Error[1]}, nil
+}
+
+// Stores and instantiates the ICA example contract on Neutron,
+// without an admin.
+func deployICAContract(t *testing.T, env *icsTestEnv) string {
+	t.Helper()
+	codeId := storeICAContract(t, env)
+	contract, err := InstantiateICAContract(env.ctx, env.neutron, env.neutronUser.KeyName, codeId, "")
+	require.NoError(t, err, "failed to instantiate ICA contract")
+	logContractStateOnFailure(t, env.ctx, env.neutron, contract)
+	return contract
+}
+
+// Stores the ICA contract from the environment's Neutron user, if it
+// has not been already, and checks that the chain stored
+// `icaContractWasm` as it is on disk. Returns the code ID, which is
+// the same every time for an environment. See `StoreOnce`.
+func storeICAContract(t *testing.T, env *icsTestEnv) string {
+	t.Helper()
+	requireContractWasm(t)
+	codeId, err := StoreOnce(env.ctx, env.neutron, env.neutronUser.KeyName, icaContractWasm)
+	require.NoError(t, err, "failed to store neutron ICA contract")
+	checksum, err := wasmChecksum(icaContractWasm)
+	require.NoError(t, err)
+	require.NoError(t, AssertStoredCodeChecksum(env.ctx, env.neutron, codeId, checksum))
+	return codeId
+}
+
+// Registers an interchain account with ID accountId on the
+// environment's connection and waits for its channel to open.
+// Returns the address of the account on Atom.
+func registerICA(t *testing.T, env *icsTestEnv, contract, accountId string) string {
+	t.Helper()
+	err := RegisterICA(env.ctx, env.neutron, env.neutronUser.KeyName, contract, env.connectionId, accountId)
+	require.NoError(t, err, "failed to register ICA %s", accountId)
+	address, err := WaitForICAAddress(env.ctx, env.neutron, contract, accountId, env.connectionId, 2*time.Minute)
+	require.NoError(t, err)
+	requireHostAddress(t, env.atom, address)
+	return address
+}
+
+func TestContractStateEntries(t *testing.T) {
+	bz, err := os.ReadFile("testdata/wasm_contract_state.json")
+	require.NoError(t, err)
+	var response contractStateQueryResponse
+	require.NoError(t, json.Unmarshal(bz, &response))
+
+	state, err := response.entries()
+	require.NoError(t, err)
+	port := "icacontroller-neutron14hj2tavq8fpesdwxxcu44rty3hh90vhujrvcmstl4zr3txmfvw9s5c2epq.test"
+	require.Equal(t, map[string]string{
+		"errors_queue/\\x00\\x00\\x00\\x00": `"failed to parse response: unexpected end of input"`,
+		"interchain_accounts/" + port:       `["cosmos1hfxm6slsnrhfmcap6q66zl0uwaq8fy3t6xqfmfhfmp6eaupaphnq8yggam","connection-0"]`,
+		"interchain_channels/" + port:       `"channel-1"`,
+		"last_acked_sequences/" + port:      `1`,
+		"acknowledgement_results/\\x00U" + port + strings.Repeat("\\x00", 7) + "\\x01": `{"success":["/cosmos.bank.v1beta1.MsgSend"]}`,
+	}, state)
+
+	response.Models[0].Key = "not hex"
+	_, err = response.entries()
+	require.ErrorContains(t, err, "invalid key")
+}
+
+// Tests that a contract keeps what it was instantiated with, with and
+// without an admin.
+func TestContractConfig(t *testing.T) {
+	env := setupICSTest(t)
+	ctx, neutron := env.ctx, env.neutron
+	keyName := env.neutronUser.KeyName
+	user := env.neutronUser.Bech32Address(neutron.Config().Bech32Prefix)
+
+	codeId := storeICAContract(t, env)
+	for _, admin := range []string{randomAddress(t, neutron), ""} {
+		contract, err := InstantiateICAContract(ctx, neutron, keyName, codeId, admin)
+		require.NoError(t, err, "failed to instantiate ICA contract")
+
+		config, err := QueryContractConfig(ctx, neutron, contract)
+		require.NoError(t, err)
+		require.Equal(t, ContractConfig{
+			CodeId:   codeId,
+			Creator:  user,
+			Admin:    admin,
+			Contract: "crates.io:neutron-sdk__neutron_interchain_txs",
+			Version:  "0.1.0",
+		}, *config)
+	}
+}
+
+func TestParseContractVersion(t *testing.T) {
+	version, err := parseContractVersion(map[string]string{
+		contractVersionKey:                  `{"contract":"crates.io:neutron-sdk__neutron_interchain_txs","version":"0.1.0"}`,
+		"errors_queue/\\x00\\x00\\x00\\x00": `"failed to parse response"`,
+	})
+	require.NoError(t, err)
+	require.Equal(t, &contractVersion{Contract: "crates.io:neutron-sdk__neutron_interchain_txs", Version: "0.1.0"}, version)
+
+	_, err = parseContractVersion(map[string]string{})
+	require.ErrorContains(t, err, "no cw2 version")
+	_, err = parseContractVersion(map[string]string{contractVersionKey: "not json"})
+	require.ErrorContains(t, err, "invalid cw2 version")
+}
+
+func TestCollectAcknowledgementResults(t *testing.T) {
+	var stored []SequencedAcknowledgementResult
+	for sequence := uint64(1); sequence <= 5; sequence++ {
+		stored = append(stored, SequencedAcknowledgementResult{SequenceId: sequence})
+	}
+	// Pages the way the contract does.
+	pager := func(results []SequencedAcknowledgementResult, pageSize int, calls *int) func(*uint64) ([]SequencedAcknowledgementResult, error) {
+		return func(startAfter *uint64) ([]SequencedAcknowledgementResult, error) {
+			*calls++
+			var page []SequencedAcknowledgementResult
+			for _, result := range results {
+				if (startAfter == nil || result.SequenceId > *startAfter) && len(page) < pageSize {
+					page = append(page, result)
+				}
+			}
+			return page, nil
+		}
+	}
+
+	calls := 0
+	results, err := collectAcknowledgementResults(2, pager(stored, 2, &calls))
+	require.NoError(t, err)
+	require.Equal(t, stored, results)
+	require.Equal(t, 3, calls, "the partial third page should end it")
+
+	calls = 0
+	results, err = collectAcknowledgementResults(5, pager(stored, 5, &calls))
+	require.NoError(t, err)
+	require.Equal(t, stored, results)
+	require.Equal(t, 2, calls, "a full last page needs an empty one after it")
+
+	calls = 0
+	results, err = collectAcknowledgementResults(2, pager(nil, 2, &calls))
+	require.NoError(t, err)
+	require.Empty(t, results)
+
+	_, err = collectAcknowledgementResults(2, pager(stored, 3, &calls))
+	require.ErrorContains(t, err, "more than the page size")
+
+	_, err = collectAcknowledgementResults(2, func(*uint64) ([]SequencedAcknowledgementResult, error) {
+		return stored[:2], nil
+	})
+	require.ErrorContains(t, err, "did not move past it")
+
+	_, err = collectAcknowledgementResults(0, pager(stored, 2, &calls))
+	require.Error(t, err)
+}
+
+func TestRetryTransient(t *testing.T) {
+	ctx := context.Background()
+	transient := errors.New(`post failed: Post "http://neutron-2-val-0:26657": dial tcp: connection refused`)
+
+	// Succeeds once the node is back.
+	calls := 0
+	err := retryTransient(ctx, 3, time.Millisecond, func() error {
+		calls++
+		if calls < 3 {
+			return transient
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, 3, calls)
+
+	// Gives up after the configured number of attempts.
+	calls = 0
+	err = retryTransient(ctx, 4, time.Millisecond, func() error {
+		calls++
+		return transient
+	})
+	require.ErrorIs(t, err, transient)
+	require.ErrorContains(t, err, "failed after 4 attempts")
+	require.Equal(t, 4, calls)
+
+	// The contract rejecting a query is not worth retrying.
+	calls = 0
+	rejected := errors.New("Error parsing into type neutron_interchain_txs::msg::QueryMsg: unknown variant `config`: query wasm contract failed")
+	err = retryTransient(ctx, 3, time.Millisecond, func() error {
+		calls++
+		return rejected
+	})
+	require.Equal(t, rejected, err)
+	require.Equal(t, 1, calls)
+
+	// The node hanging up is transient, a truncated response is not.
+	require.True(t, isTransientQueryError(errors.New(`Post "http://neutron-2-val-0:26657": EOF`)))
+	require.True(t, isTransientQueryError(fmt.Errorf("query failed: %w", io.EOF)))
+	require.False(t, isTransientQueryError(errors.New("failed to unmarshal query response: unexpected EOF")))
+
+	// Nor is anything once the context is done.
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	calls = 0
+	err = retryTransient(cancelled, 3, time.Minute, func() error {
+		calls++
+		return transient
+	})
+	require.ErrorIs(t, err, context.Canceled)
+	require.Equal(t, 1, calls)
+}
+
+func TestFormatStateKey(t *testing.T) {
+	require.Equal(t, "reply_queue_id", formatStateKey([]byte("reply_queue_id")))
+	require.Equal(t, "\\x00\\x05ab", formatStateKey([]byte("\x00\x05ab")))
+	require.Equal(t, "\\x01", formatStateKey([]byte{1}))
+	// cw2 stores its version under a plain key, not in a map.
+	require.Equal(t, contractVersionKey, formatStateKey([]byte("contract_info")))
+}
+
+func TestWasmChecksum(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "contract.wasm")
+	require.NoError(t, os.WriteFile(path, []byte("\x00asm\x01\x00\x00\x00"), 0o644))
+	checksum, err := wasmChecksum(path)
+	require.NoError(t, err)
+	// `printf '\0asm\1\0\0\0' | sha256sum`
+	require.Equal(t, "93a44bbb96c751218e4c00d479e4c14358122a389acca16205b1e4d0dc5f9476", checksum)
+
+	_, err = wasmChecksum(filepath.Join(t.TempDir(), "missing.wasm"))
+	require.Error(t, err)
+}
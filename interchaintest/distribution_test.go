@@ -0,0 +1,302 @@
+package ibc_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/cosmos/cosmos-sdk/crypto/keyring"
+	sdk "github.com/cosmos/cosmos-sdk/types"
+	authtypes "github.com/cosmos/cosmos-sdk/x/auth/types"
+	"github.com/strangelove-ventures/interchaintest/v3/chain/cosmos"
+	"github.com/strangelove-ventures/interchaintest/v3/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+// The consumer's module accounts that fees are split between each
+// block: its own share, which Neutron's feeburner burns in the same
+// block, and the provider's share, which waits here until the next
+// distribution transmission.
+const (
+	consumerRedistributeAccount     = "cons_redistribute"
+	consumerToSendToProviderAccount = "cons_to_send_to_provider"
+)
+
+// Returns the address of the module account name on chain.
+func moduleAddress(chain *cosmos.CosmosChain, name string) (string, error) {
+	return sdk.Bech32ifyAddressBytes(chain.Config().Bech32Prefix, authtypes.NewModuleAddress(name))
+}
+
+// Sends a transaction from keyName on chain paying a fee of fee in
+// chain's denom, so that there is a known amount of fees to
+// distribute. The transaction itself is a send of one token to
+// keyName's own address.
+func PayFee(ctx context.Context, chain *cosmos.CosmosChain, keyName string, fee int64) error {
+	address, err := chain.GetAddress(ctx, keyName)
+	if err != nil {
+		return err
+	}
+	bech32, err := sdk.Bech32ifyAddressBytes(chain.Config().Bech32Prefix, address)
+	if err != nil {
+		return err
+	}
+	cmd := []string{chain.Config().Bin, "tx", "bank", "send",
+		keyName,
+		bech32,
+		"1" + chain.Config().Denom,
+		"--fees", strconv.FormatInt(fee, 10) + chain.Config().Denom,
+		"--output", "json",
+		"-b", "block",
+		"--node", chain.GetRPCAddress(),
+		"--home", chain.HomeDir(),
+		"--chain-id", chain.Config().ChainID,
+		"--keyring-backend", keyring.BackendTest,
+		"-y",
+	}
+	_, err = execTx(ctx, chain, cmd)
+	return err
+}
+
+// The response to `query bank total --denom`.
+type totalSupplyQueryResponse struct {
+	Denom  string `json:"denom"`
+	Amount string `json:"amount"`
+}
+
+// Queries the total supply of denom on chain. This is zero for a denom
+// chain has never minted.
+func QueryTotalSupply(ctx context.Context, chain *cosmos.CosmosChain, denom string) (int64, error) {
+	stdout, _, err := chain.Exec(ctx, queryCommand(chain, "bank", "total", "--denom", denom), nil)
+	if err != nil {
+		return 0, err
+	}
+	return parseTotalSupply(stdout)
+}
+
+func parseTotalSupply(stdout []byte) (int64, error) {
+	var response totalSupplyQueryResponse
+	if err := json.Unmarshal(stdout, &response); err != nil {
+		return 0, fmt.Errorf("failed to unmarshal total supply: %w", err)
+	}
+	amount, err := strconv.ParseInt(response.Amount, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid total supply of %s: %w", response.Denom, err)
+	}
+	return amount, nil
+}
+
+// The response to `query feeburner total-burned-neutrons-amount`.
+type totalBurnedNeutronsQueryResponse struct {
+	TotalBurnedNeutronsAmount struct {
+		Coin struct {
+			Denom  string `json:"denom"`
+			Amount string `json:"amount"`
+		} `json:"coin"`
+	} `json:"total_burned_neutrons_amount"`
+}
+
+// Queries how much untrn Neutron's feeburner module has burned since
+// genesis. Every block it burns the untrn that Neutron kept of the
+// fees paid, which lands in `consumerRedistributeAccount`.
+func QueryTotalBurnedNeutrons(ctx context.Context, neutron *cosmos.CosmosChain) (int64, error) {
+	stdout, _, err := neutron.Exec(ctx, queryCommand(neutron, "feeburner", "total-burned-neutrons-amount"), nil)
+	if err != nil {
+		return 0, err
+	}
+	return parseTotalBurnedNeutrons(stdout)
+}
+
+func parseTotalBurnedNeutrons(stdout []byte) (int64, error) {
+	var response totalBurnedNeutronsQueryResponse
+	if err := json.Unmarshal(stdout, &response); err != nil {
+		return 0, fmt.Errorf("failed to unmarshal total burned neutrons: %w", err)
+	}
+	amount := response.TotalBurnedNeutronsAmount.Coin.Amount
+	if amount == "" {
+		return 0, nil
+	}
+	burned, err := strconv.ParseInt(amount, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid total burned neutrons %q: %w", amount, err)
+	}
+	return burned, nil
+}
+
+// Returns the denom on the provider of the rewards Neutron sends it,
+// once the transfer channel they go over is open.
+func providerRewardDenom(t *testing.T, env *icsTestEnv) string {
+	t.Helper()
+	ctx, neutron := env.ctx, env.neutron
+	neutronChainID, denom := neutron.Config().ChainID, neutron.Config().Denom
+
+	// Neutron opens the transfer channel it sends rewards over once
+	// its CCV channel opens.
+	channelID, err := QueryConsumerParam(ctx, neutron, "DistributionTransmissionChannel")
+	require.NoError(t, err)
+	require.NotEmpty(t, channelID, "no distribution transmission channel")
+	err = WaitForChannelState(ctx, env.relayer, env.eRep, neutronChainID, channelID, "STATE_OPEN", 2*time.Minute)
+	require.NoError(t, err)
+	channels, err := ListChannels(ctx, env.relayer, env.eRep, neutronChainID)
+	require.NoError(t, err)
+	var ibcDenom string
+	for _, channel := range channels {
+		if channel.ChannelID == channelID {
+			ibcDenom = IBCDenom(channel.Counterparty.PortID, channel.Counterparty.ChannelID, denom)
+		}
+	}
+	require.NotEmpty(t, ibcDenom)
+	return ibcDenom
+}
+
+// Tests the reward pipeline from Neutron to the provider. A fee is
+// paid on Neutron, which keeps its configured fraction and sends the
+// rest to the provider within a transmission interval. Transactions
+// here are otherwise free, so this fee is all there is to distribute.
+// Neutron's feeburner burns the kept share in the block it is split
+// off, so `consumerRedistributeAccount` is always empty by the time
+// it can be queried, and the kept share is measured by what was
+// burned instead.
+func TestRewardDistribution(t *testing.T) {
+	const (
+		fraction              = "0.5"
+		blocksPerTransmission = 5
+		fee                   = 1_000_000
+	)
+	env := setupICSTestWithConfig(t, icsTestConfig{
+		neutronRedistributionFraction:            fraction,
+		neutronBlocksPerDistributionTransmission: blocksPerTransmission,
+	})
+	ctx, atom, neutron := env.ctx, env.atom, env.neutron
+	denom := neutron.Config().Denom
+
+	adopted, err := QueryConsumerParam(ctx, neutron, "ConsumerRedistributionFraction")
+	require.NoError(t, err)
+	require.Equal(t, sdk.MustNewDecFromStr(fraction), sdk.MustNewDecFromStr(adopted))
+	adopted, err = QueryConsumerParam(ctx, neutron, "BlocksPerDistributionTransmission")
+	require.NoError(t, err)
+	require.Equal(t, strconv.Itoa(blocksPerTransmission), adopted)
+
+	ibcDenom := providerRewardDenom(t, env)
+
+	toSend, err := moduleAddress(neutron, consumerToSendToProviderAccount)
+	require.NoError(t, err)
+	// The provider's fee collector passes what it receives on to
+	// the distribution module at the start of each block.
+	providerRewards, err := moduleAddress(atom, "distribution")
+	require.NoError(t, err)
+
+	burnedBefore, err := QueryTotalBurnedNeutrons(ctx, neutron)
+	require.NoError(t, err)
+	rewardsBefore, err := queryBalance(ctx, atom, providerRewards, ibcDenom)
+	require.NoError(t, err)
+
+	err = PayFee(ctx, neutron, env.neutronUser.KeyName, fee)
+	require.NoError(t, err)
+	paidHeight, err := CurrentHeight(ctx, neutron)
+	require.NoError(t, err)
+
+	// Fees are split, and the kept share burned, at the end of the
+	// block they are paid in.
+	wantKept := sdk.MustNewDecFromStr(fraction).MulInt64(fee).TruncateInt64()
+	wantSent := fee - wantKept
+	burnedAfter, err := QueryTotalBurnedNeutrons(ctx, neutron)
+	require.NoError(t, err)
+	require.Equal(t, burnedBefore+wantKept, burnedAfter, "Neutron should keep, and burn, %s of fees", fraction)
+
+	// The provider's share leaves within one transmission interval.
+	err = testutil.WaitForBlocks(ctx, blocksPerTransmission+1, neutron)
+	require.NoError(t, err)
+	pending, err := queryBalance(ctx, neutron, toSend, denom)
+	require.NoError(t, err)
+	blocks, err := BlocksSince(ctx, neutron, paidHeight)
+	require.NoError(t, err)
+	require.Zero(t, pending, "provider's share still on Neutron %d blocks after the fee was paid", blocks)
+
+	rewardsAfter, err := WaitForBalance(ctx, atom, providerRewards, ibcDenom, rewardsBefore+wantSent, 2*time.Minute)
+	require.NoError(t, err, "provider never received its share")
+	require.Equal(t, rewardsBefore+wantSent, rewardsAfter)
+}
+
+// Tests how distributing rewards changes supply. Neutron has no mint
+// module, so no inflation, and its feeburner burns the share of fees
+// Neutron keeps. The provider's share is escrowed when it is sent, so
+// Neutron's supply drops by exactly the kept share. The provider
+// mints exactly its share as vouchers. The provider's own staking
+// denom inflates every block, so only the voucher's supply is checked
+// there.
+func TestRewardSupply(t *testing.T) {
+	const (
+		fraction              = "0.5"
+		blocksPerTransmission = 5
+		fee                   = 1_000_000
+	)
+	env := setupICSTestWithConfig(t, icsTestConfig{
+		neutronRedistributionFraction:            fraction,
+		neutronBlocksPerDistributionTransmission: blocksPerTransmission,
+	})
+	ctx, atom, neutron := env.ctx, env.atom, env.neutron
+	denom := neutron.Config().Denom
+	ibcDenom := providerRewardDenom(t, env)
+	providerRewards, err := moduleAddress(atom, "distribution")
+	require.NoError(t, err)
+
+	supplyBefore, err := QueryTotalSupply(ctx, neutron, denom)
+	require.NoError(t, err)
+	require.Positive(t, supplyBefore)
+	burnedBefore, err := QueryTotalBurnedNeutrons(ctx, neutron)
+	require.NoError(t, err)
+	vouchersBefore, err := QueryTotalSupply(ctx, atom, ibcDenom)
+	require.NoError(t, err)
+	rewardsBefore, err := queryBalance(ctx, atom, providerRewards, ibcDenom)
+	require.NoError(t, err)
+
+	err = PayFee(ctx, neutron, env.neutronUser.KeyName, fee)
+	require.NoError(t, err)
+	wantKept := sdk.MustNewDecFromStr(fraction).MulInt64(fee).TruncateInt64()
+	wantSent := fee - wantKept
+	_, err = WaitForBalance(ctx, atom, providerRewards, ibcDenom, rewardsBefore+wantSent, 2*time.Minute)
+	require.NoError(t, err, "provider never received its share")
+
+	supplyAfter, err := QueryTotalSupply(ctx, neutron, denom)
+	require.NoError(t, err)
+	require.Equal(t, supplyBefore-wantKept, supplyAfter, "Neutron should burn its kept share of %s, and only that", denom)
+	burnedAfter, err := QueryTotalBurnedNeutrons(ctx, neutron)
+	require.NoError(t, err)
+	require.Equal(t, supplyBefore-supplyAfter, burnedAfter-burnedBefore, "feeburner should account for all of the drop in supply")
+	vouchersAfter, err := QueryTotalSupply(ctx, atom, ibcDenom)
+	require.NoError(t, err)
+	require.Equal(t, vouchersBefore+wantSent, vouchersAfter, "the provider should mint vouchers for exactly its share")
+}
+
+func TestParseTotalBurnedNeutrons(t *testing.T) {
+	burned, err := parseTotalBurnedNeutrons([]byte(`{"total_burned_neutrons_amount":{"coin":{"denom":"untrn","amount":"500000"}}}`))
+	require.NoError(t, err)
+	require.Equal(t, int64(500_000), burned)
+
+	// Nothing burned yet.
+	burned, err = parseTotalBurnedNeutrons([]byte(`{"total_burned_neutrons_amount":{"coin":{"denom":"","amount":""}}}`))
+	require.NoError(t, err)
+	require.Zero(t, burned)
+
+	_, err = parseTotalBurnedNeutrons([]byte(`{"total_burned_neutrons_amount":{"coin":{"denom":"untrn","amount":"x"}}}`))
+	require.ErrorContains(t, err, "invalid total burned neutrons")
+}
+
+func TestParseTotalSupply(t *testing.T) {
+	supply, err := parseTotalSupply([]byte(`{"denom":"untrn","amount":"100000000000000"}`))
+	require.NoError(t, err)
+	require.Equal(t, int64(100_000_000_000_000), supply)
+
+	// The SDK reports a supply of zero for a denom it doesn't know.
+	supply, err = parseTotalSupply([]byte(`{"denom":"ufoo","amount":"0"}`))
+	require.NoError(t, err)
+	require.Zero(t, supply)
+
+	_, err = parseTotalSupply([]byte(`{"denom":"untrn","amount":"1.5"}`))
+	require.ErrorContains(t, err, "invalid total supply of untrn")
+	_, err = parseTotalSupply([]byte("not json"))
+	require.Error(t, err)
+}
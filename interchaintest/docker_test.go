@@ -0,0 +1,166 @@
+package ibc_test
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	dockertypes "github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	dockerclient "github.com/docker/docker/client"
+	ibctest "github.com/strangelove-ventures/interchaintest/v3"
+	"github.com/strangelove-ventures/interchaintest/v3/ibc"
+	"github.com/strangelove-ventures/interchaintest/v3/relayer"
+	"github.com/strangelove-ventures/interchaintest/v3/testreporter"
+	"go.uber.org/zap/zaptest"
+)
+
+// How long to wait for the Docker daemon to answer before deciding
+// that it is not there.
+const dockerPingTimeout = 5 * time.Second
+
+var (
+	dockerOnce sync.Once
+	dockerErr  error
+)
+
+// Skips t if the Docker daemon can not be reached. Integration tests
+// run their chains and relayer in Docker, and without it fail deep
+// inside interchaintest with an error that doesn't say why. The
+// daemon is only checked once per test run.
+func requireDocker(t *testing.T) {
+	t.Helper()
+	dockerOnce.Do(func() {
+		dockerErr = pingDocker()
+	})
+	if dockerErr != nil {
+		t.Skipf("skipping as Docker is unavailable (use -short to run only the tests that don't need it): %s", dockerErr)
+	}
+}
+
+// Pings the Docker daemon that the environment (DOCKER_HOST and
+// friends) points at.
+func pingDocker() error {
+	client, err := dockerclient.NewClientWithOpts(dockerclient.FromEnv, dockerclient.WithAPIVersionNegotiation())
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), dockerPingTimeout)
+	defer cancel()
+	_, err = client.Ping(ctx)
+	return err
+}
+
+// The label interchaintest puts on the Docker resources it creates
+// for a test, set to the test's name.
+const dockerCleanupLabel = "ibc-test"
+
+// What the names of the containers interchaintest runs the relayer in
+// start with, whichever image it runs.
+const relayerContainerPrefix = "rly-"
+
+// What a test has brought up and so needs tearing down. Setup fills
+// this in as it goes, so a test that fails halfway through tears down
+// only what it got to.
+type interchainTeardown struct {
+	// Set once the interchain has been built, or has at least
+	// tried to be.
+	ic *ibctest.Interchain
+	// Relayers that have been started, with the name to log them
+	// by.
+	relayers []startedRelayer
+}
+
+type startedRelayer struct {
+	name    string
+	relayer ibc.Relayer
+}
+
+// Records that r has been started and needs stopping.
+func (td *interchainTeardown) started(name string, r ibc.Relayer) {
+	td.relayers = append(td.relayers, startedRelayer{name, r})
+}
+
+// Tears down what td records, for use in t.Cleanup. Relayers are
+// stopped and the interchain closed, with failures logged rather than
+// failing the test as they say nothing about what it tested. Relayer
+// containers still running afterwards do fail the test: they outlive
+// the run and pollute CI machines. If prune is set the stopped relayer
+// containers are removed as well, rather than left for
+// interchaintest's own cleanup, which skips them if it is
+// interrupted.
+func cleanupInterchain(t *testing.T, ctx context.Context, client *dockerclient.Client, eRep *testreporter.RelayerExecReporter, td *interchainTeardown, prune bool) {
+	t.Helper()
+	for _, r := range td.relayers {
+		if err := r.relayer.StopRelayer(ctx, eRep); err != nil {
+			t.Logf("failed to stop %s: %s", r.name, err)
+		}
+	}
+	if td.ic != nil {
+		if err := td.ic.Close(); err != nil {
+			t.Logf("failed to close interchain: %s", err)
+		}
+	}
+	if len(td.relayers) == 0 {
+		return
+	}
+
+	containers, err := client.ContainerList(ctx, dockertypes.ContainerListOptions{
+		All: true,
+		Filters: filters.NewArgs(
+			filters.Arg("label", dockerCleanupLabel+"="+t.Name()),
+		),
+	})
+	if err != nil {
+		t.Logf("failed to list relayer containers: %s", err)
+		return
+	}
+	for _, c := range containers {
+		name := strings.TrimPrefix(strings.Join(c.Names, ","), "/")
+		// The chains' containers carry the same label and are
+		// still running until interchaintest's own cleanup.
+		if !strings.HasPrefix(name, relayerContainerPrefix) {
+			continue
+		}
+		if c.State == "running" {
+			t.Errorf("relayer container %s still running after teardown", name)
+			continue
+		}
+		if prune {
+			err := client.ContainerRemove(ctx, c.ID, dockertypes.ContainerRemoveOptions{RemoveVolumes: true})
+			if err != nil {
+				t.Logf("failed to remove relayer container %s: %s", name, err)
+			}
+		}
+	}
+}
+
+// Tests that teardown copes with setup that failed partway: the
+// relayer here was recorded as started but its container was never
+// created, and the interchain was never built.
+func TestCleanupInterchainPartialSetup(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping in short mode")
+	}
+	requireDocker(t)
+
+	t.Parallel()
+
+	ctx := context.Background()
+	client, network := ibctest.DockerSetup(t)
+	rf := ibctest.NewBuiltinRelayerFactory(
+		ibc.CosmosRly,
+		zaptest.NewLogger(t),
+		relayer.CustomDockerImage(relayerImage.Repository, relayerImage.Version, relayerImage.UidGid),
+	)
+	r := rf.Build(t, client, network)
+	eRep := testreporter.NewNopReporter().RelayerExecReporter(t)
+
+	teardown := &interchainTeardown{}
+	teardown.started("relayer", r)
+	cleanupInterchain(t, ctx, client, eRep, teardown, true)
+}
@@ -0,0 +1,194 @@
+package ibc_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/cosmos/cosmos-sdk/crypto/keyring"
+	"github.com/strangelove-ventures/interchaintest/v3/chain/cosmos"
+	"github.com/strangelove-ventures/interchaintest/v3/ibc"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// Options for a contract execute transaction. The zero value of each
+// field keeps the default.
+type TxOptions struct {
+	// Funds to send to the contract with the message, for example
+	// "1000untrn". Defaults to none.
+	Amount string
+	// Defaults to the chain's gas prices.
+	GasPrices string
+	// The multiplier on the simulated gas. Defaults to 1.5.
+	GasAdjustment string
+	// Defaults to "block", so that the response holds the
+	// transaction's result. With "sync", the transaction may still
+	// fail after the command returns.
+	BroadcastMode string
+}
+
+// Builds a command that executes msg on contract, signing with
+// keyName. Gas is always simulated with `--gas auto`, as
+// interchaintest's `ExecuteContract` does not do so and runs out of
+// gas on non-trivial executions.
+//
+// ref: <https://github.com/strangelove-ventures/interchaintest/pull/483>
+func executeContractCommand(chain *cosmos.CosmosChain, keyName, contract string, msg interface{}, opts TxOptions) ([]string, error) {
+	bz, err := json.Marshal(msg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal execute message: %w", err)
+	}
+	cmd := []string{chain.Config().Bin, "tx", "wasm", "execute",
+		contract,
+		string(bz),
+	}
+	return append(cmd, txFlags(chain, keyName, opts)...), nil
+}
+
+// Returns the flags that sign a transaction with keyName and
+// broadcast it to chain, with the defaults of `TxOptions` for those
+// opts leaves unset. Gas is always simulated.
+func txFlags(chain *cosmos.CosmosChain, keyName string, opts TxOptions) []string {
+	gasPrices := opts.GasPrices
+	if gasPrices == "" {
+		gasPrices = chain.Config().GasPrices
+	}
+	gasAdjustment := opts.GasAdjustment
+	if gasAdjustment == "" {
+		gasAdjustment = "1.5"
+	}
+	broadcastMode := opts.BroadcastMode
+	if broadcastMode == "" {
+		broadcastMode = "block"
+	}
+	flags := []string{
+		"--from", keyName,
+		"--gas-prices", gasPrices,
+		"--gas-adjustment", gasAdjustment,
+		"--gas", "auto",
+		"--output", "json",
+		"-b", broadcastMode,
+		"--node", chain.GetRPCAddress(),
+		"--home", chain.HomeDir(),
+		"--chain-id", chain.Config().ChainID,
+		"--keyring-backend", keyring.BackendTest,
+		"-y",
+	}
+	if opts.Amount != "" {
+		flags = append(flags, "--amount", opts.Amount)
+	}
+	return flags
+}
+
+// Executes msg, marshalled as JSON, on contract, signing with keyName.
+// Returns an error if the transaction is rejected, unless it was
+// broadcast in a mode other than block, in which case only the
+// mempool's checks are reflected.
+func ExecuteContractRaw(ctx context.Context, chain *cosmos.CosmosChain, keyName, contract string, msg interface{}, opts TxOptions) error {
+	cmd, err := executeContractCommand(chain, keyName, contract, msg, opts)
+	if err != nil {
+		return err
+	}
+	_, err = execTx(ctx, chain, cmd)
+	return err
+}
+
+// Returns the flags that appear more than once in cmd. Repeated flags
+// are not an error to the Cosmos SDK CLI, which silently uses the last
+// value, so builders must avoid them.
+func duplicateFlags(cmd []string) []string {
+	seen := make(map[string]bool)
+	var duplicates []string
+	for _, arg := range cmd {
+		if !strings.HasPrefix(arg, "-") {
+			continue
+		}
+		flag := strings.SplitN(arg, "=", 2)[0]
+		if seen[flag] {
+			duplicates = append(duplicates, flag)
+		}
+		seen[flag] = true
+	}
+	return duplicates
+}
+
+// Makes a chain with a single validator that has no container. Only
+// good for building commands, not for running them.
+func offlineChain(config ibc.ChainConfig) *cosmos.CosmosChain {
+	chain := cosmos.NewCosmosChain("offline", config, 1, 0, zap.NewNop())
+	chain.Validators = cosmos.ChainNodes{{Chain: chain, TestName: "offline", Validator: true}}
+	return chain
+}
+
+func TestExecuteContractCommand(t *testing.T) {
+	chain := offlineChain(ibc.ChainConfig{Name: "neutron", ChainID: "neutron-2", Bin: "neutrond", GasPrices: "0.0untrn"})
+	msg := IcaExampleContractExecute{SubmitTx: newSubmitTxMsg("test", "", 0)}
+
+	cmd, err := executeContractCommand(chain, "key", "contract", msg, TxOptions{})
+	require.NoError(t, err)
+	require.Empty(t, duplicateFlags(cmd))
+	require.Contains(t, strings.Join(cmd, " "), "-b block")
+	require.NotContains(t, cmd, "--amount")
+
+	cmd, err = executeContractCommand(chain, "key", "contract", msg, TxOptions{
+		Amount:        "1000untrn",
+		GasPrices:     "0.025untrn",
+		GasAdjustment: "2",
+		BroadcastMode: "sync",
+	})
+	require.NoError(t, err)
+	require.Empty(t, duplicateFlags(cmd))
+	joined := strings.Join(cmd, " ")
+	for _, flag := range []string{"--amount 1000untrn", "--gas-prices 0.025untrn", "--gas-adjustment 2", "-b sync", "--gas auto"} {
+		require.Contains(t, joined, flag)
+	}
+	require.Contains(t, cmd, `{"submit_tx":{"interchain_account_id":"test","msgs":[]}}`)
+}
+
+func TestMigrateContractCommand(t *testing.T) {
+	chain := offlineChain(ibc.ChainConfig{Name: "neutron", ChainID: "neutron-2", Bin: "neutrond-custom", GasPrices: "0.0untrn"})
+	cmd, err := migrateContractCommand(chain, "admin", "contract", "7", map[string]string{}, TxOptions{})
+	require.NoError(t, err)
+	require.Empty(t, duplicateFlags(cmd))
+	require.Equal(t, []string{"neutrond-custom", "tx", "wasm", "migrate", "contract", "7", "{}"}, cmd[:7])
+
+	joined := strings.Join(cmd, " ")
+	for _, flag := range []string{"--from admin", "--gas-prices 0.0untrn", "--gas-adjustment 1.5", "-b block", "--gas auto"} {
+		require.Contains(t, joined, flag)
+	}
+}
+
+// Guards against the register command signing with, or reading the
+// keyring of, anything other than what it is given.
+func TestRegisterCommand(t *testing.T) {
+	chain := offlineChain(ibc.ChainConfig{Name: "neutron", ChainID: "neutron-2", Bin: "neutrond", GasPrices: "0.0untrn"})
+	cmd, err := registerCommand(chain, "user", "contract", "connection-0", "test")
+	require.NoError(t, err)
+	require.Empty(t, duplicateFlags(cmd))
+
+	joined := strings.Join(cmd, " ")
+	require.Contains(t, joined, "--from user")
+	require.Contains(t, joined, "--home "+chain.HomeDir())
+	require.Contains(t, joined, "-b block", "registering should wait for the transaction's result")
+	require.Contains(t, cmd, `{"register":{"connection_id":"connection-0","interchain_account_id":"test"}}`)
+}
+
+func TestDuplicateFlags(t *testing.T) {
+	require.Empty(t, duplicateFlags([]string{"tx", "--from", "a", "--home", "h", "-y"}))
+	require.Equal(t, []string{"--from", "--home"}, duplicateFlags([]string{"--from", "a", "--home", "h", "--from", "b", "--home=h2"}))
+}
+
+// The register transaction is signed by the key it is given, so any
+// user `GetAndFundTestUsers` returns can register accounts without
+// the genesis faucet.
+func TestRegisterCommandSigner(t *testing.T) {
+	chain := offlineChain(ibc.ChainConfig{Name: "neutron", ChainID: "neutron-2", Bin: "neutrond", GasPrices: "0.0untrn"})
+
+	cmd, err := registerCommand(chain, "registrant", "contract", "connection-0", "test")
+	require.NoError(t, err)
+	require.Contains(t, strings.Join(cmd, " "), "--from registrant")
+	require.NotContains(t, cmd, faucetKeyName)
+}
@@ -0,0 +1,303 @@
+package ibc_test
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"testing"
+
+	banktypes "github.com/cosmos/cosmos-sdk/x/bank/types"
+	"github.com/icza/dyno"
+	"github.com/strangelove-ventures/interchaintest/v3/ibc"
+	"github.com/stretchr/testify/require"
+)
+
+// Just the parts of a Neutron genesis file that `setupNeutronGenesis`
+// modifies.
+const minimalNeutronGenesis = `{
+  "app_state": {
+    "bank": {"denom_metadata": []},
+    "ccvconsumer": {"params": {}}
+  }
+}`
+
+// Runs modifyGenesis over `minimalNeutronGenesis` and returns the
+// result, decoded as generic JSON.
+func modifyTestGenesis(t *testing.T, modifyGenesis func(ibc.ChainConfig, []byte) ([]byte, error)) map[string]interface{} {
+	t.Helper()
+	return applyGenesis(t, modifyGenesis, []byte(minimalNeutronGenesis))
+}
+
+// Runs modifyGenesis over the fixture at path and returns the result,
+// decoded as generic JSON. This exercises a genesis modifier the way
+// interchaintest does when it starts a chain, without Docker.
+func modifyGenesisFixture(t *testing.T, path string, modifyGenesis func(ibc.ChainConfig, []byte) ([]byte, error)) map[string]interface{} {
+	t.Helper()
+	genbz, err := os.ReadFile(path)
+	require.NoError(t, err)
+	return applyGenesis(t, modifyGenesis, genbz)
+}
+
+// Runs modifyGenesis over genbz and decodes the result.
+func applyGenesis(t *testing.T, modifyGenesis func(ibc.ChainConfig, []byte) ([]byte, error), genbz []byte) map[string]interface{} {
+	t.Helper()
+	bz, err := modifyGenesis(ibc.ChainConfig{}, genbz)
+	require.NoError(t, err)
+	g := make(map[string]interface{})
+	require.NoError(t, json.Unmarshal(bz, &g))
+	return g
+}
+
+func TestNeutronGenesisRewardDenoms(t *testing.T) {
+	metadata := banktypes.Metadata{
+		Description: "The native staking token of Neutron.",
+		DenomUnits: []*banktypes.DenomUnit{
+			{Denom: "untrn", Exponent: 0},
+			{Denom: "ntrn", Exponent: 6},
+		},
+		Base:    "untrn",
+		Display: "ntrn",
+		Name:    "Neutron",
+		Symbol:  "NTRN",
+	}
+	g := modifyTestGenesis(t, setupNeutronGenesis("0.05", "", "", []string{"untrn", "ustake"}, []string{"uatom"}, []banktypes.Metadata{metadata}, nil))
+
+	rewardDenoms, err := dyno.GetSlice(g, "app_state", "ccvconsumer", "params", "reward_denoms")
+	require.NoError(t, err)
+	require.Equal(t, []interface{}{"untrn", "ustake"}, rewardDenoms)
+
+	denomMetadata, err := dyno.GetSlice(g, "app_state", "bank", "denom_metadata")
+	require.NoError(t, err)
+	require.Len(t, denomMetadata, 1)
+	bz, err := json.Marshal(denomMetadata[0])
+	require.NoError(t, err)
+	var decoded banktypes.Metadata
+	require.NoError(t, json.Unmarshal(bz, &decoded))
+	require.Equal(t, metadata, decoded)
+}
+
+// Setup gives Neutron's genesis the reward denoms and metadata its
+// config asks for, and otherwise rewards in untrn, sent on as uatom.
+func TestICSChainSpecsRewardDenoms(t *testing.T) {
+	neutronGenesis := func(config icsTestConfig) map[string]interface{} {
+		return modifyTestGenesis(t, icsChainSpecs(config, defaultNeutronImage)[1].ModifyGenesis)
+	}
+	params := func(g map[string]interface{}) (rewardDenoms, providerRewardDenoms []interface{}) {
+		rewardDenoms, err := dyno.GetSlice(g, "app_state", "ccvconsumer", "params", "reward_denoms")
+		require.NoError(t, err)
+		providerRewardDenoms, err = dyno.GetSlice(g, "app_state", "ccvconsumer", "params", "provider_reward_denoms")
+		require.NoError(t, err)
+		return rewardDenoms, providerRewardDenoms
+	}
+
+	rewardDenoms, providerRewardDenoms := params(neutronGenesis(icsTestConfig{}))
+	require.Equal(t, []interface{}{"untrn"}, rewardDenoms)
+	require.Equal(t, []interface{}{"uatom"}, providerRewardDenoms)
+
+	metadata := banktypes.Metadata{Base: "ustake", Display: "stake", DenomUnits: []*banktypes.DenomUnit{{Denom: "ustake"}}}
+	g := neutronGenesis(icsTestConfig{
+		neutronRewardDenoms:         []string{"untrn", "ustake"},
+		neutronProviderRewardDenoms: []string{},
+		neutronDenomMetadata:        []banktypes.Metadata{metadata},
+	})
+	rewardDenoms, providerRewardDenoms = params(g)
+	require.Equal(t, []interface{}{"untrn", "ustake"}, rewardDenoms)
+	require.Empty(t, providerRewardDenoms)
+	denomMetadata, err := dyno.GetSlice(g, "app_state", "bank", "denom_metadata")
+	require.NoError(t, err)
+	require.Len(t, denomMetadata, 1)
+}
+
+// An empty list of denoms should be written as `[]`. A `null` is
+// rejected by the consumer module when the chain starts.
+func TestNeutronGenesisEmptyRewardDenoms(t *testing.T) {
+	for name, denoms := range map[string][]string{
+		"empty": {},
+		"nil":   nil,
+	} {
+		t.Run(name, func(t *testing.T) {
+			g := modifyTestGenesis(t, setupNeutronGenesis("0.05", "", "", denoms, denoms, nil, nil))
+
+			for _, field := range []string{"reward_denoms", "provider_reward_denoms"} {
+				value, err := dyno.Get(g, "app_state", "ccvconsumer", "params", field)
+				require.NoError(t, err)
+				require.Equal(t, []interface{}{}, value, "%s should be an empty array", field)
+			}
+
+			denomMetadata, err := dyno.GetSlice(g, "app_state", "bank", "denom_metadata")
+			require.NoError(t, err)
+			require.Empty(t, denomMetadata)
+		})
+	}
+}
+
+// Applies `setupNeutronGenesis` to a full Neutron genesis file and
+// checks that the consumer params it owns are set while the rest of
+// the file is left alone.
+func TestNeutronGenesisFixture(t *testing.T) {
+	g := modifyGenesisFixture(t, "testdata/neutron_genesis.json", setupNeutronGenesis("0.05", "", "", []string{"untrn"}, []string{"uatom"}, nil, nil))
+
+	params, err := dyno.GetMapS(g, "app_state", "ccvconsumer", "params")
+	require.NoError(t, err)
+	require.Equal(t, "0.05", params["soft_opt_out_threshold"])
+	require.Equal(t, []interface{}{"untrn"}, params["reward_denoms"])
+	require.Equal(t, []interface{}{"uatom"}, params["provider_reward_denoms"])
+
+	// Untouched params and modules survive the round trip.
+	require.Equal(t, "1000", params["blocks_per_distribution_transmission"])
+	require.Equal(t, "0.75", params["consumer_redistribution_fraction"])
+	chainID, err := dyno.GetString(g, "chain_id")
+	require.NoError(t, err)
+	require.Equal(t, "neutron-2", chainID)
+	hostPort, err := dyno.GetString(g, "app_state", "interchainaccounts", "host_genesis_state", "port")
+	require.NoError(t, err)
+	require.Equal(t, "icahost", hostPort)
+}
+
+func TestNeutronGenesisDistribution(t *testing.T) {
+	g := modifyGenesisFixture(t, "testdata/neutron_genesis.json", setupNeutronGenesis("0.05", "0.5", "5", []string{"untrn"}, []string{"uatom"}, nil, nil))
+
+	params, err := dyno.GetMapS(g, "app_state", "ccvconsumer", "params")
+	require.NoError(t, err)
+	require.Equal(t, "0.5", params["consumer_redistribution_fraction"])
+	require.Equal(t, "5", params["blocks_per_distribution_transmission"])
+}
+
+// Just the parts of a Gaia genesis file that the tests below modify.
+const minimalGaiaGenesis = `{
+  "app_state": {
+    "gov": {
+      "deposit_params": {"max_deposit_period": "172800s"},
+      "voting_params": {"voting_period": "172800s"}
+    },
+    "staking": {"params": {"unbonding_time": "1814400s"}}
+  }
+}`
+
+func TestGaiaGenesisOverrides(t *testing.T) {
+	g := applyGenesis(t, setupGaiaGenesis("", "", map[string]interface{}{
+		"app_state.gov.voting_params.voting_period": "30s",
+	}), []byte(minimalGaiaGenesis))
+
+	votingPeriod, err := dyno.GetString(g, "app_state", "gov", "voting_params", "voting_period")
+	require.NoError(t, err)
+	require.Equal(t, "30s", votingPeriod)
+	unbondingTime, err := dyno.GetString(g, "app_state", "staking", "params", "unbonding_time")
+	require.NoError(t, err)
+	require.Equal(t, "1814400s", unbondingTime, "unbonding_time should keep its default")
+}
+
+// Overrides are applied after the fields `setupNeutronGenesis` sets
+// itself, so they can replace them.
+func TestNeutronGenesisOverrides(t *testing.T) {
+	g := modifyGenesisFixture(t, "testdata/neutron_genesis.json", setupNeutronGenesis("0.05", "", "", []string{"untrn"}, []string{"uatom"}, nil, map[string]interface{}{
+		"app_state.ccvconsumer.params.soft_opt_out_threshold":               "0.1",
+		"app_state.ccvconsumer.params.blocks_per_distribution_transmission": "10",
+	}))
+
+	params, err := dyno.GetMapS(g, "app_state", "ccvconsumer", "params")
+	require.NoError(t, err)
+	require.Equal(t, "0.1", params["soft_opt_out_threshold"])
+	require.Equal(t, "10", params["blocks_per_distribution_transmission"])
+	require.Equal(t, []interface{}{"untrn"}, params["reward_denoms"])
+}
+
+func TestGenesisOverrideMissingParent(t *testing.T) {
+	_, err := setupGaiaGenesis("", "", map[string]interface{}{
+		"app_state.mint.params.inflation_max": "0.2",
+	})(ibc.ChainConfig{}, []byte(minimalGaiaGenesis))
+	require.ErrorContains(t, err, "app_state.mint.params.inflation_max")
+}
+
+// The gov module's periods live in different places before and after
+// gov v1, and `setupGaiaGenesis` should find them in both.
+func TestGaiaGenesisVotingPeriod(t *testing.T) {
+	t.Run("legacy", func(t *testing.T) {
+		g := applyGenesis(t, setupGaiaGenesis("", "15s", nil), []byte(minimalGaiaGenesis))
+
+		votingPeriod, err := dyno.GetString(g, "app_state", "gov", "voting_params", "voting_period")
+		require.NoError(t, err)
+		require.Equal(t, "15s", votingPeriod)
+		depositPeriod, err := dyno.GetString(g, "app_state", "gov", "deposit_params", "max_deposit_period")
+		require.NoError(t, err)
+		require.Equal(t, "15s", depositPeriod)
+	})
+
+	t.Run("v1", func(t *testing.T) {
+		genesis := `{
+  "app_state": {
+    "gov": {
+      "deposit_params": null,
+      "voting_params": null,
+      "params": {"max_deposit_period": "172800s", "voting_period": "172800s"}
+    }
+  }
+}`
+		g := applyGenesis(t, setupGaiaGenesis("", "15s", nil), []byte(genesis))
+
+		params, err := dyno.GetMapS(g, "app_state", "gov", "params")
+		require.NoError(t, err)
+		require.Equal(t, "15s", params["voting_period"])
+		require.Equal(t, "15s", params["max_deposit_period"])
+		votingParams, err := dyno.Get(g, "app_state", "gov", "voting_params")
+		require.NoError(t, err)
+		require.Nil(t, votingParams)
+	})
+}
+
+// Modifiers compose in order, and the first that fails stops the
+// rest from running.
+func TestChainModifiers(t *testing.T) {
+	appendByte := func(b byte) func(ibc.ChainConfig, []byte) ([]byte, error) {
+		return func(_ ibc.ChainConfig, genbz []byte) ([]byte, error) {
+			return append(genbz, b), nil
+		}
+	}
+
+	out, err := chainModifiers(appendByte('a'), nil, appendByte('b'), appendByte('c'))(ibc.ChainConfig{}, []byte("_"))
+	require.NoError(t, err)
+	require.Equal(t, "_abc", string(out))
+
+	out, err = chainModifiers()(ibc.ChainConfig{}, []byte("_"))
+	require.NoError(t, err)
+	require.Equal(t, "_", string(out))
+
+	ran := false
+	_, err = chainModifiers(
+		appendByte('a'),
+		func(ibc.ChainConfig, []byte) ([]byte, error) { return nil, errors.New("boom") },
+		func(_ ibc.ChainConfig, genbz []byte) ([]byte, error) {
+			ran = true
+			return genbz, nil
+		},
+	)(ibc.ChainConfig{ChainID: "neutron-2"}, []byte("_"))
+	require.ErrorContains(t, err, "genesis modifier 1 of neutron-2: boom")
+	require.False(t, ran, "modifiers after a failing one should not run")
+}
+
+// A test-specific tweak layered on the base ccvconsumer setup sees,
+// and keeps, what the base set.
+func TestChainModifiersLayerNeutronGenesis(t *testing.T) {
+	disableSends := func(_ ibc.ChainConfig, genbz []byte) ([]byte, error) {
+		g := make(map[string]interface{})
+		if err := json.Unmarshal(genbz, &g); err != nil {
+			return nil, err
+		}
+		if err := applyGenesisOverrides(g, map[string]interface{}{"app_state.bank.params.default_send_enabled": false}); err != nil {
+			return nil, err
+		}
+		return json.Marshal(g)
+	}
+
+	g := modifyGenesisFixture(t, "testdata/neutron_genesis.json", chainModifiers(
+		setupNeutronGenesis("0.05", "", "", []string{"untrn"}, []string{"uatom"}, nil, nil),
+		disableSends,
+	))
+
+	sendEnabled, err := dyno.GetBoolean(g, "app_state", "bank", "params", "default_send_enabled")
+	require.NoError(t, err)
+	require.False(t, sendEnabled)
+	threshold, err := dyno.GetString(g, "app_state", "ccvconsumer", "params", "soft_opt_out_threshold")
+	require.NoError(t, err)
+	require.Equal(t, "0.05", threshold)
+}
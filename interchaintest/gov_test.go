@@ -0,0 +1,165 @@
+package ibc_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/cosmos/cosmos-sdk/crypto/keyring"
+	govtypes "github.com/cosmos/cosmos-sdk/x/gov/types"
+	"github.com/strangelove-ventures/interchaintest/v3/chain/cosmos"
+	"github.com/stretchr/testify/require"
+)
+
+// Submits a text proposal on chain from keyName with an initial
+// deposit, such as "10000000uatom", and returns the proposal's ID.
+// The proposal enters its voting period straight away if deposit
+// meets the chain's minimum deposit.
+func SubmitTextProposal(ctx context.Context, chain *cosmos.CosmosChain, keyName, title, deposit string) (uint64, error) {
+	cmd := []string{chain.Config().Bin, "tx", "gov", "submit-proposal",
+		"--type", "Text",
+		"--title", title,
+		"--description", title,
+		"--deposit", deposit,
+		"--from", keyName,
+		"--gas-prices", chain.Config().GasPrices,
+		"--gas-adjustment", `1.5`,
+		"--gas", "auto",
+		"--output", "json",
+		"-b", "block",
+		"--node", chain.GetRPCAddress(),
+		"--home", chain.HomeDir(),
+		"--chain-id", chain.Config().ChainID,
+		"--keyring-backend", keyring.BackendTest,
+		"-y",
+	}
+	response, err := execTx(ctx, chain, cmd)
+	if err != nil {
+		return 0, err
+	}
+	proposalId, ok := response.attribute("submit_proposal", "proposal_id")
+	if !ok {
+		return 0, fmt.Errorf("transaction %s did not submit a proposal", response.TxHash)
+	}
+	return strconv.ParseUint(proposalId, 10, 64)
+}
+
+// The response to `query gov proposal`.
+type proposalQueryResponse struct {
+	Status string `json:"status"`
+}
+
+// Queries the status of proposalId, for example
+// "PROPOSAL_STATUS_VOTING_PERIOD".
+func QueryProposalStatus(ctx context.Context, chain *cosmos.CosmosChain, proposalId uint64) (string, error) {
+	stdout, _, err := chain.Exec(ctx, queryCommand(chain, "gov", "proposal", strconv.FormatUint(proposalId, 10)), nil)
+	if err != nil {
+		return "", err
+	}
+	var response proposalQueryResponse
+	if err := json.Unmarshal(stdout, &response); err != nil {
+		return "", fmt.Errorf("failed to unmarshal proposal: %w", err)
+	}
+	return response.Status, nil
+}
+
+// Polls until proposalId on chain has status, or until timeout
+// elapses. Votes on a proposal that is not in its voting period fail
+// with "inactive proposal", so wait for `govtypes.StatusVotingPeriod`
+// before voting.
+func WaitForProposalStatus(ctx context.Context, chain *cosmos.CosmosChain, proposalId uint64, status string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var last string
+	for {
+		current, err := QueryProposalStatus(ctx, chain, proposalId)
+		if err == nil && current == status {
+			return nil
+		}
+		if err == nil {
+			last = current
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("timed out after %s waiting for proposal %d to be %s, last status: %q", timeout, proposalId, status, last)
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+// The response to `query gov vote`.
+type voteQueryResponse struct {
+	Option string `json:"option"`
+}
+
+// Queries how voter voted on proposalId.
+func QueryVote(ctx context.Context, chain *cosmos.CosmosChain, proposalId uint64, voter string) (string, error) {
+	stdout, _, err := chain.Exec(ctx, queryCommand(chain, "gov", "vote", strconv.FormatUint(proposalId, 10), voter), nil)
+	if err != nil {
+		return "", err
+	}
+	var response voteQueryResponse
+	if err := json.Unmarshal(stdout, &response); err != nil {
+		return "", fmt.Errorf("failed to unmarshal vote: %w", err)
+	}
+	return response.Option, nil
+}
+
+// Tests that an interchain account can vote on a host chain proposal
+// once the proposal is in its voting period.
+func TestICAVote(t *testing.T) {
+	env := setupICSTest(t)
+	ctx, atom, neutron := env.ctx, env.atom, env.neutron
+	votingPeriod := govtypes.StatusVotingPeriod.String()
+
+	contract := deployICAContract(t, env)
+	icaAddress := registerICA(t, env, contract, "test")
+
+	t.Run("vote", func(t *testing.T) {
+		// Gaia's default minimum deposit.
+		proposalId, err := SubmitTextProposal(ctx, atom, env.atomUser.KeyName, "ICA vote", "10000000"+atom.Config().Denom)
+		require.NoError(t, err)
+		err = WaitForProposalStatus(ctx, atom, proposalId, votingPeriod, time.Minute)
+		require.NoError(t, err)
+
+		sequence, err := SubmitICAVote(ctx, neutron, env.neutronUser.KeyName, contract, "test", proposalId, govtypes.OptionYes, 0)
+		require.NoError(t, err)
+		result, err := WaitForAcknowledgement(ctx, neutron, contract, "test", sequence, 2*time.Minute)
+		require.NoError(t, err)
+		require.NotNil(t, result.Success, "expected success, got %+v", result)
+
+		option, err := QueryVote(ctx, atom, proposalId, icaAddress)
+		require.NoError(t, err)
+		require.Equal(t, govtypes.OptionYes.String(), option)
+	})
+
+	t.Run("never enters voting", func(t *testing.T) {
+		// Below the minimum deposit, the proposal stays in its
+		// deposit period.
+		proposalId, err := SubmitTextProposal(ctx, atom, env.atomUser.KeyName, "underfunded", "1"+atom.Config().Denom)
+		require.NoError(t, err)
+		err = WaitForProposalStatus(ctx, atom, proposalId, votingPeriod, 10*time.Second)
+		require.ErrorContains(t, err, "timed out")
+		require.ErrorContains(t, err, govtypes.StatusDepositPeriod.String())
+	})
+}
+
+// Tests that `gaiaVotingPeriod` makes proposals finish quickly. With
+// nobody voting, the proposal fails to reach quorum and is rejected
+// as soon as its voting period ends.
+func TestShortVotingPeriod(t *testing.T) {
+	env := setupICSTestWithConfig(t, icsTestConfig{gaiaVotingPeriod: "15s"})
+	ctx, atom := env.ctx, env.atom
+
+	proposalId, err := SubmitTextProposal(ctx, atom, env.atomUser.KeyName, "short", "10000000"+atom.Config().Denom)
+	require.NoError(t, err)
+	err = WaitForProposalStatus(ctx, atom, proposalId, govtypes.StatusVotingPeriod.String(), 10*time.Second)
+	require.NoError(t, err)
+	err = WaitForProposalStatus(ctx, atom, proposalId, govtypes.StatusRejected.String(), time.Minute)
+	require.NoError(t, err)
+}
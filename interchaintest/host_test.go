@@ -0,0 +1,716 @@
+package ibc_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	sdk "github.com/cosmos/cosmos-sdk/types"
+	"github.com/cosmos/cosmos-sdk/types/address"
+	"github.com/cosmos/cosmos-sdk/types/bech32"
+	authtypes "github.com/cosmos/cosmos-sdk/x/auth/types"
+	"github.com/strangelove-ventures/interchaintest/v3/chain/cosmos"
+	"github.com/strangelove-ventures/interchaintest/v3/ibc"
+	"github.com/stretchr/testify/require"
+)
+
+// Sends amount of the host chain's native denom from keyName to the
+// interchain account at icaAddress.
+func FundICAAccount(ctx context.Context, host *cosmos.CosmosChain, keyName, icaAddress string, amount int64) error {
+	return host.SendFunds(ctx, keyName, ibc.WalletAmount{
+		Address: icaAddress,
+		Denom:   host.Config().Denom,
+		Amount:  amount,
+	})
+}
+
+// Polls until the interchain account at icaAddress holds at least min
+// of denom on provider, or until timeout elapses. `FundICAAccount`
+// returns once its send is in a block, which can be before a query
+// of the account sees the funds, so wait on this before submitting
+// anything that spends them.
+func WaitForICABalance(ctx context.Context, provider *cosmos.CosmosChain, icaAddress, denom string, min int64, timeout time.Duration) error {
+	_, err := WaitForBalance(ctx, provider, icaAddress, denom, min, timeout)
+	return err
+}
+
+// Funds the interchain account at icaAddress on the environment's
+// Atom with amount from the Atom user, and waits for it to hold at
+// least amount.
+func fundICA(t *testing.T, env *icsTestEnv, icaAddress string, amount int64) {
+	t.Helper()
+	err := FundICAAccount(env.ctx, env.atom, env.atomUser.KeyName, icaAddress, amount)
+	require.NoError(t, err, "failed to fund ICA")
+	err = WaitForICABalance(env.ctx, env.atom, icaAddress, env.atom.Config().Denom, amount, time.Minute)
+	require.NoError(t, err, "ICA never received its funds")
+}
+
+// The response to `query staking validators`.
+type validatorsQueryResponse struct {
+	Validators []struct {
+		OperatorAddress string `json:"operator_address"`
+		Jailed          bool   `json:"jailed"`
+		Status          string `json:"status"`
+		Tokens          string `json:"tokens"`
+		ConsensusPubkey struct {
+			Key string `json:"key"`
+		} `json:"consensus_pubkey"`
+	} `json:"validators"`
+}
+
+// Returned by `GetTwoBondedValidators` when the host has fewer than
+// two validators to move stake between.
+var errTooFewValidators = errors.New("too few bonded validators")
+
+// Returns the operator addresses of the bonded, unjailed validators
+// in the response, most stake first. The validator `TriggerVSC`
+// creates is left out: it is bonded until it is jailed for never
+// signing a block, so stake moved to it would soon be slashed.
+func (r *validatorsQueryResponse) bondedByTokens() ([]string, error) {
+	type bonded struct {
+		operator string
+		tokens   sdk.Int
+	}
+	var validators []bonded
+	for _, validator := range r.Validators {
+		if validator.Jailed || validator.Status != "BOND_STATUS_BONDED" || validator.ConsensusPubkey.Key == triggerVSCPubKey {
+			continue
+		}
+		tokens, ok := sdk.NewIntFromString(validator.Tokens)
+		if !ok {
+			return nil, fmt.Errorf("invalid tokens for validator %s: %s", validator.OperatorAddress, validator.Tokens)
+		}
+		validators = append(validators, bonded{validator.OperatorAddress, tokens})
+	}
+	sort.SliceStable(validators, func(i, j int) bool {
+		return validators[i].tokens.GT(validators[j].tokens)
+	})
+	operators := make([]string, len(validators))
+	for i, validator := range validators {
+		operators[i] = validator.operator
+	}
+	return operators, nil
+}
+
+// Queries the bonded, unjailed validators on host, most stake first,
+// not counting the validator `TriggerVSC` creates.
+func queryBondedValidators(ctx context.Context, host *cosmos.CosmosChain) ([]string, error) {
+	var response validatorsQueryResponse
+	if err := QueryHostJSON(ctx, host, []string{"staking", "validators"}, &response); err != nil {
+		return nil, err
+	}
+	return response.bondedByTokens()
+}
+
+// Returns the operator address of the bonded validator on host with
+// the most stake.
+func QueryLargestValidator(ctx context.Context, host *cosmos.CosmosChain) (string, error) {
+	validators, err := queryBondedValidators(ctx, host)
+	if err != nil {
+		return "", err
+	}
+	if len(validators) == 0 {
+		return "", fmt.Errorf("no bonded validators on %s", host.Config().ChainID)
+	}
+	return validators[0], nil
+}
+
+// Returns the operator addresses of the two bonded validators on host
+// with the most stake, to redelegate from src to dst. Returns an
+// error wrapping `errTooFewValidators` if host has only one, besides
+// the validator `TriggerVSC` creates.
+func GetTwoBondedValidators(ctx context.Context, host *cosmos.CosmosChain) (src, dst string, err error) {
+	validators, err := queryBondedValidators(ctx, host)
+	if err != nil {
+		return "", "", err
+	}
+	if len(validators) < 2 {
+		return "", "", fmt.Errorf("%w: %s has %d", errTooFewValidators, host.Config().ChainID, len(validators))
+	}
+	return validators[0], validators[1], nil
+}
+
+// The response to `query staking delegations`.
+type delegationsQueryResponse struct {
+	DelegationResponses []struct {
+		Delegation struct {
+			ValidatorAddress string `json:"validator_address"`
+		} `json:"delegation"`
+		Balance sdk.Coin `json:"balance"`
+	} `json:"delegation_responses"`
+}
+
+// Queries how much delegator has delegated to each validator on host,
+// keyed by operator address. Redelegations show up here as soon as
+// they are made, under the validator they were made to.
+func QueryDelegations(ctx context.Context, host *cosmos.CosmosChain, delegator string) (map[string]int64, error) {
+	var response delegationsQueryResponse
+	if err := QueryHostJSON(ctx, host, []string{"staking", "delegations", delegator}, &response); err != nil {
+		return nil, err
+	}
+	delegations := make(map[string]int64, len(response.DelegationResponses))
+	for _, delegation := range response.DelegationResponses {
+		delegations[delegation.Delegation.ValidatorAddress] = delegation.Balance.Amount.Int64()
+	}
+	return delegations, nil
+}
+
+// The response to `query distribution rewards <delegator> <validator>`.
+type rewardsQueryResponse struct {
+	Rewards sdk.DecCoins `json:"rewards"`
+}
+
+// Queries the whole amount of denom that delegator would receive by
+// withdrawing its rewards from validator on host. Rewards accrue in
+// fractions, and withdrawing truncates them.
+func QueryDelegatorRewards(ctx context.Context, host *cosmos.CosmosChain, delegator, validator, denom string) (int64, error) {
+	var response rewardsQueryResponse
+	if err := QueryHostJSON(ctx, host, []string{"distribution", "rewards", delegator, validator}, &response); err != nil {
+		return 0, err
+	}
+	return response.Rewards.AmountOf(denom).TruncateInt64(), nil
+}
+
+// Queries the whole amount of its native denom that the interchain
+// account at icaAddress would receive by withdrawing its rewards from
+// validator on the provider. See `QueryDelegatorRewards`.
+func QueryICARewards(ctx context.Context, provider *cosmos.CosmosChain, icaAddress, validator string) (int64, error) {
+	return QueryDelegatorRewards(ctx, provider, icaAddress, validator, provider.Config().Denom)
+}
+
+// Polls until delegator has at least one whole unit of denom in
+// rewards from validator to withdraw, or until timeout elapses.
+func WaitForDelegatorRewards(ctx context.Context, host *cosmos.CosmosChain, delegator, validator, denom string, timeout time.Duration) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var lastErr error
+	for {
+		rewards, err := QueryDelegatorRewards(ctx, host, delegator, validator, denom)
+		if err == nil && rewards > 0 {
+			return rewards, nil
+		}
+		if err != nil {
+			lastErr = err
+		}
+
+		select {
+		case <-ctx.Done():
+			if lastErr != nil {
+				return 0, fmt.Errorf("timed out after %s waiting for %s to accrue rewards: %w", timeout, delegator, lastErr)
+			}
+			return 0, fmt.Errorf("timed out after %s waiting for %s to accrue rewards", timeout, delegator)
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+// The response to `query txs`.
+type txSearchResponse struct {
+	TotalCount string `json:"total_count"`
+}
+
+// Parses how many transactions matched from the output of `query
+// txs`.
+func parseTxSearchCount(stdout []byte) (uint64, error) {
+	var response txSearchResponse
+	if err := json.Unmarshal(stdout, &response); err != nil {
+		return 0, fmt.Errorf("failed to unmarshal tx search: %w", err)
+	}
+	count, err := strconv.ParseUint(response.TotalCount, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid total count %q: %w", response.TotalCount, err)
+	}
+	return count, nil
+}
+
+// Reports whether any transaction on the host emitted an event of
+// eventType, for example "transfer", with attrKey set to attrVal.
+// Interchain account messages run inside the relayer's `MsgRecvPacket`
+// transaction, whose events include those of the messages, so this
+// confirms a message ran on the host independently of the contract's
+// acknowledgement.
+func FindHostEvent(ctx context.Context, host *cosmos.CosmosChain, eventType, attrKey, attrVal string) (bool, error) {
+	query := fmt.Sprintf("%s.%s=%s", eventType, attrKey, attrVal)
+	stdout, _, err := host.Exec(ctx, queryCommand(host, "txs", "--events", query, "--limit", "1"), nil)
+	if err != nil {
+		return false, err
+	}
+	count, err := parseTxSearchCount(stdout)
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// The response to `query txs`, with each transaction as `execTx`
+// sees it.
+type txSearchTxsResponse struct {
+	Txs []txResponse `json:"txs"`
+}
+
+// The part of an interchain account packet's data that holds its
+// memo. ibc-go emits packet data as JSON in the `recv_packet` event.
+type icaPacketData struct {
+	Memo string `json:"memo"`
+}
+
+// Returns the first transaction in stdout, the output of `query txs`
+// for the transaction that received a packet.
+func parseRecvPacketTx(stdout []byte) (*txResponse, error) {
+	var response txSearchTxsResponse
+	if err := json.Unmarshal(stdout, &response); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal tx search: %w", err)
+	}
+	if len(response.Txs) == 0 {
+		return nil, errors.New("no transaction received the packet")
+	}
+	return &response.Txs[0], nil
+}
+
+// Parses the memo of the interchain account packet received by the
+// first transaction in stdout, the output of `query txs`.
+func parsePacketMemo(stdout []byte) (string, error) {
+	tx, err := parseRecvPacketTx(stdout)
+	if err != nil {
+		return "", err
+	}
+	data, ok := tx.attribute("recv_packet", "packet_data")
+	if !ok {
+		return "", fmt.Errorf("transaction %s has no packet data", tx.TxHash)
+	}
+	var packet icaPacketData
+	if err := json.Unmarshal([]byte(data), &packet); err != nil {
+		return "", fmt.Errorf("invalid interchain account packet data %q: %w", data, err)
+	}
+	return packet.Memo, nil
+}
+
+// Parses the error the host's interchain accounts module hit handling
+// the packet received by the first transaction in stdout, the output
+// of `query txs`. This is empty if the packet's messages executed, in
+// which case the event has no error attribute at all.
+func parseHostAckError(stdout []byte) (string, error) {
+	tx, err := parseRecvPacketTx(stdout)
+	if err != nil {
+		return "", err
+	}
+	success, ok := tx.attribute("ics27_packet", "success")
+	if !ok {
+		return "", fmt.Errorf("transaction %s has no interchain account packet event", tx.TxHash)
+	}
+	if success == "true" {
+		return "", nil
+	}
+	ackError, ok := tx.attribute("ics27_packet", "error")
+	if !ok {
+		return "", fmt.Errorf("transaction %s failed the interchain account packet without an error", tx.TxHash)
+	}
+	return ackError, nil
+}
+
+// Runs `query txs` for the transaction that received the packet with
+// sequence on channelId, the host's end of an ICA's channel.
+func searchRecvPacket(ctx context.Context, host *cosmos.CosmosChain, channelId string, sequence uint64) ([]byte, error) {
+	query := fmt.Sprintf("recv_packet.packet_dst_channel=%s&recv_packet.packet_sequence=%d", channelId, sequence)
+	stdout, _, err := host.Exec(ctx, queryCommand(host, "txs", "--events", query, "--limit", "1"), nil)
+	return stdout, err
+}
+
+// Queries the memo of the interchain account packet that the host
+// received on channelId, its end of the ICA's channel, with sequence.
+// The memo doesn't become the memo of a host transaction: the host
+// runs the packet's messages inside the relayer's `MsgRecvPacket`
+// transaction, which has the relayer's memo, so this reads it from
+// the packet that transaction received.
+func QueryHostPacketMemo(ctx context.Context, host *cosmos.CosmosChain, channelId string, sequence uint64) (string, error) {
+	stdout, err := searchRecvPacket(ctx, host, channelId, sequence)
+	if err != nil {
+		return "", err
+	}
+	return parsePacketMemo(stdout)
+}
+
+// Queries why the host failed to handle the interchain account packet
+// it received on channelId with sequence. Error acknowledgements only
+// carry an ABCI code, so that they are deterministic, and the host
+// records the error itself in an event of the receiving transaction.
+// Returns an empty string if the packet's messages executed.
+func QueryHostAckError(ctx context.Context, host *cosmos.CosmosChain, channelId string, sequence uint64) (string, error) {
+	stdout, err := searchRecvPacket(ctx, host, channelId, sequence)
+	if err != nil {
+		return "", err
+	}
+	return parseHostAckError(stdout)
+}
+
+// Returned by `QueryICAAccountSeq` and `QueryAccountType` when the
+// host has no account at the address.
+var errAccountNotFound = errors.New("account not found")
+
+// The parts of the response to `query auth account` that hold the
+// account number and sequence. Interchain accounts wrap a base
+// account, while other accounts are one.
+type authAccountQueryResponse struct {
+	Type string `json:"@type"`
+	accountSequence
+	BaseAccount *accountSequence `json:"base_account"`
+}
+
+type accountSequence struct {
+	AccountNumber string `json:"account_number"`
+	Sequence      string `json:"sequence"`
+}
+
+// Parses the account number and sequence from the output of `query
+// auth account`.
+func parseAccountSeq(stdout []byte) (uint64, uint64, error) {
+	var response authAccountQueryResponse
+	if err := json.Unmarshal(stdout, &response); err != nil {
+		return 0, 0, fmt.Errorf("failed to unmarshal account: %w", err)
+	}
+	account := response.accountSequence
+	if response.BaseAccount != nil {
+		account = *response.BaseAccount
+	}
+	accountNumber, err := strconv.ParseUint(account.AccountNumber, 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid account number %q: %w", account.AccountNumber, err)
+	}
+	sequence, err := strconv.ParseUint(account.Sequence, 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid sequence %q: %w", account.Sequence, err)
+	}
+	return accountNumber, sequence, nil
+}
+
+// The account type the host's auth module reports for an interchain
+// account, rather than `/cosmos.auth.v1beta1.BaseAccount`.
+const interchainAccountType = "/ibc.applications.interchain_accounts.v1.InterchainAccount"
+
+// Parses the account's type, for example `interchainAccountType`,
+// from the output of `query auth account`.
+func parseAccountType(stdout []byte) (string, error) {
+	var response authAccountQueryResponse
+	if err := json.Unmarshal(stdout, &response); err != nil {
+		return "", fmt.Errorf("failed to unmarshal account: %w", err)
+	}
+	if response.Type == "" {
+		return "", fmt.Errorf("account has no type: %s", stdout)
+	}
+	return response.Type, nil
+}
+
+// Runs `query auth account` for address on host. Returns an error
+// wrapping `errAccountNotFound` if the host has no such account.
+func queryAuthAccount(ctx context.Context, host *cosmos.CosmosChain, address string) ([]byte, error) {
+	stdout, stderr, err := host.Exec(ctx, queryCommand(host, "auth", "account", address), nil)
+	if err != nil {
+		if strings.Contains(err.Error(), "not found") || strings.Contains(string(stderr), "not found") {
+			return nil, fmt.Errorf("%w: %s on %s", errAccountNotFound, address, host.Config().ChainID)
+		}
+		return nil, err
+	}
+	return stdout, nil
+}
+
+// Queries the host chain's auth module for the account number and
+// sequence of the interchain account at icaAddress. This checks the
+// account exists on the host, independently of what the contract
+// reports. Returns an error wrapping `errAccountNotFound` if the host
+// has no such account, for example because the address never
+// received funds or its channel never opened.
+func QueryICAAccountSeq(ctx context.Context, host *cosmos.CosmosChain, icaAddress string) (accountNumber, sequence uint64, err error) {
+	stdout, err := queryAuthAccount(ctx, host, icaAddress)
+	if err != nil {
+		return 0, 0, err
+	}
+	return parseAccountSeq(stdout)
+}
+
+// Queries the type of the account at address in the host chain's
+// auth module. Returns an error wrapping `errAccountNotFound` if the
+// host has no such account.
+func QueryAccountType(ctx context.Context, host *cosmos.CosmosChain, address string) (string, error) {
+	stdout, err := queryAuthAccount(ctx, host, address)
+	if err != nil {
+		return "", err
+	}
+	return parseAccountType(stdout)
+}
+
+// Polls until the host has an account at address, and returns its
+// type, or until timeout elapses. The host creates an interchain
+// account when the account's channel opens, which the relayer may
+// finish a few blocks after the contract reports the address.
+func WaitForAccountType(ctx context.Context, host *cosmos.CosmosChain, address string, timeout time.Duration) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var lastErr error
+	for {
+		accountType, err := QueryAccountType(ctx, host, address)
+		if err == nil {
+			return accountType, nil
+		}
+		lastErr = err
+
+		select {
+		case <-ctx.Done():
+			return "", fmt.Errorf("timed out after %s waiting for account %s on %s: %w", timeout, address, host.Config().ChainID, lastErr)
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+// Returns the human readable part of a bech32 address, for example
+// "cosmos" for a Gaia account.
+func bech32Prefix(address string) (string, error) {
+	prefix, _, err := bech32.DecodeAndConvert(address)
+	if err != nil {
+		return "", fmt.Errorf("invalid bech32 address %q: %w", address, err)
+	}
+	return prefix, nil
+}
+
+// Derives the address a host would give the interchain account on
+// portID, opened over the host's connectionID, using the legacy ICS-27
+// derivation from ibc-go before v3.4.0: an ADR-028 sub-address of the
+// interchain accounts module account, keyed by connectionID+portID.
+//
+// This is not the address the host gives the account. Hosts on ibc-go
+// v3.4.0 or later (including the Gaia version these tests run) also
+// mix the current block's app hash and data hash into the key, so
+// their addresses can not be derived off-chain. Only compare against
+// this to check a host isn't using the legacy scheme, as
+// `TestICAAddressDerivation` does.
+func deriveLegacyICAAddress(connectionID, portID string) (string, error) {
+	if connectionID == "" || portID == "" {
+		return "", fmt.Errorf("connection ID and port ID must be set, got %q and %q", connectionID, portID)
+	}
+	module := authtypes.NewModuleAddress("interchainaccounts")
+	return sdk.AccAddress(address.Derive(module, []byte(connectionID+portID))).String(), nil
+}
+
+// Asserts that icaAddress is an address on host. An ICA address with
+// another chain's prefix means the address came from the wrong
+// chain.
+func requireHostAddress(t *testing.T, host *cosmos.CosmosChain, icaAddress string) {
+	t.Helper()
+	prefix, err := bech32Prefix(icaAddress)
+	require.NoError(t, err)
+	require.Equal(t, host.Config().Bech32Prefix, prefix, "ICA address %s is not on %s", icaAddress, host.Config().ChainID)
+}
+
+// Tests that a registered interchain account exists in the host's
+// auth module, and that an address the host has never seen is
+// reported as not found rather than as a failed query.
+func TestICAAccountSeq(t *testing.T) {
+	env := setupICSTest(t)
+	ctx, atom := env.ctx, env.atom
+
+	contract := deployICAContract(t, env)
+	address := registerICA(t, env, contract, "test")
+
+	_, sequence, err := QueryICAAccountSeq(ctx, atom, address)
+	require.NoError(t, err, "the host should create the ICA when its channel opens")
+	// Interchain accounts never sign transactions on the host, so
+	// their sequence is never incremented.
+	require.Zero(t, sequence)
+
+	_, _, err = QueryICAAccountSeq(ctx, atom, "cosmos1qypqxpq9qcrsszg2pvxq6rs0zqg3yyc5lzv7xu")
+	require.ErrorIs(t, err, errAccountNotFound)
+}
+
+// Tests that the host registers an interchain account as one, rather
+// than as a plain account that happens to be at the same address.
+func TestICAAccountType(t *testing.T) {
+	env := setupICSTest(t)
+	ctx, atom := env.ctx, env.atom
+
+	contract := deployICAContract(t, env)
+	icaAddress := registerICA(t, env, contract, "test")
+
+	accountType, err := WaitForAccountType(ctx, atom, icaAddress, time.Minute)
+	require.NoError(t, err)
+	require.Equal(t, interchainAccountType, accountType)
+
+	// A user is a plain account.
+	userType, err := QueryAccountType(ctx, atom, env.atomUser.Bech32Address(atom.Config().Bech32Prefix))
+	require.NoError(t, err)
+	require.NotEqual(t, interchainAccountType, userType)
+}
+
+// Tests that the contract reports an address the host derived with
+// ICS-27's unique derivation: a 32-byte ADR-028 sub-address that is
+// not the old, predictable, derivation.
+func TestICAAddressDerivation(t *testing.T) {
+	env := setupICSTest(t)
+	ctx := env.ctx
+
+	contract := deployICAContract(t, env)
+	icaAddress := registerICA(t, env, contract, "test")
+
+	_, bz, err := bech32.DecodeAndConvert(icaAddress)
+	require.NoError(t, err)
+	require.Len(t, bz, 32, "ICA addresses should be ADR-028 derived addresses")
+
+	hostConnectionId, err := counterpartyConnectionID(ctx, env.relayer, env.eRep, env.neutron.Config().ChainID, env.connectionId)
+	require.NoError(t, err)
+
+	legacy, err := deriveLegacyICAAddress(hostConnectionId, ICAPortID(contract, "test"))
+	require.NoError(t, err)
+	require.NotEqual(t, legacy, icaAddress, "the host should not use the predictable ICA address derivation")
+}
+
+func TestDeriveLegacyICAAddress(t *testing.T) {
+	// Generated with ibc-go v3's `icatypes.GenerateAddress`.
+	derived, err := deriveLegacyICAAddress("connection-0", "icacontroller-neutron14hj2tavq8fpesdwxxcu44rty3hh90vhujrvcmstl4zr3txmfvw9s5c2epq.test")
+	require.NoError(t, err)
+	require.Equal(t, "cosmos1hfxm6slsnrhfmcap6q66zl0uwaq8fy3t6xqfmfhfmp6eaupaphnq8yggam", derived)
+
+	_, err = deriveLegacyICAAddress("", "icacontroller-neutron1.test")
+	require.Error(t, err)
+}
+
+func TestBech32Prefix(t *testing.T) {
+	prefix, err := bech32Prefix("cosmos1qypqxpq9qcrsszg2pvxq6rs0zqg3yyc5lzv7xu")
+	require.NoError(t, err)
+	require.Equal(t, "cosmos", prefix)
+
+	prefix, err = bech32Prefix("neutron1qypqxpq9qcrsszg2pvxq6rs0zqg3yyc5ma9uum")
+	require.NoError(t, err)
+	require.Equal(t, "neutron", prefix)
+
+	_, err = bech32Prefix("cosmos1invalid")
+	require.Error(t, err)
+	_, err = bech32Prefix("")
+	require.Error(t, err)
+}
+
+func TestParseAccountSeq(t *testing.T) {
+	for _, tc := range []struct {
+		fixture       string
+		accountNumber uint64
+		sequence      uint64
+	}{
+		// Interchain accounts never sign, so their sequence
+		// stays at zero.
+		{"testdata/auth_account_ica.json", 12, 0},
+		{"testdata/auth_account_base.json", 7, 5},
+	} {
+		stdout, err := os.ReadFile(tc.fixture)
+		require.NoError(t, err)
+		accountNumber, sequence, err := parseAccountSeq(stdout)
+		require.NoError(t, err, tc.fixture)
+		require.Equal(t, tc.accountNumber, accountNumber, tc.fixture)
+		require.Equal(t, tc.sequence, sequence, tc.fixture)
+	}
+
+	_, _, err := parseAccountSeq([]byte(`{"@type": "/cosmos.auth.v1beta1.BaseAccount"}`))
+	require.Error(t, err)
+}
+
+func TestParseTxSearchCount(t *testing.T) {
+	count, err := parseTxSearchCount([]byte(`{"total_count":"2","count":"1","page_number":"1","page_total":"2","limit":"1","txs":[]}`))
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), count)
+
+	count, err = parseTxSearchCount([]byte(`{"total_count":"0","count":"0","page_number":"1","page_total":"0","limit":"1","txs":[]}`))
+	require.NoError(t, err)
+	require.Zero(t, count)
+
+	_, err = parseTxSearchCount([]byte(`{}`))
+	require.Error(t, err)
+}
+
+func TestParseAccountType(t *testing.T) {
+	for _, tc := range []struct {
+		fixture     string
+		accountType string
+	}{
+		{"testdata/auth_account_ica.json", interchainAccountType},
+		{"testdata/auth_account_base.json", "/cosmos.auth.v1beta1.BaseAccount"},
+	} {
+		stdout, err := os.ReadFile(tc.fixture)
+		require.NoError(t, err)
+		accountType, err := parseAccountType(stdout)
+		require.NoError(t, err, tc.fixture)
+		require.Equal(t, tc.accountType, accountType, tc.fixture)
+	}
+
+	_, err := parseAccountType([]byte(`{"account_number": "7"}`))
+	require.Error(t, err)
+}
+
+func TestBondedByTokens(t *testing.T) {
+	var response validatorsQueryResponse
+	err := json.Unmarshal([]byte(`{"validators": [
+		{"operator_address": "cosmosvaloper1small", "jailed": false, "status": "BOND_STATUS_BONDED", "tokens": "1000000"},
+		{"operator_address": "cosmosvaloper1jailed", "jailed": true, "status": "BOND_STATUS_UNBONDING", "tokens": "9000000000"},
+		{"operator_address": "cosmosvaloper1large", "jailed": false, "status": "BOND_STATUS_BONDED", "tokens": "5000000000"},
+		{"operator_address": "cosmosvaloper1unbonded", "jailed": false, "status": "BOND_STATUS_UNBONDED", "tokens": "8000000000"},
+		{"operator_address": "cosmosvaloper1medium", "jailed": false, "status": "BOND_STATUS_BONDED", "tokens": "5000000"},
+		{"operator_address": "cosmosvaloper1throwaway", "consensus_pubkey": {"@type": "/cosmos.crypto.ed25519.PubKey", "key": "`+triggerVSCPubKey+`"}, "jailed": false, "status": "BOND_STATUS_BONDED", "tokens": "1000000"}
+	]}`), &response)
+	require.NoError(t, err)
+	validators, err := response.bondedByTokens()
+	require.NoError(t, err)
+	require.Equal(t, []string{"cosmosvaloper1large", "cosmosvaloper1medium", "cosmosvaloper1small"}, validators)
+
+	response.Validators[0].Tokens = "lots"
+	_, err = response.bondedByTokens()
+	require.ErrorContains(t, err, "invalid tokens for validator cosmosvaloper1small")
+}
+
+func TestParsePacketMemo(t *testing.T) {
+	tx := func(data string) string {
+		attribute, err := json.Marshal(data)
+		require.NoError(t, err)
+		return `{"total_count":"1","txs":[{"txhash":"AB12","code":0,"logs":[{"msg_index":0,"events":[
+			{"type":"recv_packet","attributes":[{"key":"packet_data","value":` + string(attribute) + `},{"key":"packet_sequence","value":"3"}]}
+		]}]}]}`
+	}
+
+	memo, err := parsePacketMemo([]byte(tx(`{"data":"CgA=","memo":"invoice-2023-0042","type":"TYPE_EXECUTE_TX"}`)))
+	require.NoError(t, err)
+	require.Equal(t, "invoice-2023-0042", memo)
+
+	memo, err = parsePacketMemo([]byte(tx(`{"data":"CgA=","type":"TYPE_EXECUTE_TX"}`)))
+	require.NoError(t, err)
+	require.Empty(t, memo)
+
+	_, err = parsePacketMemo([]byte(`{"total_count":"0","txs":[]}`))
+	require.ErrorContains(t, err, "no transaction")
+}
+
+func TestParseHostAckError(t *testing.T) {
+	failed := `{"total_count":"1","txs":[{"txhash":"AB12","code":0,"logs":[{"msg_index":1,"events":[
+		{"type":"ics27_packet","attributes":[{"key":"module","value":"interchainaccounts"},{"key":"error","value":"no concrete type registered for type URL /cosmos.bank.v1beta1.MsgSendd against interface *types.Msg"},{"key":"host_channel_id","value":"channel-2"},{"key":"success","value":"false"}]},
+		{"type":"recv_packet","attributes":[{"key":"packet_sequence","value":"1"}]}
+	]}]}]}`
+	ackError, err := parseHostAckError([]byte(failed))
+	require.NoError(t, err)
+	require.Contains(t, ackError, "no concrete type registered")
+
+	// The host only sets the error attribute on failure.
+	succeeded := `{"total_count":"1","txs":[{"txhash":"AB12","code":0,"logs":[{"msg_index":1,"events":[
+		{"type":"ics27_packet","attributes":[{"key":"module","value":"interchainaccounts"},{"key":"host_channel_id","value":"channel-2"},{"key":"success","value":"true"}]},
+		{"type":"recv_packet","attributes":[{"key":"packet_sequence","value":"1"}]}
+	]}]}]}`
+	ackError, err = parseHostAckError([]byte(succeeded))
+	require.NoError(t, err)
+	require.Empty(t, ackError)
+
+	_, err = parseHostAckError([]byte(`{"total_count":"1","txs":[{"txhash":"AB12","code":0,"logs":[]}]}`))
+	require.ErrorContains(t, err, "no interchain account packet event")
+	_, err = parseHostAckError([]byte(`{"total_count":"0","txs":[]}`))
+	require.ErrorContains(t, err, "no transaction")
+}
@@ -4,10 +4,15 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
-	"github.com/cosmos/cosmos-sdk/crypto/keyring"
+	banktypes "github.com/cosmos/cosmos-sdk/x/bank/types"
+	dockerclient "github.com/docker/docker/client"
 	"github.com/icza/dyno"
 	ibctest "github.com/strangelove-ventures/interchaintest/v3"
 	"github.com/strangelove-ventures/interchaintest/v3/chain/cosmos"
@@ -15,7 +20,6 @@ import (
 	"github.com/strangelove-ventures/interchaintest/v3/relayer"
 	"github.com/strangelove-ventures/interchaintest/v3/relayer/rly"
 	"github.com/strangelove-ventures/interchaintest/v3/testreporter"
-	"github.com/strangelove-ventures/interchaintest/v3/testutil"
 	"github.com/stretchr/testify/require"
 	"go.uber.org/zap/zaptest"
 )
@@ -26,18 +30,48 @@ import (
 // percentage of validators may opt out of running a Neutron
 // node [^1].
 //
+// consumer_redistribution_fraction - the fraction of the fees
+// collected on Neutron that it keeps, for example "0.75". The rest
+// are sent to the provider. If empty, the genesis default is left in
+// place.
+//
+// blocks_per_distribution_transmission - how many blocks Neutron
+// waits between sending the provider its share of fees. If empty, the
+// genesis default is left in place.
+//
 // reward_denoms - the reward denominations allowed to be sent to the
 // provider (atom) from the consumer (neutron) [^2].
 //
 // provider_reward_denoms - the reward denominations allowed to be
 // sent to the consumer by the provider [^2].
 //
+// Nil denomination slices are written as empty arrays, as the
+// consumer module rejects a null list.
+//
+// denom_metadata - metadata for denominations on Neutron, for example
+// a reward denomination, appended to any metadata already in the
+// bank module's genesis [^3].
+//
+// overrides - any other fields to set, see `applyGenesisOverrides`.
+// These are applied last, so they win over the fields above.
+//
 // [^1]: https://docs.neutron.org/neutron/consumer-chain-launch#relevant-parameters
 // [^2]: https://github.com/cosmos/interchain-security/blob/54e9852d3c89a2513cd0170a56c6eec894fc878d/proto/interchain_security/ccv/consumer/v1/consumer.proto#L61-L66
+// [^3]: https://github.com/cosmos/cosmos-sdk/blob/v0.45.11/proto/cosmos/bank/v1beta1/bank.proto#L74-L96
 func setupNeutronGenesis(
 	soft_opt_out_threshold string,
+	consumer_redistribution_fraction string,
+	blocks_per_distribution_transmission string,
 	reward_denoms []string,
-	provider_reward_denoms []string) func(ibc.ChainConfig, []byte) ([]byte, error) {
+	provider_reward_denoms []string,
+	denom_metadata []banktypes.Metadata,
+	overrides map[string]interface{}) func(ibc.ChainConfig, []byte) ([]byte, error) {
+	if reward_denoms == nil {
+		reward_denoms = []string{}
+	}
+	if provider_reward_denoms == nil {
+		provider_reward_denoms = []string{}
+	}
 	return func(chainConfig ibc.ChainConfig, genbz []byte) ([]byte, error) {
 		g := make(map[string]interface{})
 		if err := json.Unmarshal(genbz, &g); err != nil {
@@ -48,6 +82,18 @@ func setupNeutronGenesis(
 			return nil, fmt.Errorf("failed to set soft_opt_out_threshold in genesis json: %w", err)
 		}
 
+		if consumer_redistribution_fraction != "" {
+			if err := dyno.Set(g, consumer_redistribution_fraction, "app_state", "ccvconsumer", "params", "consumer_redistribution_fraction"); err != nil {
+				return nil, fmt.Errorf("failed to set consumer_redistribution_fraction in genesis json: %w", err)
+			}
+		}
+
+		if blocks_per_distribution_transmission != "" {
+			if err := dyno.Set(g, blocks_per_distribution_transmission, "app_state", "ccvconsumer", "params", "blocks_per_distribution_transmission"); err != nil {
+				return nil, fmt.Errorf("failed to set blocks_per_distribution_transmission in genesis json: %w", err)
+			}
+		}
+
 		if err := dyno.Set(g, reward_denoms, "app_state", "ccvconsumer", "params", "reward_denoms"); err != nil {
 			return nil, fmt.Errorf("failed to set reward_denoms in genesis json: %w", err)
 		}
@@ -56,6 +102,63 @@ func setupNeutronGenesis(
 			return nil, fmt.Errorf("failed to set provider_reward_denoms in genesis json: %w", err)
 		}
 
+		for _, metadata := range denom_metadata {
+			if err := dyno.Append(g, metadata, "app_state", "bank", "denom_metadata"); err != nil {
+				return nil, fmt.Errorf("failed to add %s denom_metadata to genesis json: %w", metadata.Base, err)
+			}
+		}
+
+		if err := applyGenesisOverrides(g, overrides); err != nil {
+			return nil, err
+		}
+
+		out, err := json.Marshal(g)
+
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal genesis bytes to json: %w", err)
+		}
+		return out, nil
+	}
+}
+
+// Sets custom fields for the Gaia genesis file.
+//
+// unbonding_period - how long it takes for tokens to unbond. Light
+// clients tracking Gaia must have a trusting period shorter than
+// this. If empty, Gaia's default is left in place.
+//
+// voting_period - how long governance proposals are in their voting
+// period, and the longest they can be in their deposit period. See
+// `setGovPeriods`. If empty, Gaia's defaults are left in place.
+//
+// overrides - any other fields to set, see `applyGenesisOverrides`.
+func setupGaiaGenesis(unbonding_period, voting_period string, overrides map[string]interface{}) func(ibc.ChainConfig, []byte) ([]byte, error) {
+	return func(chainConfig ibc.ChainConfig, genbz []byte) ([]byte, error) {
+		if unbonding_period == "" && voting_period == "" && len(overrides) == 0 {
+			return genbz, nil
+		}
+
+		g := make(map[string]interface{})
+		if err := json.Unmarshal(genbz, &g); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal genesis file: %w", err)
+		}
+
+		if unbonding_period != "" {
+			if err := dyno.Set(g, unbonding_period, "app_state", "staking", "params", "unbonding_time"); err != nil {
+				return nil, fmt.Errorf("failed to set unbonding_time in genesis json: %w", err)
+			}
+		}
+
+		if voting_period != "" {
+			if err := setGovPeriods(g, voting_period); err != nil {
+				return nil, err
+			}
+		}
+
+		if err := applyGenesisOverrides(g, overrides); err != nil {
+			return nil, err
+		}
+
 		out, err := json.Marshal(g)
 
 		if err != nil {
@@ -65,12 +168,86 @@ func setupNeutronGenesis(
 	}
 }
 
+// Composes modifiers into one genesis modification that applies them
+// in order, each to the output of the one before. It stops at the
+// first that fails. Nil modifiers are skipped.
+func chainModifiers(modifiers ...func(ibc.ChainConfig, []byte) ([]byte, error)) func(ibc.ChainConfig, []byte) ([]byte, error) {
+	return func(chainConfig ibc.ChainConfig, genbz []byte) ([]byte, error) {
+		for i, modify := range modifiers {
+			if modify == nil {
+				continue
+			}
+			out, err := modify(chainConfig, genbz)
+			if err != nil {
+				return nil, fmt.Errorf("genesis modifier %d of %s: %w", i, chainConfig.ChainID, err)
+			}
+			genbz = out
+		}
+		return genbz, nil
+	}
+}
+
+// Sets the gov module's voting period and maximum deposit period in
+// genesis g to period. Before gov v1 (Cosmos SDK v0.47) these are in
+// separate `voting_params` and `deposit_params`. From v1 they are
+// both in `params`, and the old fields are left null.
+func setGovPeriods(g map[string]interface{}, period string) error {
+	if params, err := dyno.GetMapS(g, "app_state", "gov", "params"); err == nil && params != nil {
+		if err := dyno.Set(g, period, "app_state", "gov", "params", "voting_period"); err != nil {
+			return fmt.Errorf("failed to set voting_period in genesis json: %w", err)
+		}
+		if err := dyno.Set(g, period, "app_state", "gov", "params", "max_deposit_period"); err != nil {
+			return fmt.Errorf("failed to set max_deposit_period in genesis json: %w", err)
+		}
+		return nil
+	}
+
+	if err := dyno.Set(g, period, "app_state", "gov", "voting_params", "voting_period"); err != nil {
+		return fmt.Errorf("failed to set voting_period in genesis json: %w", err)
+	}
+	if err := dyno.Set(g, period, "app_state", "gov", "deposit_params", "max_deposit_period"); err != nil {
+		return fmt.Errorf("failed to set max_deposit_period in genesis json: %w", err)
+	}
+	return nil
+}
+
+// Sets each field of genesis g named by a key of overrides to its
+// value. Keys are dotted paths, for example
+// "app_state.gov.voting_params.voting_period". Every field but the
+// last on a path must already exist. Overrides are applied in key
+// order, so a parent is set before any of its children.
+func applyGenesisOverrides(g map[string]interface{}, overrides map[string]interface{}) error {
+	keys := make([]string, 0, len(overrides))
+	for key := range overrides {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		fields := strings.Split(key, ".")
+		path := make([]interface{}, len(fields))
+		for i, field := range fields {
+			path[i] = field
+		}
+		if err := dyno.Set(g, overrides[key], path...); err != nil {
+			return fmt.Errorf("failed to set %s in genesis json: %w", key, err)
+		}
+	}
+	return nil
+}
+
 // A query against the Neutron example contract. Note the usage of
 // `omitempty` on fields. This means that if that field has no value,
 // it will not have a key in the serialized representaiton of the
-// struct, thus mimicing the serialization of Rust enums.
+// struct, thus mimicing the serialization of Rust enums. The fields
+// are pointers as `omitempty` has no effect on struct values.
 type IcaExampleContractQuery struct {
-	InterchainAccountAddress InterchainAccountAddressQuery `json:"interchain_account_address,omitempty"`
+	InterchainAccountAddress             *InterchainAccountAddressQuery             `json:"interchain_account_address,omitempty"`
+	InterchainAccountAddressFromContract *InterchainAccountAddressFromContractQuery `json:"interchain_account_address_from_contract,omitempty"`
+	AcknowledgementResult                *AcknowledgementResultQuery                `json:"acknowledgement_result,omitempty"`
+	InterchainAccountChannel             *InterchainAccountChannelQuery             `json:"interchain_account_channel,omitempty"`
+	LastAcknowledgedSequence             *LastAcknowledgedSequenceQuery             `json:"last_acknowledged_sequence,omitempty"`
+	AcknowledgementResults               *AcknowledgementResultsQuery               `json:"acknowledgement_results,omitempty"`
 }
 
 type InterchainAccountAddressQuery struct {
@@ -78,6 +255,44 @@ type InterchainAccountAddressQuery struct {
 	ConnectionId        string `json:"connection_id"`
 }
 
+// Queries the account address the contract saved in its own storage
+// when the ICA channel opened, as opposed to asking Neutron's
+// interchain transactions module for it.
+type InterchainAccountAddressFromContractQuery struct {
+	InterchainAccountId string `json:"interchain_account_id"`
+}
+
+// Queries the result of the packet with SequenceId sent by the
+// interchain account with ID InterchainAccountId.
+type AcknowledgementResultQuery struct {
+	InterchainAccountId string `json:"interchain_account_id"`
+	SequenceId          uint64 `json:"sequence_id"`
+}
+
+// Queries the port and channel the contract recorded for the
+// interchain account with ID InterchainAccountId.
+type InterchainAccountChannelQuery struct {
+	InterchainAccountId string `json:"interchain_account_id"`
+}
+
+// Queries the highest sequence of the packets sent by the interchain
+// account with ID InterchainAccountId that the contract has received
+// a response (success, error, or timeout) to.
+type LastAcknowledgedSequenceQuery struct {
+	InterchainAccountId string `json:"interchain_account_id"`
+}
+
+// Queries a page of the results the contract has received for the
+// packets sent by the interchain account with ID InterchainAccountId,
+// in order of sequence. StartAfter is the last sequence of the
+// previous page, or nil for the first page. The contract returns 10
+// results if Limit is nil, and never more than 30.
+type AcknowledgementResultsQuery struct {
+	InterchainAccountId string  `json:"interchain_account_id"`
+	StartAfter          *uint64 `json:"start_after,omitempty"`
+	Limit               *uint32 `json:"limit,omitempty"`
+}
+
 // A query response from the Neutron contract. Note that when
 // interchaintest returns query responses, it does so in the form
 // `{"data": <RESPONSE>}`, so we need this outer data key, which is
@@ -90,42 +305,416 @@ type InterchainAccountAddressQueryResponse struct {
 	InterchainAccountAddress string `json:"interchain_account_address"`
 }
 
-// This tests Cosmos Interchain Security, spinning up a provider and a single consumer chain.
-func TestICS(t *testing.T) {
+// The contract stores accounts as an `(address, connection_id)`
+// tuple, which serializes as a two element array.
+type InterchainAccountAddressFromContractQueryResponse struct {
+	Data []string `json:"data"`
+}
+
+// Data is nil until the contract has received a response to the
+// packet.
+type AcknowledgementResultQueryResponse struct {
+	Data *AcknowledgementResult `json:"data"`
+}
+
+// Data is nil until the contract has received a response to any of
+// the account's packets.
+type LastAcknowledgedSequenceQueryResponse struct {
+	Data *uint64 `json:"data"`
+}
+
+type AcknowledgementResultsQueryResponse struct {
+	Data []SequencedAcknowledgementResult `json:"data"`
+}
+
+// The result of the packet with SequenceId.
+type SequencedAcknowledgementResult struct {
+	SequenceId uint64                `json:"sequence_id"`
+	Result     AcknowledgementResult `json:"result"`
+}
+
+type InterchainAccountChannelQueryResponse struct {
+	Data InterchainAccountChannel `json:"data"`
+}
+
+// An interchain account's channel, as recorded by the contract.
+// Until the channel handshake completes, ChannelId is empty and State
+// is `PENDING`. Afterwards State is `OPEN`, and `CLOSED` once a packet
+// on the channel times out.
+type InterchainAccountChannel struct {
+	PortId    string `json:"port_id"`
+	ChannelId string `json:"channel_id"`
+	State     string `json:"state"`
+}
+
+// Mirrors the contract's `AcknowledgementResult` enum. Exactly one of
+// the fields is set.
+type AcknowledgementResult struct {
+	// The type URLs of the messages that executed successfully.
+	Success []string `json:"success,omitempty"`
+	// A `(payload message, error details)` pair.
+	Error []string `json:"error,omitempty"`
+	// The payload message of a packet that timed out.
+	Timeout *string `json:"timeout,omitempty"`
+}
+
+// The state shared by tests that run against a live Atom <-> Neutron
+// replicated security setup. This is built by `setupICSTest`.
+type icsTestEnv struct {
+	ctx context.Context
+
+	atom    *cosmos.CosmosChain
+	neutron *cosmos.CosmosChain
+
+	relayer ibc.Relayer
+	// The relayer of the IBC transfer path. This is `relayer`
+	// unless the test was configured with `transferRelayer`.
+	transferRelayer ibc.Relayer
+	eRep            *testreporter.RelayerExecReporter
+
+	// Funded users on each chain.
+	atomUser    *ibc.Wallet
+	neutronUser *ibc.Wallet
+
+	// A connection between Atom and Neutron that interchain
+	// accounts can be created on.
+	connectionId string
+	// Every connection on Neutron. There is one for the ICS path
+	// and one for the IBC transfer path, both to Atom.
+	connectionIds []string
+
+	// The Docker client and network the interchain runs on, for
+	// tests that build more relayers.
+	dockerClient *dockerclient.Client
+	networkID    string
+	// What setup brought up. Tests that start relayers of their
+	// own record them here to have them torn down.
+	teardown *interchainTeardown
+}
+
+// The relayer paths between Atom and Neutron. The ICS path carries
+// the CCV channel, and the IBC path a transfer channel.
+const (
+	icsPath = "ics-path"
+	ibcPath = "ibc-path"
+)
+
+// The default Neutron trusting period. This is production scale, so
+// clients never expire over the course of a test.
+const defaultNeutronTrustingPeriod = "1197504s"
+
+// The default Neutron gas price, in Neutron's denom. Transactions
+// are free, unlike on the real Neutron.
+const defaultNeutronGasPrice = "0.0"
+
+// The default soft opt-out threshold of Neutron. See
+// `setupNeutronGenesis`.
+const defaultSoftOptOutThreshold = "0.05"
+
+// Set to a locally built Neutron image, such as "neutron:local", to
+// run that instead of `defaultNeutronImage`. interchaintest still
+// tries to pull it, but only logs the failure and runs the local
+// copy. Its nodes run as NEUTRON_LOCAL_IMAGE_UIDGID, which defaults
+// to the user and group heighliner images run as.
+const (
+	neutronLocalImageEnv  = "NEUTRON_LOCAL_IMAGE"
+	neutronLocalUidGidEnv = "NEUTRON_LOCAL_IMAGE_UIDGID"
+)
+
+// The Neutron release the tests run by default.
+var defaultNeutronImage = ibc.DockerImage{
+	Repository: "ghcr.io/strangelove-ventures/heighliner/neutron",
+	Version:    "v1.0.2",
+	UidGid:     "1025:1025",
+}
+
+// The Neutron image tests run. Set up by `TestMain`.
+var neutronImage = defaultNeutronImage
+
+// Reads the Neutron image to run from getenv, keeping
+// `defaultNeutronImage` if NEUTRON_LOCAL_IMAGE is unset. An image
+// without a tag is tagged "latest", as Docker does.
+func parseNeutronImage(getenv func(string) string) (ibc.DockerImage, error) {
+	local := getenv(neutronLocalImageEnv)
+	if local == "" {
+		if uidGid := getenv(neutronLocalUidGidEnv); uidGid != "" {
+			return ibc.DockerImage{}, fmt.Errorf("%s is set without %s", neutronLocalUidGidEnv, neutronLocalImageEnv)
+		}
+		return defaultNeutronImage, nil
+	}
+	repository, version := local, "latest"
+	// A colon before the last slash is a registry's port, not a
+	// tag.
+	if i := strings.LastIndex(local, ":"); i > strings.LastIndex(local, "/") {
+		repository, version = local[:i], local[i+1:]
+	}
+	if repository == "" || version == "" {
+		return ibc.DockerImage{}, fmt.Errorf("%s must be an image such as neutron:local, got %q", neutronLocalImageEnv, local)
+	}
+	uidGid := defaultNeutronImage.UidGid
+	if value := getenv(neutronLocalUidGidEnv); value != "" {
+		uidGid = value
+	}
+	return ibc.DockerImage{Repository: repository, Version: version, UidGid: uidGid}, nil
+}
+
+// Reads the Neutron image to run from the environment and sets up
+// `neutronImage` to match.
+func configureNeutronImage() error {
+	parsed, err := parseNeutronImage(os.Getenv)
+	if err != nil {
+		return err
+	}
+	neutronImage = parsed
+	return nil
+}
+
+// Set to run a relayer other than `defaultRelayerImage`: a
+// repository such as ghcr.io/cosmos/relayer, and a tag such as
+// v2.4.0. Either may be set without the other.
+const (
+	relayerImageEnv   = "RELAYER_IMAGE"
+	relayerVersionEnv = "RELAYER_VERSION"
+)
+
+// The relayer release the tests run by default.
+var defaultRelayerImage = ibc.DockerImage{
+	Repository: "ghcr.io/cosmos/relayer",
+	Version:    "v2.3.1",
+	UidGid:     rly.RlyDefaultUidGid,
+}
+
+// The relayer image tests run. Set up by `TestMain`.
+var relayerImage = defaultRelayerImage
+
+// Reads the relayer image to run from getenv, keeping what
+// `defaultRelayerImage` has for whichever of RELAYER_IMAGE and
+// RELAYER_VERSION is unset.
+func parseRelayerImage(getenv func(string) string) (ibc.DockerImage, error) {
+	image := defaultRelayerImage
+	if repository := getenv(relayerImageEnv); repository != "" {
+		if i := strings.LastIndex(repository, ":"); i > strings.LastIndex(repository, "/") {
+			return ibc.DockerImage{}, fmt.Errorf("%s must be an image without a tag, set %s for that, got %q", relayerImageEnv, relayerVersionEnv, repository)
+		}
+		image.Repository = repository
+	}
+	if version := getenv(relayerVersionEnv); version != "" {
+		image.Version = version
+	}
+	return image, nil
+}
+
+// Reads the relayer image to run from the environment and sets up
+// `relayerImage` to match.
+func configureRelayerImage() error {
+	parsed, err := parseRelayerImage(os.Getenv)
+	if err != nil {
+		return err
+	}
+	relayerImage = parsed
+	return nil
+}
+
+// The options tests build relayers running image with, usually
+// `relayerImage`. Relayers log to the console so that
+// `AssertNoRelayerErrors` can read it.
+func relayerOptions(image ibc.DockerImage) relayer.RelayerOptions {
+	return relayer.RelayerOptions{
+		relayer.CustomDockerImage(image.Repository, image.Version, image.UidGid),
+		relayer.RelayerOptionExtraStartFlags{Flags: []string{"-d", "--log-format", "console"}},
+	}
+}
+
+// The default chain ID and staking denom of Neutron.
+const (
+	defaultNeutronChainID = "neutron-2"
+	defaultNeutronDenom   = "untrn"
+)
+
+// Knobs for `setupICSTestWithConfig`. The zero value of each field
+// keeps the default behavior.
+type icsTestConfig struct {
+	// The trusting period of Neutron. When set, this is also the
+	// trusting period of the light clients created for the IBC
+	// transfer path, so that tests can make those clients expire
+	// quickly. Defaults to `defaultNeutronTrustingPeriod`, with
+	// the relayer choosing the clients' trusting periods.
+	neutronTrustingPeriod string
+	// The minimum gas prices of Neutron's nodes, for example
+	// "0.025untrn". Tests' transactions pay these prices, as does
+	// the relayer. Defaults to `defaultNeutronGasPrice` in
+	// Neutron's denom.
+	neutronGasPrices string
+	// The soft opt-out threshold of Neutron, for example "0.1".
+	// Defaults to `defaultSoftOptOutThreshold`.
+	neutronSoftOptOutThreshold string
+	// The fraction of its fees that Neutron keeps, for example
+	// "0.75", sending the rest to the provider. Defaults to the
+	// genesis default.
+	neutronRedistributionFraction string
+	// How many blocks Neutron waits between sending fees to the
+	// provider. Defaults to the genesis default of 1000, so tests
+	// of the reward pipeline should set this low.
+	neutronBlocksPerDistributionTransmission int64
+	// The chain ID of Neutron. Defaults to `defaultNeutronChainID`.
+	neutronChainID string
+	// The staking denom of Neutron, which is also its fee and
+	// reward denom. Defaults to `defaultNeutronDenom`.
+	neutronDenom string
+	// The denoms Neutron distributes as rewards, and those of them it
+	// sends on to the provider. Default to Neutron's denom and
+	// "uatom". Set an empty, rather than nil, slice for none.
+	neutronRewardDenoms         []string
+	neutronProviderRewardDenoms []string
+	// Metadata of denoms on Neutron, such as a reward denom other
+	// than its staking denom, added to its bank genesis. Defaults
+	// to none.
+	neutronDenomMetadata []banktypes.Metadata
+	// The unbonding period of Gaia, for example "600s". Defaults
+	// to Gaia's genesis default.
+	gaiaUnbondingPeriod string
+	// The governance voting period of Gaia, for example "15s", so
+	// that proposals finish quickly. Also caps Gaia's deposit
+	// period. Defaults to Gaia's genesis default.
+	gaiaVotingPeriod string
+	// Extra fields to set in Gaia's and Neutron's genesis files,
+	// keyed by dotted path. See `applyGenesisOverrides`.
+	gaiaGenesisOverrides    map[string]interface{}
+	neutronGenesisOverrides map[string]interface{}
+	// Further modifications of Gaia's and Neutron's genesis files,
+	// applied in order after the ones the fields above describe.
+	// See `chainModifiers`.
+	gaiaGenesisModifiers    []func(ibc.ChainConfig, []byte) ([]byte, error)
+	neutronGenesisModifiers []func(ibc.ChainConfig, []byte) ([]byte, error)
+	// How far apart both chains make blocks. Defaults to
+	// `timing.blockTime`.
+	blockTime time.Duration
+	// Skip triggering the first validator set change (VSC) packet.
+	// Transfers stay disabled on Neutron, so no users are funded
+	// and the environment's users are nil.
+	skipVSC bool
+	// Build the interchain without creating any IBC paths, and
+	// then link only the ICS path. The environment's connections
+	// are then just the ICS connection, and the relayer only
+	// relays `icsPath`.
+	skipPathCreation bool
+	// Relay the IBC transfer path with a second relayer, leaving
+	// the first with the ICS path. Interchain account channels are
+	// relayed by whichever relayer relays their connection. Has no
+	// effect with `skipPathCreation`, as there is then no transfer
+	// path.
+	transferRelayer bool
+	// Fail the test if the relayer reports errors while it runs.
+	// See `AssertNoRelayerErrors`.
+	checkRelayerErrors bool
+}
+
+// Spins up a provider (atom) and a single consumer chain (neutron),
+// links them with replicated security and an IBC transfer path, and
+// funds a user on each chain. The relayer is stopped when the test
+// ends.
+func setupICSTest(t *testing.T) *icsTestEnv {
+	t.Helper()
+	return setupICSTestWithConfig(t, icsTestConfig{})
+}
+
+// Builds the specs of the chains `setupICSTestWithConfig` runs: a
+// Gaia provider, and a Neutron consumer running image, with the
+// defaults overridden by config.
+func icsChainSpecs(config icsTestConfig, image ibc.DockerImage) []*ibctest.ChainSpec {
+	neutronTrustingPeriod := defaultNeutronTrustingPeriod
+	if config.neutronTrustingPeriod != "" {
+		neutronTrustingPeriod = config.neutronTrustingPeriod
+	}
+
+	neutronChainID := defaultNeutronChainID
+	if config.neutronChainID != "" {
+		neutronChainID = config.neutronChainID
+	}
+	neutronDenom := defaultNeutronDenom
+	if config.neutronDenom != "" {
+		neutronDenom = config.neutronDenom
+	}
+	softOptOutThreshold := defaultSoftOptOutThreshold
+	if config.neutronSoftOptOutThreshold != "" {
+		softOptOutThreshold = config.neutronSoftOptOutThreshold
+	}
+	var blocksPerDistributionTransmission string
+	if config.neutronBlocksPerDistributionTransmission != 0 {
+		blocksPerDistributionTransmission = strconv.FormatInt(config.neutronBlocksPerDistributionTransmission, 10)
+	}
+	blockTime := timing.blockTime
+	if config.blockTime != 0 {
+		blockTime = config.blockTime
+	}
+	neutronGasPrices := defaultNeutronGasPrice + neutronDenom
+	if config.neutronGasPrices != "" {
+		neutronGasPrices = config.neutronGasPrices
+	}
+	rewardDenoms := []string{neutronDenom}
+	if config.neutronRewardDenoms != nil {
+		rewardDenoms = config.neutronRewardDenoms
+	}
+	providerRewardDenoms := []string{"uatom"}
+	if config.neutronProviderRewardDenoms != nil {
+		providerRewardDenoms = config.neutronProviderRewardDenoms
+	}
+
+	return []*ibctest.ChainSpec{
+		{
+			Name:    "gaia",
+			Version: "v9.1.0",
+			ChainConfig: ibc.ChainConfig{
+				GasAdjustment:       1.5,
+				ConfigFileOverrides: blockTimeConfig(blockTime),
+				ModifyGenesis: chainModifiers(append(
+					[]func(ibc.ChainConfig, []byte) ([]byte, error){setupGaiaGenesis(config.gaiaUnbondingPeriod, config.gaiaVotingPeriod, config.gaiaGenesisOverrides)},
+					config.gaiaGenesisModifiers...)...),
+			},
+		},
+		{
+			ChainConfig: ibc.ChainConfig{
+				Type:                "cosmos",
+				Name:                "neutron",
+				ChainID:             neutronChainID,
+				Images:              []ibc.DockerImage{image},
+				Bin:                 "neutrond",
+				Bech32Prefix:        "neutron",
+				Denom:               neutronDenom,
+				GasPrices:           neutronGasPrices,
+				GasAdjustment:       10.3,
+				TrustingPeriod:      neutronTrustingPeriod,
+				NoHostMount:         false,
+				ConfigFileOverrides: blockTimeConfig(blockTime),
+				ModifyGenesis: chainModifiers(append(
+					[]func(ibc.ChainConfig, []byte) ([]byte, error){setupNeutronGenesis(softOptOutThreshold, config.neutronRedistributionFraction, blocksPerDistributionTransmission, rewardDenoms, providerRewardDenoms, config.neutronDenomMetadata, config.neutronGenesisOverrides)},
+					config.neutronGenesisModifiers...)...),
+			},
+		},
+	}
+}
+
+// Like `setupICSTest`, but with the defaults overridden by config.
+func setupICSTestWithConfig(t *testing.T, config icsTestConfig) *icsTestEnv {
+	t.Helper()
+
 	if testing.Short() {
 		t.Skip("skipping in short mode")
 	}
+	requireDocker(t)
 
 	t.Parallel()
+	acquireInterchainSlot(t)
 
 	ctx := context.Background()
 
+	var ibcClientOpts ibc.CreateClientOptions
+	if config.neutronTrustingPeriod != "" {
+		ibcClientOpts.TrustingPeriod = config.neutronTrustingPeriod
+	}
+
 	// Chain Factory
-	cf := ibctest.NewBuiltinChainFactory(zaptest.NewLogger(t), []*ibctest.ChainSpec{
-		{Name: "gaia", Version: "v9.1.0", ChainConfig: ibc.ChainConfig{GasAdjustment: 1.5}},
-		{
-			ChainConfig: ibc.ChainConfig{
-				Type:    "cosmos",
-				Name:    "neutron",
-				ChainID: "neutron-2",
-				Images: []ibc.DockerImage{
-					{
-						Repository: "ghcr.io/strangelove-ventures/heighliner/neutron",
-						Version:    "v1.0.2",
-						UidGid:     "1025:1025",
-					},
-				},
-				Bin:            "neutrond",
-				Bech32Prefix:   "neutron",
-				Denom:          "untrn",
-				GasPrices:      "0.0untrn",
-				GasAdjustment:  10.3,
-				TrustingPeriod: "1197504s",
-				NoHostMount:    false,
-				ModifyGenesis:  setupNeutronGenesis("0.05", []string{"untrn"}, []string{"uatom"}),
-			},
-		},
-	})
+	cf := ibctest.NewBuiltinChainFactory(zaptest.NewLogger(t), icsChainSpecs(config, neutronImage))
 
 	chains, err := cf.Chains(t.Name())
 	require.NoError(t, err)
@@ -133,20 +722,20 @@ func TestICS(t *testing.T) {
 	// interchaintest has one interface for a chain with IBC
 	// support, and another for a Cosmos blockchain.
 	atom, neutron := chains[0], chains[1]
-	_, cosmosNeutron := atom.(*cosmos.CosmosChain), neutron.(*cosmos.CosmosChain)
+	cosmosAtom, cosmosNeutron := atom.(*cosmos.CosmosChain), neutron.(*cosmos.CosmosChain)
+	neutronChainID := cosmosNeutron.Config().ChainID
 
 	// Relayer Factory
 	client, network := ibctest.DockerSetup(t)
-	r := ibctest.NewBuiltinRelayerFactory(
-		ibc.CosmosRly,
-		zaptest.NewLogger(t),
-		relayer.CustomDockerImage("ghcr.io/cosmos/relayer", "v2.3.1", rly.RlyDefaultUidGid),
-		relayer.RelayerOptionExtraStartFlags{Flags: []string{"-d", "--log-format", "console"}},
-	).Build(t, client, network)
+	rf := ibctest.NewBuiltinRelayerFactory(ibc.CosmosRly, zaptest.NewLogger(t), relayerOptions(relayerImage)...)
+	r := rf.Build(t, client, network)
+	transferRelayer := r
+	separateTransferRelayer := config.transferRelayer && !config.skipPathCreation
+	if separateTransferRelayer {
+		transferRelayer = rf.Build(t, client, network)
+	}
 
 	// Prep Interchain
-	const icsPath = "ics-path"
-	const ibcPath = "ibc-path"
 	ic := ibctest.NewInterchain().
 		AddChain(atom).
 		AddChain(neutron).
@@ -158,96 +747,136 @@ func TestICS(t *testing.T) {
 			Path:     icsPath,
 		}).
 		AddLink(ibctest.InterchainLink{
-			Chain1:  atom,
-			Chain2:  neutron,
-			Relayer: r,
-			Path:    ibcPath,
+			Chain1:           atom,
+			Chain2:           neutron,
+			Relayer:          transferRelayer,
+			Path:             ibcPath,
+			CreateClientOpts: ibcClientOpts,
 		})
+	if separateTransferRelayer {
+		ic = ic.AddRelayer(transferRelayer, "transfer-relayer")
+	}
 
-	// Log location
-	f, err := ibctest.CreateLogFile(fmt.Sprintf("%d.json", time.Now().Unix()))
+	// Log location. Tests run in parallel, so the file is named
+	// after the test as well as the time.
+	f, err := ibctest.CreateLogFile(fmt.Sprintf("%s-%d.json", t.Name(), time.Now().Unix()))
 	require.NoError(t, err)
+	logPath := f.Name()
 	// Reporter/logs
 	rep := testreporter.NewReporter(f)
 	eRep := rep.RelayerExecReporter(t)
+	// This cleanup is registered before the relayer's, so it runs
+	// after the relayer has stopped and reported its last
+	// commands.
+	t.Cleanup(func() {
+		if err := rep.Close(); err != nil {
+			t.Logf("failed to close reporter: %s", err)
+			return
+		}
+		if config.checkRelayerErrors {
+			AssertNoRelayerErrors(t, logPath)
+		}
+	})
+
+	// Tear down whatever gets set up from here, however far setup
+	// gets. This is registered after the reporter's cleanup so that
+	// it runs first.
+	teardown := &interchainTeardown{}
+	t.Cleanup(func() {
+		cleanupInterchain(t, ctx, client, eRep, teardown, true)
+	})
+	dumpTopology(t, ctx, r, eRep, cosmosAtom, cosmosNeutron)
 
 	// Build interchain
+	endBuild := timePhase(t, "build")
+	teardown.ic = ic
 	err = ic.Build(ctx, eRep, ibctest.InterchainBuildOptions{
 		TestName:          t.Name(),
 		Client:            client,
 		NetworkID:         network,
 		BlockDatabaseFile: ibctest.DefaultBlockDatabaseFilepath(),
 
-		SkipPathCreation: false,
+		SkipPathCreation: config.skipPathCreation,
 	})
 	require.NoError(t, err, "failed to build interchain")
+	endBuild()
 
-	err = testutil.WaitForBlocks(ctx, 10, atom, neutron)
-	require.NoError(t, err, "failed to wait for blocks")
+	paths := []string{icsPath, ibcPath}
+	if config.skipPathCreation {
+		// Neutron can't do anything without a CCV channel, so
+		// the ICS path is always needed.
+		err = LinkICSPath(ctx, r, eRep, cosmosAtom, cosmosNeutron, icsPath)
+		require.NoError(t, err, "failed to link ICS path")
+		paths = []string{icsPath}
+	}
 
-	// Start the relayer and clean it up when the test ends.
-	err = r.StartRelayer(ctx, eRep, icsPath, ibcPath)
-	require.NoError(t, err, "failed to start relayer on atom <-> neutron path")
-	t.Cleanup(func() {
-		err = r.StopRelayer(ctx, eRep)
-		if err != nil {
-			t.Logf("failed to stop relayer: %s", err)
+	err = waitForInterchainSettle(ctx, timing, atom, neutron)
+	require.NoError(t, err)
+
+	relayers := []ibc.Relayer{r}
+	if separateTransferRelayer {
+		relayers = append(relayers, transferRelayer)
+		paths = []string{icsPath}
+	}
+	// Neutron rejects transfers until its first VSC packet, so only
+	// Atom can top the relayers up before they start. They are
+	// funded on Neutron in genesis, and topped up there once
+	// transfers are enabled below.
+	fundRelayers := func(chains ...*cosmos.CosmosChain) {
+		for _, toFund := range relayers {
+			err := EnsureRelayerFunded(ctx, toFund, eRep, chains, minRelayerBalance)
+			require.NoError(t, err, "failed to fund relayer")
+			AssertRelayerFunded(t, ctx, toFund, eRep, chains...)
 		}
-	})
+	}
+	fundRelayers(cosmosAtom)
 
-	err = testutil.WaitForBlocks(ctx, 2, atom, neutron)
-	require.NoError(t, err, "failed to wait for blocks")
-
-	// Before receiving a validator set change (VSC) packet,
-	// consumer chains disallow bank transfers. To trigger a VSC
-	// packet, this creates a validator (from a random public key)
-	// that will never do anything, triggering a VSC
-	// packet. Eventually this validator will become jailed,
-	// triggering another one.
-	cmd := []string{"gaiad", "tx", "staking", "create-validator",
-		"--amount", "1000000uatom",
-		"--pubkey", `{"@type":"/cosmos.crypto.ed25519.PubKey","key":"qwrYHaJ7sNHfYBR1nzDr851+wT4ed6p8BbwTeVhaHoA="}`,
-		"--moniker", "a",
-		"--commission-rate", "0.1",
-		"--commission-max-rate", "0.2",
-		"--commission-max-change-rate", "0.01",
-		"--min-self-delegation", "1000000",
-		"--node", atom.GetRPCAddress(),
-		"--home", atom.HomeDir(),
-		"--chain-id", atom.Config().ChainID,
-		"--from", "faucet",
-		"--fees", "20000uatom",
-		"--keyring-backend", keyring.BackendTest,
-		"-y",
-	}
-	_, _, err = atom.Exec(ctx, cmd, nil)
-	require.NoError(t, err)
+	// Start the relayer. It is stopped by the teardown above.
+	endRelayerStart := timePhase(t, "relayer_start")
+	err = r.StartRelayer(ctx, eRep, paths...)
+	require.NoError(t, err, "failed to start relayer on atom <-> neutron path")
+	teardown.started("relayer", r)
+	if separateTransferRelayer {
+		err = transferRelayer.StartRelayer(ctx, eRep, ibcPath)
+		require.NoError(t, err, "failed to start transfer relayer")
+		teardown.started("transfer relayer", transferRelayer)
+	}
 
-	// Wait a bit for the VSC packet to get relayed.
-	err = testutil.WaitForBlocks(ctx, 2, atom, neutron)
-	require.NoError(t, err, "failed to wait for blocks")
+	// Wait for the CCV channel to open. Until it does, the VSC
+	// packet triggered below has no way to get to Neutron.
+	err = WaitForCCVChannel(ctx, cosmosNeutron, 2*time.Minute)
+	require.NoError(t, err, "CCV channel never opened")
+	endRelayerStart()
 
-	// Once the VSC packet has been relayed, x/bank transfers are
-	// enabled on Neutron and we can fund accounts. The funds for
-	// this are sent from a "faucet" account created by
-	// interchaintest in the genesis file.
-	users := ibctest.GetAndFundTestUsers(t, ctx, "default", int64(100_000_000), atom, neutron)
-	_, neutronUser := users[0], users[1]
+	var atomUser, neutronUser *ibc.Wallet
+	if !config.skipVSC {
+		// Before receiving a validator set change (VSC) packet,
+		// consumer chains disallow bank transfers. Trigger one and
+		// wait for it to get relayed.
+		err = TriggerVSC(ctx, cosmosAtom, faucetKeyName)
+		require.NoError(t, err, "failed to trigger VSC packet")
+		err = WaitForTransfersEnabled(ctx, cosmosNeutron, 2*time.Minute)
+		require.NoError(t, err, "transfers never enabled on neutron")
+		fundRelayers(cosmosNeutron)
 
-	// Store and instantiate the Neutron ICA example contract. The
-	// wasm file is placed in `wasms/` by the `just test` command.
-	codeId, err := cosmosNeutron.StoreContract(ctx, neutronUser.KeyName, "wasms/neutron_interchain_txs.wasm")
-	require.NoError(t, err, "failed to store neutron ICA contract")
-	contract, err := cosmosNeutron.InstantiateContract(ctx, neutronUser.KeyName, codeId, `{}`, true)
-	require.NoError(t, err, "failed to instantiate ICA contract")
+		// Now that x/bank transfers are enabled on Neutron we can
+		// fund accounts. The funds for this are sent from a
+		// "faucet" account created by interchaintest in the
+		// genesis file.
+		users := ibctest.GetAndFundTestUsers(t, ctx, "default", int64(100_000_000), atom, neutron)
+		AssertFunded(t, ctx, 100_000_000, users, atom, neutron)
+		atomUser, neutronUser = users[0], users[1]
+	}
 
 	// Locate the connection that the ICS channel is on. This is a
 	// connection between Atom and Neutron and thus a connection
 	// we can create our interchain account on.
-	connections, err := r.GetConnections(ctx, eRep, "neutron-2")
-	require.NoError(t, err, "failed to get neturon-2 IBC connections from relayer")
+	connections, err := r.GetConnections(ctx, eRep, neutronChainID)
+	require.NoError(t, err, "failed to get %s IBC connections from relayer", neutronChainID)
 	var connectionId string
+	var connectionIds []string
 	for _, connection := range connections {
+		connectionIds = append(connectionIds, connection.ID)
 		for _, version := range connection.Versions {
 			if version.String() != "transfer" {
 				connectionId = connection.ID
@@ -256,48 +885,168 @@ func TestICS(t *testing.T) {
 		}
 	}
 
-	// Execute a message to create the account. Interchaintest
-	// v3-ics (the version we use) doesn't set `--gas auto` on
-	// transactions, so as this is a non-trivial smart contract
-	// interaction, it will run out of gas using the "normal"
-	// `neutronCosmos.ExecuteContract`. This manually constructs
-	// the execute transaction to get around this.
-	//
-	// ref: <https://github.com/strangelove-ventures/interchaintest/pull/483>
-	cmd = []string{"neutrond", "tx", "wasm", "execute",
-		contract,
-		`{"register":{"connection_id": "` + connectionId + `","interchain_account_id": "test"}}`,
-		"--from", neutronUser.KeyName,
-		"--gas-prices", "0.0untrn",
-		"--gas-adjustment", `1.5`,
-		"--output", "json",
-		"--home", "/var/cosmos-chain/neutron-2",
-		"--node", neutron.GetRPCAddress(),
-		"--home", neutron.HomeDir(),
-		"--chain-id", neutron.Config().ChainID,
-		"--from", "faucet",
-		"--gas", "auto",
-		"--keyring-backend", keyring.BackendTest,
-		"-y",
-	}
-	_, _, err = neutron.Exec(ctx, cmd, nil)
+	return &icsTestEnv{
+		ctx:             ctx,
+		atom:            cosmosAtom,
+		neutron:         cosmosNeutron,
+		relayer:         r,
+		transferRelayer: transferRelayer,
+		eRep:            eRep,
+		atomUser:        atomUser,
+		neutronUser:     neutronUser,
+		connectionId:    connectionId,
+		connectionIds:   connectionIds,
+		dockerClient:    client,
+		networkID:       network,
+		teardown:        teardown,
+	}
+}
+
+// This tests Cosmos Interchain Security, spinning up a provider and a
+// single consumer chain, and then creates an interchain account on the
+// provider from a smart contract on the consumer.
+//
+// The interchain is built once and shared by each phase's sub-test.
+// Each phase depends on the ones before it, and skips if they did
+// not succeed, so `-run TestICS/<phase>` must also match the phases
+// it depends on.
+func TestICS(t *testing.T) {
+	env := setupICSTestWithConfig(t, icsTestConfig{checkRelayerErrors: true})
+	ctx, atom, neutron := env.ctx, env.atom, env.neutron
+
+	// Store and instantiate the Neutron ICA example contract. The
+	// wasm file is placed in `wasms/` by the `just test` command.
+	codeId := storeICAContract(t, env)
+	contract, err := InstantiateICAContract(ctx, neutron, env.neutronUser.KeyName, codeId, "")
+	require.NoError(t, err, "failed to instantiate ICA contract")
+
+	var registered bool
+	t.Run("register", func(t *testing.T) {
+		channelCount, err := openChannelCount(ctx, env.relayer, env.eRep, neutron.Config().ChainID)
+		require.NoError(t, err)
+
+		// Execute a message to create the account.
+		endHandshake := timePhase(t, "handshake")
+		err = RegisterICA(ctx, neutron, env.neutronUser.KeyName, contract, env.connectionId, "test")
+		require.NoError(t, err)
+
+		// Wait for the ICA packet to get relayed. This takes a
+		// long time as the relayer has to do an entire IBC
+		// handshake because ICA creates a channel per account.
+		err = WaitForChannelCount(ctx, env.relayer, env.eRep, neutron.Config().ChainID, channelCount+1, 2*time.Minute)
+		require.NoError(t, err, "failed to wait for ICA channel")
+		endHandshake()
+		registered = true
+	})
+
+	var icaAddress string
+	t.Run("query address", func(t *testing.T) {
+		if !registered {
+			t.Skip("depends on register")
+		}
+
+		// Query the contract for the address of the account on
+		// Atom.
+		var response QueryResponse
+		err := neutron.QueryContract(ctx, contract, IcaExampleContractQuery{
+			InterchainAccountAddress: &InterchainAccountAddressQuery{
+				InterchainAccountId: "test",
+				ConnectionId:        env.connectionId,
+			},
+		}, &response)
+		require.NoError(t, err, "failed to query ICA account address")
+		require.NotEmpty(t, response.Data.InterchainAccountAddress, "an account should have been created")
+		requireHostAddress(t, atom, response.Data.InterchainAccountAddress)
+		icaAddress = response.Data.InterchainAccountAddress
+	})
+
+	t.Run("submit send", func(t *testing.T) {
+		if icaAddress == "" {
+			t.Skip("depends on query address")
+		}
+
+		fundICA(t, env, icaAddress, 1_000_000)
+
+		// Send some of the funds back from the account, and wait
+		// for the contract to hear that the send executed on Atom.
+		atomUserAddress := env.atomUser.Bech32Address(atom.Config().Bech32Prefix)
+		endAck := timePhase(t, "ack")
+		sequence, err := SubmitICASend(ctx, neutron, env.neutronUser.KeyName, contract, "test", atomUserAddress, 1_000, atom.Config().Denom, 0)
+		require.NoError(t, err, "failed to submit ICA send")
+		result, err := WaitForAcknowledgement(ctx, neutron, contract, "test", sequence, 2*time.Minute)
+		require.NoError(t, err)
+		require.Equal(t, []string{"/cosmos.bank.v1beta1.MsgSend"}, result.Success)
+		endAck()
+
+		balance, err := atom.GetBalance(ctx, icaAddress, atom.Config().Denom)
+		require.NoError(t, err)
+		require.Equal(t, int64(999_000), balance)
+	})
+}
+
+func TestParseNeutronImage(t *testing.T) {
+	parse := func(env map[string]string) (ibc.DockerImage, error) {
+		return parseNeutronImage(func(key string) string { return env[key] })
+	}
+
+	image, err := parse(nil)
 	require.NoError(t, err)
+	require.Equal(t, defaultNeutronImage, image, "unset should pull the release")
 
-	// Wait a bit for the ICA packet to get relayed. This takes a
-	// long time as the relayer has to do an entire IBC handshake
-	// because ICA creates a channel per account.
-	err = testutil.WaitForBlocks(ctx, 10, atom, neutron)
-	require.NoError(t, err, "failed to wait for blocks")
-
-	// Finally, we query the contract for the address of the
-	// account on Atom.
-	var response QueryResponse
-	err = cosmosNeutron.QueryContract(ctx, contract, IcaExampleContractQuery{
-		InterchainAccountAddress: InterchainAccountAddressQuery{
-			InterchainAccountId: "test",
-			ConnectionId:        connectionId,
-		},
-	}, &response)
-	require.NoError(t, err, "failed to query ICA account address")
-	require.NotEmpty(t, response.Data.InterchainAccountAddress, "an account should have been created")
+	image, err = parse(map[string]string{neutronLocalImageEnv: "neutron:local"})
+	require.NoError(t, err)
+	require.Equal(t, ibc.DockerImage{Repository: "neutron", Version: "local", UidGid: "1025:1025"}, image)
+
+	image, err = parse(map[string]string{neutronLocalImageEnv: "localhost:5000/neutron", neutronLocalUidGidEnv: "1000:1000"})
+	require.NoError(t, err)
+	require.Equal(t, ibc.DockerImage{Repository: "localhost:5000/neutron", Version: "latest", UidGid: "1000:1000"}, image)
+
+	_, err = parse(map[string]string{neutronLocalImageEnv: "neutron:"})
+	require.Error(t, err)
+	_, err = parse(map[string]string{neutronLocalUidGidEnv: "1000:1000"})
+	require.ErrorContains(t, err, neutronLocalImageEnv)
+}
+
+// Guards against setup building Neutron from anything but the image
+// NEUTRON_LOCAL_IMAGE and NEUTRON_LOCAL_IMAGE_UIDGID point it at.
+func TestICSChainSpecsNeutronImage(t *testing.T) {
+	env := map[string]string{neutronLocalImageEnv: "neutron:local", neutronLocalUidGidEnv: "1000:1000"}
+	image, err := parseNeutronImage(func(key string) string { return env[key] })
+	require.NoError(t, err)
+
+	specs := icsChainSpecs(icsTestConfig{}, image)
+	require.Len(t, specs, 2)
+	neutron := specs[1].ChainConfig
+	require.Equal(t, "neutron", neutron.Name)
+	require.Equal(t, []ibc.DockerImage{{Repository: "neutron", Version: "local", UidGid: "1000:1000"}}, neutron.Images)
+	require.Empty(t, specs[0].ChainConfig.Images, "gaia should run interchaintest's image")
+}
+
+func TestRelayerImage(t *testing.T) {
+	parse := func(env map[string]string) (ibc.DockerImage, error) {
+		return parseRelayerImage(func(key string) string { return env[key] })
+	}
+
+	image, err := parse(nil)
+	require.NoError(t, err)
+	require.Equal(t, defaultRelayerImage, image)
+
+	image, err = parse(map[string]string{relayerVersionEnv: "v2.4.0"})
+	require.NoError(t, err)
+	require.Equal(t, ibc.DockerImage{Repository: "ghcr.io/cosmos/relayer", Version: "v2.4.0", UidGid: rly.RlyDefaultUidGid}, image)
+
+	_, err = parse(map[string]string{relayerImageEnv: "localhost:5000/relayer:v2.4.0"})
+	require.ErrorContains(t, err, relayerVersionEnv)
+
+	// The factory tests build relayers with runs the overridden
+	// image.
+	image, err = parse(map[string]string{relayerImageEnv: "localhost:5000/relayer", relayerVersionEnv: "main"})
+	require.NoError(t, err)
+	var images []ibc.DockerImage
+	for _, option := range relayerOptions(image) {
+		if o, ok := option.(relayer.RelayerOptionDockerImage); ok {
+			images = append(images, o.DockerImage)
+		}
+	}
+	require.Equal(t, []ibc.DockerImage{{Repository: "localhost:5000/relayer", Version: "main", UidGid: rly.RlyDefaultUidGid}}, images)
 }
@@ -0,0 +1,113 @@
+package ibc_test
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+const (
+	// Set to the path of a prebuilt contract to use that instead of
+	// `icaContractWasm`.
+	wasmPathEnv = "NEUTRON_ICA_WASM"
+	// Set to any non-empty value to build the contract with cargo
+	// when the wasm file is missing.
+	wasmBuildEnv = "NEUTRON_ICA_BUILD"
+	// The contract's source, relative to this directory.
+	contractSourceDir = "../neutron_interchain_txs"
+)
+
+// Sets up the limit on parallel interchains, the block counts tests
+// wait for, and the Neutron and relayer images to run. The contract
+// wasm is only needed once a test stores it, so it is checked then,
+// by `requireContractWasm`.
+func TestMain(m *testing.M) {
+	flag.Parse()
+	if err := configureParallelism(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if err := configureTiming(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if err := configureNeutronImage(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if err := configureRelayerImage(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	os.Exit(m.Run())
+}
+
+var (
+	contractWasmOnce sync.Once
+	contractWasmErr  error
+)
+
+// Fails t if the contract wasm can not be found or built. Tests that
+// store the contract call this first, so that unit tests run without
+// it. The contract is only prepared once per test run.
+func requireContractWasm(t *testing.T) {
+	t.Helper()
+	contractWasmOnce.Do(func() {
+		contractWasmErr = prepareContractWasm()
+	})
+	if contractWasmErr != nil {
+		t.Fatal(contractWasmErr)
+	}
+}
+
+// Points `icaContractWasm` at NEUTRON_ICA_WASM if it is set, and
+// otherwise builds the contract if it is missing and NEUTRON_ICA_BUILD
+// is set.
+func prepareContractWasm() error {
+	if path := os.Getenv(wasmPathEnv); path != "" {
+		if _, err := os.Stat(path); err != nil {
+			return fmt.Errorf("%s is set, but the contract can not be read: %w", wasmPathEnv, err)
+		}
+		icaContractWasm = path
+		return nil
+	}
+	if _, err := os.Stat(icaContractWasm); err == nil {
+		return nil
+	}
+	if os.Getenv(wasmBuildEnv) == "" {
+		return fmt.Errorf("%s not found. Run `just test` from the repository root, set %s=1 to build it with cargo, or set %s to the path of a built contract", icaContractWasm, wasmBuildEnv, wasmPathEnv)
+	}
+	if _, err := os.Stat(filepath.Join(contractSourceDir, "Cargo.toml")); err != nil {
+		return fmt.Errorf("%s is set, but the contract source is not in %s: %w", wasmBuildEnv, contractSourceDir, err)
+	}
+	return buildContractWasm()
+}
+
+// Builds the contract with cargo and copies it to `icaContractWasm`.
+// Unlike `just optimize`, this does not run the wasm through
+// rust-optimizer, so the result is larger but does not need Docker
+// to build.
+func buildContractWasm() error {
+	cmd := exec.Command("cargo", "build", "--release", "--lib", "--target", "wasm32-unknown-unknown")
+	cmd.Dir = contractSourceDir
+	cmd.Env = append(os.Environ(), "RUSTFLAGS=-C link-arg=-s")
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("failed to build contract: %w", err)
+	}
+
+	built := filepath.Join(contractSourceDir, "target", "wasm32-unknown-unknown", "release", "neutron_interchain_txs.wasm")
+	bz, err := os.ReadFile(built)
+	if err != nil {
+		return fmt.Errorf("failed to read built contract: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(icaContractWasm), 0o755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", filepath.Dir(icaContractWasm), err)
+	}
+	return os.WriteFile(icaContractWasm, bz, 0o644)
+}
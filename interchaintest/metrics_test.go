@@ -0,0 +1,117 @@
+package ibc_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Set to a path to have timed tests append their measurements there,
+// one JSON object per line, so that runs can be compared over time.
+// Unset means measurements are only logged.
+const metricsFileEnv = "ICA_METRICS_FILE"
+
+// A measurement, as written to the metrics file.
+type metric struct {
+	Test    string  `json:"test"`
+	Name    string  `json:"name"`
+	Seconds float64 `json:"seconds"`
+}
+
+// Serializes appends to the metrics file from parallel tests.
+var metricsMu sync.Mutex
+
+// Logs that name took d in t, and appends it to the file at
+// `metricsFileEnv` if that is set.
+func recordMetric(t *testing.T, name string, d time.Duration) {
+	t.Helper()
+	t.Logf("%s: %s", name, d)
+
+	path := os.Getenv(metricsFileEnv)
+	if path == "" {
+		return
+	}
+	require.NoError(t, appendMetric(path, metric{Test: t.Name(), Name: name, Seconds: d.Seconds()}))
+}
+
+// Starts timing a phase of t, such as "build" or "handshake". Calling
+// the returned function ends the phase and appends its duration to the
+// file at `metricsFileEnv`. Unlike `recordMetric`, this does nothing
+// when that is unset, so phases can be timed in every test without
+// cluttering their logs.
+func timePhase(t *testing.T, phase string) func() {
+	t.Helper()
+	path := os.Getenv(metricsFileEnv)
+	if path == "" {
+		return func() {}
+	}
+	start := time.Now()
+	return func() {
+		t.Helper()
+		require.NoError(t, appendMetric(path, metric{Test: t.Name(), Name: phase, Seconds: time.Since(start).Seconds()}))
+	}
+}
+
+func appendMetric(path string, m metric) error {
+	bz, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", metricsFileEnv, err)
+	}
+	defer f.Close()
+	_, err = f.Write(append(bz, '\n'))
+	return err
+}
+
+func TestRecordMetric(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.jsonl")
+	t.Setenv(metricsFileEnv, path)
+
+	recordMetric(t, "first", 1500*time.Millisecond)
+	recordMetric(t, "second", 2*time.Second)
+
+	bz, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, `{"test":"TestRecordMetric","name":"first","seconds":1.5}
+{"test":"TestRecordMetric","name":"second","seconds":2}
+`, string(bz))
+}
+
+func TestTimePhase(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.jsonl")
+
+	// Without a metrics file, timing a phase does nothing.
+	t.Setenv(metricsFileEnv, "")
+	timePhase(t, "build")()
+	require.NoFileExists(t, path)
+
+	t.Setenv(metricsFileEnv, path)
+	for _, phase := range []string{"build", "relayer_start"} {
+		timePhase(t, phase)()
+	}
+
+	bz, err := os.ReadFile(path)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSuffix(string(bz), "\n"), "\n")
+	require.Len(t, lines, 2)
+	for i, phase := range []string{"build", "relayer_start"} {
+		var fields map[string]interface{}
+		require.NoError(t, json.Unmarshal([]byte(lines[i]), &fields), "line %d should be JSON", i)
+		require.Equal(t, "TestTimePhase", fields["test"])
+		require.Equal(t, phase, fields["name"])
+		require.IsType(t, float64(0), fields["seconds"])
+	}
+}
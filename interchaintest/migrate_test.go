@@ -0,0 +1,130 @@
+package ibc_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/strangelove-ventures/interchaintest/v3/chain/cosmos"
+	"github.com/strangelove-ventures/interchaintest/v3/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+// Migrates contract to newCodeId and checks that the interchain
+// account with ID accountId survived: the contract must return the
+// same address and channel for it afterwards, with the channel still
+// open. The example contract's migrate does nothing, so this holds
+// today. A migrate that changes the storage layout must carry over
+// the `interchain_accounts` and `interchain_channels` maps, both
+// keyed by the account's port (see `ICAPortID`), or the contract
+// loses track of accounts that Neutron still routes packets for.
+func AssertMigrationPreservesAccount(ctx context.Context, chain *cosmos.CosmosChain, keyName, contract, newCodeId, accountId string) error {
+	address, err := QueryICAAddressFromContract(ctx, chain, contract, accountId)
+	if err != nil {
+		return fmt.Errorf("failed to query %s before migrating: %w", accountId, err)
+	}
+	channel, err := QueryICAChannel(ctx, chain, contract, accountId)
+	if err != nil {
+		return fmt.Errorf("failed to query the channel of %s before migrating: %w", accountId, err)
+	}
+
+	if err := MigrateICAContract(ctx, chain, keyName, contract, newCodeId, struct{}{}); err != nil {
+		return err
+	}
+	codeId, err := QueryContractCodeID(ctx, chain, contract)
+	if err != nil {
+		return err
+	}
+	if codeId != newCodeId {
+		return fmt.Errorf("contract is on code %s after migrating to %s", codeId, newCodeId)
+	}
+
+	migratedAddress, err := QueryICAAddressFromContract(ctx, chain, contract, accountId)
+	if err != nil {
+		return fmt.Errorf("failed to query %s after migrating: %w", accountId, err)
+	}
+	if migratedAddress != address {
+		return fmt.Errorf("migrating changed the address of %s from %s to %s", accountId, address, migratedAddress)
+	}
+	migratedChannel, err := QueryICAChannel(ctx, chain, contract, accountId)
+	if err != nil {
+		return fmt.Errorf("failed to query the channel of %s after migrating: %w", accountId, err)
+	}
+	if *migratedChannel != *channel {
+		return fmt.Errorf("migrating changed the channel of %s from %+v to %+v", accountId, *channel, *migratedChannel)
+	}
+	if migratedChannel.State != "OPEN" {
+		return fmt.Errorf("the channel of %s is %s after migrating", accountId, migratedChannel.State)
+	}
+	return nil
+}
+
+// Tests that a contract instantiated with an admin can be migrated
+// without losing track of its interchain accounts, and that a
+// contract without an admin can not be migrated at all.
+func TestMigrateICAContract(t *testing.T) {
+	env := setupICSTest(t)
+	ctx, neutron := env.ctx, env.neutron
+	keyName := env.neutronUser.KeyName
+	admin := env.neutronUser.Bech32Address(neutron.Config().Bech32Prefix)
+
+	codeId := storeICAContract(t, env)
+	contract, err := InstantiateICAContract(ctx, neutron, keyName, codeId, admin)
+	require.NoError(t, err, "failed to instantiate ICA contract")
+
+	err = RegisterICA(ctx, neutron, keyName, contract, env.connectionId, "test")
+	require.NoError(t, err)
+	err = testutil.WaitForBlocks(ctx, timing.handshakeBlocks, env.atom, neutron)
+	require.NoError(t, err, "failed to wait for blocks")
+
+	address, err := QueryICAAddressFromContract(ctx, neutron, contract, "test")
+	require.NoError(t, err, "failed to query ICA account address")
+	require.NotEmpty(t, address, "an account should have been created")
+
+	// There is only one version of the contract in this
+	// repository, so "v2" is the same wasm stored a second
+	// time. This gets a new code ID, which is all migration
+	// needs.
+	newCodeId, err := neutron.StoreContract(ctx, keyName, icaContractWasm)
+	require.NoError(t, err, "failed to store v2 of neutron ICA contract")
+	require.NotEqual(t, codeId, newCodeId)
+
+	err = MigrateICAContract(ctx, neutron, keyName, contract, newCodeId, struct{}{})
+	require.NoError(t, err, "failed to migrate ICA contract")
+
+	migratedAddress, err := QueryICAAddressFromContract(ctx, neutron, contract, "test")
+	require.NoError(t, err, "failed to query ICA account address after migration")
+	require.Equal(t, address, migratedAddress, "migration should preserve interchain accounts")
+
+	// Without an admin, nobody may migrate the contract.
+	noAdmin, err := InstantiateICAContract(ctx, neutron, keyName, codeId, "")
+	require.NoError(t, err, "failed to instantiate ICA contract")
+	err = MigrateICAContract(ctx, neutron, keyName, noAdmin, newCodeId, struct{}{})
+	require.Error(t, err, "migrating a contract without an admin should fail")
+}
+
+// Tests that migrating keeps an account whose channel is open usable:
+// the contract still knows it, and the channel on chain is still
+// open.
+func TestMigratePreservesAccount(t *testing.T) {
+	env := setupICSTest(t)
+	ctx, neutron := env.ctx, env.neutron
+	keyName := env.neutronUser.KeyName
+	admin := env.neutronUser.Bech32Address(neutron.Config().Bech32Prefix)
+
+	codeId := storeICAContract(t, env)
+	contract, err := InstantiateICAContract(ctx, neutron, keyName, codeId, admin)
+	require.NoError(t, err, "failed to instantiate ICA contract")
+	registerICA(t, env, contract, "test")
+
+	newCodeId, err := neutron.StoreContract(ctx, keyName, icaContractWasm)
+	require.NoError(t, err, "failed to store v2 of neutron ICA contract")
+	err = AssertMigrationPreservesAccount(ctx, neutron, keyName, contract, newCodeId, "test")
+	require.NoError(t, err)
+
+	channel, err := QueryICAChannel(ctx, neutron, contract, "test")
+	require.NoError(t, err)
+	err = WaitForChannelState(ctx, env.relayer, env.eRep, neutron.Config().ChainID, channel.ChannelId, "STATE_OPEN", time.Minute)
+	require.NoError(t, err, "the channel should still be open on chain")
+}
@@ -0,0 +1,130 @@
+package ibc_test
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Set to a positive number to limit how many tests may have an
+// interchain running at once. Each interchain is a handful of Docker
+// containers, and running too many at once can starve them enough to
+// make IBC handshakes flaky. Unset means no limit.
+const maxParallelEnv = "ICA_MAX_PARALLEL"
+
+// Limits how many holders there are at once. A nil semaphore has no
+// limit.
+type semaphore chan struct{}
+
+// Makes a semaphore with limit slots, or with no limit if limit is
+// zero.
+func newSemaphore(limit int) semaphore {
+	if limit == 0 {
+		return nil
+	}
+	return make(semaphore, limit)
+}
+
+// Blocks until a slot is free and takes it. The returned function
+// gives the slot back.
+func (s semaphore) acquire() func() {
+	if s == nil {
+		return func() {}
+	}
+	s <- struct{}{}
+	return func() { <-s }
+}
+
+// Reads the limit from `maxParallelEnv`.
+func parseMaxParallel(value string) (int, error) {
+	if value == "" {
+		return 0, nil
+	}
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit <= 0 {
+		return 0, fmt.Errorf("%s must be a positive integer, got %q", maxParallelEnv, value)
+	}
+	return limit, nil
+}
+
+// Gates interchain builds. Set up by `TestMain`.
+var interchainSlots semaphore
+
+// Reads `maxParallelEnv` and sets up `interchainSlots` to match.
+func configureParallelism() error {
+	limit, err := parseMaxParallel(os.Getenv(maxParallelEnv))
+	if err != nil {
+		return err
+	}
+	interchainSlots = newSemaphore(limit)
+	return nil
+}
+
+// Waits for a free interchain slot, and frees it again when t ends.
+// Call this after `t.Parallel`, so that waiting tests don't hold up
+// the sequential ones, and before building an interchain.
+func acquireInterchainSlot(t *testing.T) {
+	t.Helper()
+	release := interchainSlots.acquire()
+	t.Cleanup(release)
+}
+
+func TestSemaphore(t *testing.T) {
+	const limit, workers = 2, 8
+	s := newSemaphore(limit)
+
+	var running, peak int32
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			release := s.acquire()
+			defer release()
+
+			now := atomic.AddInt32(&running, 1)
+			for {
+				old := atomic.LoadInt32(&peak)
+				if now <= old || atomic.CompareAndSwapInt32(&peak, old, now) {
+					break
+				}
+			}
+			time.Sleep(10 * time.Millisecond)
+			atomic.AddInt32(&running, -1)
+		}()
+	}
+	wg.Wait()
+	require.Equal(t, int32(limit), peak, "at most limit workers should run at once, and the limit should be reached")
+}
+
+func TestUnlimitedSemaphore(t *testing.T) {
+	s := newSemaphore(0)
+	releases := make([]func(), 100)
+	for i := range releases {
+		releases[i] = s.acquire()
+	}
+	for _, release := range releases {
+		release()
+	}
+}
+
+func TestParseMaxParallel(t *testing.T) {
+	limit, err := parseMaxParallel("")
+	require.NoError(t, err)
+	require.Zero(t, limit, "unset should mean unlimited")
+
+	limit, err = parseMaxParallel("3")
+	require.NoError(t, err)
+	require.Equal(t, 3, limit)
+
+	for _, value := range []string{"0", "-1", "two"} {
+		_, err := parseMaxParallel(value)
+		require.Error(t, err, value)
+	}
+}
@@ -0,0 +1,548 @@
+package ibc_test
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	sdk "github.com/cosmos/cosmos-sdk/types"
+	ibctest "github.com/strangelove-ventures/interchaintest/v3"
+	"github.com/strangelove-ventures/interchaintest/v3/ibc"
+	"github.com/stretchr/testify/require"
+)
+
+// A single `register` execution in a registration test case.
+type registration struct {
+	accountId string
+	// Index into the environment's connection IDs.
+	connection int
+	// If set, the register transaction should be rejected with an
+	// error containing this.
+	wantErr string
+}
+
+// Tests how the contract keys interchain accounts by registering
+// different combinations of account and connection IDs. All cases
+// share a single interchain and contract, so each case uses account
+// IDs that no other case does.
+func TestRegistrationCombinations(t *testing.T) {
+	env := setupICSTest(t)
+	ctx, neutron := env.ctx, env.neutron
+	require.GreaterOrEqual(t, len(env.connectionIds), 2, "need two connections to Atom")
+
+	codeId := storeICAContract(t, env)
+	contract, err := InstantiateICAContract(ctx, neutron, env.neutronUser.KeyName, codeId, "")
+	require.NoError(t, err, "failed to instantiate ICA contract")
+
+	cases := []struct {
+		name          string
+		registrations []registration
+	}{
+		{
+			name: "same connection, different ids",
+			registrations: []registration{
+				{accountId: "same-conn-a", connection: 0},
+				{accountId: "same-conn-b", connection: 0},
+			},
+		},
+		{
+			name: "different connections, same id",
+			registrations: []registration{
+				{accountId: "diff-conn", connection: 0},
+				{accountId: "diff-conn", connection: 1},
+			},
+		},
+		{
+			// Once the first account's channel is open, ICA
+			// refuses to open a second one for the same port
+			// on the same connection.
+			name: "duplicate registration",
+			registrations: []registration{
+				{accountId: "duplicate", connection: 0},
+				{accountId: "duplicate", connection: 0, wantErr: "active channel already set for this owner"},
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			addresses := make(map[string]bool)
+			for _, reg := range tc.registrations {
+				connectionId := env.connectionIds[reg.connection]
+				if reg.wantErr != "" {
+					cmd, err := registerCommand(neutron, env.neutronUser.KeyName, contract, connectionId, reg.accountId)
+					require.NoError(t, err)
+					err = ExecExpectError(ctx, neutron, cmd, reg.wantErr)
+					require.NoError(t, err, "registering %s on %s should fail", reg.accountId, connectionId)
+					continue
+				}
+				err := RegisterICA(ctx, neutron, env.neutronUser.KeyName, contract, connectionId, reg.accountId)
+				require.NoError(t, err, "failed to register %s on %s", reg.accountId, connectionId)
+
+				address, err := WaitForICAAddress(ctx, neutron, contract, reg.accountId, connectionId, 2*time.Minute)
+				require.NoError(t, err)
+				require.False(t, addresses[address], "ICA %s on %s collides with an earlier account", reg.accountId, connectionId)
+				addresses[address] = true
+			}
+		})
+	}
+}
+
+// Tests that querying the address of an account that was never
+// registered fails, as Neutron has no channel to look the account up
+// by. `WaitForICAAddress` keeps polling through such errors, since a
+// pending account fails the same way until its handshake completes.
+func TestQueryUnregisteredICA(t *testing.T) {
+	env := setupICSTest(t)
+	ctx, neutron := env.ctx, env.neutron
+
+	codeId := storeICAContract(t, env)
+	contract, err := InstantiateICAContract(ctx, neutron, env.neutronUser.KeyName, codeId, "")
+	require.NoError(t, err, "failed to instantiate ICA contract")
+
+	var response QueryResponse
+	err = neutron.QueryContract(ctx, contract, IcaExampleContractQuery{
+		InterchainAccountAddress: &InterchainAccountAddressQuery{
+			InterchainAccountId: "never-registered",
+			ConnectionId:        env.connectionId,
+		},
+	}, &response)
+	require.ErrorContains(t, err, "no interchain account found", "querying an unregistered account should fail")
+}
+
+// Tests that the contract records the port and channel of an
+// interchain account, and that it reports the account as pending
+// until the channel handshake completes. The relayer is stopped while
+// registering so that the handshake can not complete before the
+// pending state is checked.
+func TestICAChannelDetails(t *testing.T) {
+	env := setupICSTest(t)
+	ctx, neutron := env.ctx, env.neutron
+	chainID := neutron.Config().ChainID
+
+	contract := deployICAContract(t, env)
+	expectedPort := ICAPortID(contract, "test")
+
+	err := env.relayer.StopRelayer(ctx, env.eRep)
+	require.NoError(t, err, "failed to stop relayer")
+
+	err = RegisterICA(ctx, neutron, env.neutronUser.KeyName, contract, env.connectionId, "test")
+	require.NoError(t, err)
+
+	// The register transaction has been included, so the contract
+	// knows about the account even though the relayer is stopped.
+	pending, err := QueryICAChannel(ctx, neutron, contract, "test")
+	require.NoError(t, err, "the registered account should be queryable")
+	require.Equal(t, InterchainAccountChannel{PortId: expectedPort, State: "PENDING"}, *pending)
+
+	err = env.relayer.StartRelayer(ctx, env.eRep, icsPath, ibcPath)
+	require.NoError(t, err, "failed to restart relayer")
+	_, err = WaitForICAAddress(ctx, neutron, contract, "test", env.connectionId, 2*time.Minute)
+	require.NoError(t, err)
+	channelId, err := WaitForICAChannel(ctx, env.relayer, env.eRep, chainID, env.connectionId, time.Minute)
+	require.NoError(t, err)
+
+	channel, err := QueryICAChannel(ctx, neutron, contract, "test")
+	require.NoError(t, err)
+	require.Equal(t, expectedPort, channel.PortId)
+	require.Equal(t, channelId, channel.ChannelId)
+	require.Equal(t, "OPEN", channel.State)
+
+	// The recorded channel should be the one the relayer sees.
+	relayed, err := FindICAChannel(ctx, env.relayer, env.eRep, chainID, expectedPort)
+	require.NoError(t, err)
+	require.Equal(t, channel.ChannelId, relayed.ChannelID)
+	require.Equal(t, "STATE_OPEN", relayed.State)
+}
+
+// Tests the version negotiated for an interchain account's channel.
+// Registering proposes no version, so the controller proposes the
+// ICS-27 defaults, protobuf encoded messages in multi-message
+// transactions, and the host fills in the account's address. The
+// version names both ends of the connection the account was
+// registered on.
+func TestICAChannelVersion(t *testing.T) {
+	env := setupICSTest(t)
+	ctx, neutron := env.ctx, env.neutron
+	chainID := neutron.Config().ChainID
+
+	contract := deployICAContract(t, env)
+	icaAddress := registerICA(t, env, contract, "test")
+
+	version, err := QueryICAChannelVersion(ctx, env.relayer, env.eRep, chainID, ICAPortID(contract, "test"))
+	require.NoError(t, err)
+	require.Equal(t, "proto3", version.Encoding)
+	require.Equal(t, "sdk_multi_msg", version.TxType)
+	require.Equal(t, icaAddress, version.Address)
+
+	hostConnectionId, err := counterpartyConnectionID(ctx, env.relayer, env.eRep, chainID, env.connectionId)
+	require.NoError(t, err)
+	require.Equal(t, env.connectionId, version.ControllerConnectionId)
+	require.Equal(t, hostConnectionId, version.HostConnectionId)
+}
+
+// Tests that an interchain account's channel is opened to the host's
+// interchain accounts module, rather than some other module on Atom.
+func TestICACounterpartyPort(t *testing.T) {
+	env := setupICSTest(t)
+	chainID := env.neutron.Config().ChainID
+
+	contract := deployICAContract(t, env)
+	registerICA(t, env, contract, "test")
+
+	err := AssertICACounterpartyPort(env.ctx, env.relayer, env.eRep, chainID, ICAPortID(contract, "test"))
+	require.NoError(t, err)
+}
+
+// Tests that simulating a register transaction estimates its gas
+// without registering anything.
+func TestSimulateRegister(t *testing.T) {
+	env := setupICSTest(t)
+	ctx, neutron := env.ctx, env.neutron
+
+	contract := deployICAContract(t, env)
+
+	cmd, err := registerCommand(neutron, env.neutronUser.KeyName, contract, env.connectionId, "test")
+	require.NoError(t, err)
+	gas, err := SimulateICATx(ctx, neutron, cmd)
+	require.NoError(t, err)
+	require.NotZero(t, gas)
+	// Registering is a single contract execution, which should come
+	// in far below a block's gas limit.
+	require.Less(t, gas, uint64(10_000_000), "implausibly large gas estimate")
+
+	_, err = QueryICAChannel(ctx, neutron, contract, "test")
+	require.Error(t, err, "simulating should not register the account")
+}
+
+// Tests that an interchain account's port is derived from the
+// contract, not from the account that instantiated it.
+func TestICAPortOwner(t *testing.T) {
+	env := setupICSTest(t)
+	ctx, neutron := env.ctx, env.neutron
+
+	// Instantiate from an account other than the one that stores
+	// the code or registers accounts.
+	instantiator := ibctest.GetAndFundTestUsers(t, ctx, "instantiator", 100_000_000, neutron)[0]
+	codeId := storeICAContract(t, env)
+	contract, err := InstantiateICAContract(ctx, neutron, instantiator.KeyName, codeId, "")
+	require.NoError(t, err, "failed to instantiate ICA contract")
+
+	creator, err := QueryContractOwner(ctx, neutron, contract)
+	require.NoError(t, err)
+	require.Equal(t, instantiator.Bech32Address(neutron.Config().Bech32Prefix), creator)
+
+	registerICA(t, env, contract, "test")
+
+	channel, err := QueryICAChannel(ctx, neutron, contract, "test")
+	require.NoError(t, err)
+	require.Equal(t, ICAPortID(contract, "test"), channel.PortId)
+	require.NotEqual(t, ICAPortID(creator, "test"), channel.PortId)
+}
+
+// Tests that two contracts registering the same account ID on the
+// same connection get separate interchain accounts. Each contract
+// owns its own controller port, so neither can use the other's
+// account.
+func TestTwoContractsSameConnection(t *testing.T) {
+	env := setupICSTest(t)
+	ctx, neutron := env.ctx, env.neutron
+
+	// Both contracts are instantiated from the code stored for the
+	// first.
+	first := deployICAContract(t, env)
+	second := deployICAContract(t, env)
+	require.NotEqual(t, first, second)
+	firstCodeId, err := QueryContractCodeID(ctx, neutron, first)
+	require.NoError(t, err)
+	secondCodeId, err := QueryContractCodeID(ctx, neutron, second)
+	require.NoError(t, err)
+	require.Equal(t, firstCodeId, secondCodeId, "the wasm should only have been stored once")
+
+	firstAddress := registerICA(t, env, first, "test")
+	secondAddress := registerICA(t, env, second, "test")
+	require.NotEqual(t, firstAddress, secondAddress, "contracts should not share an ICA")
+
+	firstChannel, err := QueryICAChannel(ctx, neutron, first, "test")
+	require.NoError(t, err)
+	secondChannel, err := QueryICAChannel(ctx, neutron, second, "test")
+	require.NoError(t, err)
+
+	require.Equal(t, "OPEN", firstChannel.State)
+	require.Equal(t, "OPEN", secondChannel.State)
+	require.Equal(t, ICAPortID(first, "test"), firstChannel.PortId)
+	require.Equal(t, ICAPortID(second, "test"), secondChannel.PortId)
+	require.NotEqual(t, firstChannel.ChannelId, secondChannel.ChannelId)
+}
+
+// Measures how long it takes from broadcasting a register
+// transaction to the contract knowing the account's address. This is
+// mostly the relayer completing the channel handshake, so relayer or
+// chain upgrades that slow handshakes down show up here. See
+// `metricsFileEnv` for keeping the measurement.
+func TestRegisterLatency(t *testing.T) {
+	env := setupICSTest(t)
+	ctx, neutron := env.ctx, env.neutron
+	contract := deployICAContract(t, env)
+
+	start := time.Now()
+	err := RegisterICA(ctx, neutron, env.neutronUser.KeyName, contract, env.connectionId, "test")
+	require.NoError(t, err)
+	_, err = WaitForICAAddress(ctx, neutron, contract, "test", env.connectionId, 2*time.Minute)
+	require.NoError(t, err)
+	recordMetric(t, "register_to_address", time.Since(start))
+}
+
+// How many accounts `TestConcurrentRegistration` registers at once.
+// Defaults to `defaultConcurrentAccounts`, and may be at most
+// `maxConcurrentAccounts`.
+const concurrentAccountsEnv = "ICA_CONCURRENT_ACCOUNTS"
+
+const (
+	defaultConcurrentAccounts = 5
+	// Each account is a channel handshake for the relayer, and a
+	// funded user to sign its registration.
+	maxConcurrentAccounts = 20
+)
+
+// Reads the number of accounts from `concurrentAccountsEnv`.
+func parseConcurrentAccounts(value string) (int, error) {
+	if value == "" {
+		return defaultConcurrentAccounts, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n <= 0 || n > maxConcurrentAccounts {
+		return 0, fmt.Errorf("%s must be an integer from 1 to %d, got %q", concurrentAccountsEnv, maxConcurrentAccounts, value)
+	}
+	return n, nil
+}
+
+// Tests that the contract and relayer cope with many channel
+// handshakes at once, by registering several accounts concurrently.
+// Each registration is signed by its own user, as concurrent
+// transactions from one account would race for its sequence number.
+func TestConcurrentRegistration(t *testing.T) {
+	env := setupICSTest(t)
+	n, err := parseConcurrentAccounts(os.Getenv(concurrentAccountsEnv))
+	require.NoError(t, err)
+	ctx, neutron := env.ctx, env.neutron
+	contract := deployICAContract(t, env)
+
+	chains := make([]ibc.Chain, n)
+	for i := range chains {
+		chains[i] = neutron
+	}
+	registrants := ibctest.GetAndFundTestUsers(t, ctx, "registrant", 10_000_000, chains...)
+
+	start := time.Now()
+	addresses := make([]string, n)
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for i, registrant := range registrants {
+		wg.Add(1)
+		go func(i int, keyName string) {
+			defer wg.Done()
+			accountId := fmt.Sprintf("concurrent-%d", i)
+			if errs[i] = RegisterICA(ctx, neutron, keyName, contract, env.connectionId, accountId); errs[i] != nil {
+				return
+			}
+			addresses[i], errs[i] = WaitForICAAddress(ctx, neutron, contract, accountId, env.connectionId, 5*time.Minute)
+		}(i, registrant.KeyName)
+	}
+	wg.Wait()
+	recordMetric(t, fmt.Sprintf("register_%d_concurrently", n), time.Since(start))
+
+	seen := make(map[string]bool)
+	for i, address := range addresses {
+		require.NoError(t, errs[i], "account %d", i)
+		requireHostAddress(t, env.atom, address)
+		require.False(t, seen[address], "account %d shares its address with another account", i)
+		seen[address] = true
+	}
+}
+
+func TestParseConcurrentAccounts(t *testing.T) {
+	n, err := parseConcurrentAccounts("")
+	require.NoError(t, err)
+	require.Equal(t, defaultConcurrentAccounts, n)
+
+	n, err = parseConcurrentAccounts("12")
+	require.NoError(t, err)
+	require.Equal(t, 12, n)
+
+	for _, invalid := range []string{"0", "-1", "21", "five"} {
+		_, err := parseConcurrentAccounts(invalid)
+		require.Error(t, err, invalid)
+	}
+}
+
+// Tests that every account a contract registers on a connection is
+// listed for that connection, and for no other.
+func TestQueryAccountsByConnection(t *testing.T) {
+	env := setupICSTest(t)
+	ctx, neutron := env.ctx, env.neutron
+
+	contract := deployICAContract(t, env)
+	other := deployICAContract(t, env)
+	registerICA(t, env, contract, "first")
+	registerICA(t, env, contract, "second")
+	registerICA(t, env, other, "third")
+
+	accountIds, err := QueryAccountsByConnection(ctx, neutron, contract, env.connectionId)
+	require.NoError(t, err)
+	require.Equal(t, []string{"first", "second"}, accountIds)
+
+	for _, connectionId := range env.connectionIds {
+		if connectionId == env.connectionId {
+			continue
+		}
+		accountIds, err := QueryAccountsByConnection(ctx, neutron, contract, connectionId)
+		require.NoError(t, err)
+		require.Empty(t, accountIds, "no accounts were registered on %s", connectionId)
+	}
+}
+
+func TestParseConnectionAccounts(t *testing.T) {
+	stdout, err := os.ReadFile("testdata/ibc_connection_channels.json")
+	require.NoError(t, err)
+
+	// The second account's channel was closed and reopened.
+	accountIds, err := parseConnectionAccounts(stdout, "neutron14hj2tavq8fpesdwxxcu44rty3hh90vhujrvcmstl4zr3txmfvw9s5c2epq")
+	require.NoError(t, err)
+	require.Equal(t, []string{"a", "b"}, accountIds)
+
+	accountIds, err = parseConnectionAccounts(stdout, "neutron1contract")
+	require.NoError(t, err)
+	require.Empty(t, accountIds)
+}
+
+// Tests that registering on a connection that doesn't exist never
+// produces an account. The contract sends the register message as a
+// plain message rather than a submessage, so Neutron's rejection of
+// the connection should fail the whole transaction, when `--gas auto`
+// simulates it. Should the rejection ever become asynchronous, the
+// account must still never get an address.
+func TestRegisterInvalidConnection(t *testing.T) {
+	env := setupICSTest(t)
+	ctx, neutron := env.ctx, env.neutron
+	const connectionId = "connection-999"
+
+	contract := deployICAContract(t, env)
+
+	cmd, err := registerCommand(neutron, env.neutronUser.KeyName, contract, connectionId, "test")
+	require.NoError(t, err)
+	err = ExecExpectError(ctx, neutron, cmd, "connection not found")
+	if !errors.Is(err, errCommandSucceeded) {
+		require.NoError(t, err, "registering on %s should be rejected for the missing connection", connectionId)
+		// A synchronous rejection reverts the contract's
+		// record of the account along with everything else.
+		_, err = QueryICAChannel(ctx, neutron, contract, "test")
+		require.Error(t, err, "a rejected registration should not be recorded")
+	} else {
+		t.Logf("registering on %s was accepted, checking no channel opens", connectionId)
+		_, err = WaitForICAAddress(ctx, neutron, contract, "test", connectionId, time.Minute)
+		require.Error(t, err, "an account on %s should never get an address", connectionId)
+		channel, err := QueryICAChannel(ctx, neutron, contract, "test")
+		if err == nil {
+			require.Equal(t, "PENDING", channel.State)
+		}
+	}
+
+	_, err = QueryICAAddressFromContract(ctx, neutron, contract, "test")
+	require.Error(t, err, "the contract should not have an address for the account")
+}
+
+// Tests that registering works when Neutron charges for gas, and that
+// the register transaction pays the fee its gas prices call for.
+func TestRegisterWithGasPrices(t *testing.T) {
+	const gasPrices = "0.025untrn"
+	env := setupICSTestWithConfig(t, icsTestConfig{neutronGasPrices: gasPrices})
+	ctx, neutron := env.ctx, env.neutron
+
+	contract := deployICAContract(t, env)
+
+	cmd, err := registerCommand(neutron, env.neutronUser.KeyName, contract, env.connectionId, "test")
+	require.NoError(t, err)
+	stdout, _, err := neutron.Exec(ctx, cmd, nil)
+	require.NoError(t, err, "failed to register ICA")
+	var response txResponse
+	require.NoError(t, json.Unmarshal(stdout, &response))
+	require.Zero(t, response.Code, "register rejected: %s", response.RawLog)
+
+	var fee sdk.Coins
+	var gasLimit uint64
+	require.Eventually(t, func() bool {
+		fee, gasLimit, err = QueryTxFee(ctx, neutron, response.TxHash)
+		return err == nil
+	}, time.Minute, pollInterval, "register transaction never included")
+
+	expected, err := feeForGas(gasPrices, gasLimit)
+	require.NoError(t, err)
+	require.False(t, expected.IsZero())
+	require.Equal(t, expected, fee)
+
+	_, err = WaitForICAAddress(ctx, neutron, contract, "test", env.connectionId, 2*time.Minute)
+	require.NoError(t, err)
+}
+
+// Tests that an interchain account can be registered again after its
+// channel closes, getting the same address over a new channel.
+//
+// The contract has no message to close a channel, and ICS-27 refuses
+// to close interchain account channels on request from either end, so
+// there is no graceful teardown to test. The only way the channel
+// closes is a packet timing out, which this uses instead.
+func TestICAChannelReopen(t *testing.T) {
+	env := setupICSTest(t)
+	ctx, neutron := env.ctx, env.neutron
+	chainID := neutron.Config().ChainID
+
+	contract := deployICAContract(t, env)
+	address := registerICA(t, env, contract, "test")
+	closed, err := QueryICAChannel(ctx, neutron, contract, "test")
+	require.NoError(t, err)
+
+	timeOutICAPacket(t, env, contract, "test")
+	err = WaitForChannelState(ctx, env.relayer, env.eRep, chainID, closed.ChannelId, "STATE_CLOSED", time.Minute)
+	require.NoError(t, err, "the timeout should close the channel")
+	stale, err := QueryICAChannel(ctx, neutron, contract, "test")
+	require.NoError(t, err)
+	require.Equal(t, InterchainAccountChannel{PortId: closed.PortId, ChannelId: closed.ChannelId, State: "CLOSED"}, *stale,
+		"the contract should no longer report the channel open")
+
+	// Neutron remembers the account's address from the first
+	// channel, so wait on the contract for the new channel instead
+	// of on the address.
+	err = RegisterICA(ctx, neutron, env.neutronUser.KeyName, contract, env.connectionId, "test")
+	require.NoError(t, err, "failed to register ICA again")
+	var channel *InterchainAccountChannel
+	require.Eventually(t, func() bool {
+		channel, err = QueryICAChannel(ctx, neutron, contract, "test")
+		return err == nil && channel.State == "OPEN" && channel.ChannelId != closed.ChannelId
+	}, 2*time.Minute, pollInterval, "no new channel opened")
+
+	reopened, err := QueryICAAddressFromContract(ctx, neutron, contract, "test")
+	require.NoError(t, err)
+	require.Equal(t, address, reopened, "the account should keep its address")
+	require.Equal(t, closed.PortId, channel.PortId)
+	require.NotEqual(t, closed.ChannelId, channel.ChannelId, "registering again should open a new channel")
+	err = WaitForChannelState(ctx, env.relayer, env.eRep, chainID, channel.ChannelId, "STATE_OPEN", time.Minute)
+	require.NoError(t, err)
+}
+
+// Tests that the harness finds Neutron's connections by the chain ID
+// it was configured with, rather than assuming `neutron-2`.
+func TestCustomNeutronChainID(t *testing.T) {
+	env := setupICSTestWithConfig(t, icsTestConfig{neutronChainID: "neutron-test-1"})
+	require.Equal(t, "neutron-test-1", env.neutron.Config().ChainID)
+	require.NotEmpty(t, env.connectionId, "no ICA connection found on neutron-test-1")
+
+	contract := deployICAContract(t, env)
+	icaAddress := registerICA(t, env, contract, "test")
+	requireHostAddress(t, env.atom, icaAddress)
+}
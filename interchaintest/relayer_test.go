@@ -0,0 +1,1013 @@
+package ibc_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/strangelove-ventures/interchaintest/v3/chain/cosmos"
+	"github.com/strangelove-ventures/interchaintest/v3/ibc"
+	"github.com/strangelove-ventures/interchaintest/v3/testreporter"
+	"github.com/stretchr/testify/require"
+)
+
+// Finds the connection on chainID that the relayer's IBC transfer
+// channel runs over. Neutron has two clients tracking Atom (one for
+// ICS and one for the transfer path), so `ibc.GetTransferChannel`
+// refuses to pick between them.
+func transferConnection(ctx context.Context, r ibc.Relayer, eRep *testreporter.RelayerExecReporter, chainID string) (*ibc.ConnectionOutput, error) {
+	channel, err := transferChannel(ctx, r, eRep, chainID)
+	if err != nil {
+		return nil, err
+	}
+	connectionId := channel.ConnectionHops[0]
+
+	connections, err := r.GetConnections(ctx, eRep, chainID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get connections on %s: %w", chainID, err)
+	}
+	for _, connection := range connections {
+		if connection.ID == connectionId {
+			return connection, nil
+		}
+	}
+	return nil, fmt.Errorf("transfer channel's connection %s not found on %s", connectionId, chainID)
+}
+
+// Finds the channel of the IBC transfer path on chainID. The ICS
+// connection also carries a transfer channel, which the consumer
+// opens to send rewards to the provider, so this skips transfer
+// channels on the same connection as a CCV channel.
+func transferChannel(ctx context.Context, r ibc.Relayer, eRep *testreporter.RelayerExecReporter, chainID string) (*ibc.ChannelOutput, error) {
+	channels, err := ListChannels(ctx, r, eRep, chainID)
+	if err != nil {
+		return nil, err
+	}
+	ccvConnections := make(map[string]bool)
+	for _, channel := range channels {
+		if (channel.PortID == ccvConsumerPort || channel.PortID == ccvProviderPort) && len(channel.ConnectionHops) == 1 {
+			ccvConnections[channel.ConnectionHops[0]] = true
+		}
+	}
+	for _, channel := range channels {
+		if channel.PortID == "transfer" && len(channel.ConnectionHops) == 1 && !ccvConnections[channel.ConnectionHops[0]] {
+			return &channel, nil
+		}
+	}
+	return nil, fmt.Errorf("no transfer channel found on %s", chainID)
+}
+
+// Returns every IBC client on chainID, as reported by the relayer.
+// Neutron has more than one client tracking Atom, so callers that
+// want a particular one should filter on the client ID, for example
+// the `ClientID` of a connection.
+func GetClients(ctx context.Context, r ibc.Relayer, eRep *testreporter.RelayerExecReporter, chainID string) ([]ibc.ClientOutput, error) {
+	clients, err := r.GetClients(ctx, eRep, chainID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get clients on %s: %w", chainID, err)
+	}
+	outputs := make([]ibc.ClientOutput, 0, len(clients))
+	for _, client := range clients {
+		outputs = append(outputs, *client)
+	}
+	return outputs, nil
+}
+
+// Links provider and consumer on path, as `ic.Build` does for a
+// provider consumer link when path creation is not skipped. The
+// provider and consumer already have clients tracking each other
+// from the consumer's genesis, so this reuses those clients rather
+// than creating new ones, and then opens a connection and the CCV
+// channel over them.
+func LinkICSPath(ctx context.Context, r ibc.Relayer, eRep *testreporter.RelayerExecReporter, provider, consumer ibc.Chain, path string) error {
+	providerID, consumerID := provider.Config().ChainID, consumer.Config().ChainID
+	if err := r.GeneratePath(ctx, eRep, consumerID, providerID, path); err != nil {
+		return fmt.Errorf("failed to generate path %s: %w", path, err)
+	}
+
+	consumerClient, err := clientTracking(ctx, r, eRep, consumerID, providerID)
+	if err != nil {
+		return err
+	}
+	providerClient, err := clientTracking(ctx, r, eRep, providerID, consumerID)
+	if err != nil {
+		return err
+	}
+	if err := r.UpdatePath(ctx, eRep, path, ibc.PathUpdateOptions{
+		SrcClientID: &consumerClient,
+		DstClientID: &providerClient,
+	}); err != nil {
+		return fmt.Errorf("failed to update path %s: %w", path, err)
+	}
+
+	if err := r.CreateConnections(ctx, eRep, path); err != nil {
+		return fmt.Errorf("failed to create connections on path %s: %w", path, err)
+	}
+	if err := r.CreateChannel(ctx, eRep, path, ibc.CreateChannelOptions{
+		SourcePortName: ccvConsumerPort,
+		DestPortName:   ccvProviderPort,
+		Order:          ibc.Ordered,
+		Version:        "1",
+	}); err != nil {
+		return fmt.Errorf("failed to create CCV channel on path %s: %w", path, err)
+	}
+	return nil
+}
+
+// Generates path from srcChainID to dstChainID and opens a connection
+// on it over new clients, for building topology after `ic.Build` with
+// path creation skipped. Returns the ID of the new connection on
+// srcChainID. As with any path made after the relayer starts, the
+// relayer must be restarted with the path to relay it.
+func CreateConnection(ctx context.Context, r ibc.Relayer, eRep *testreporter.RelayerExecReporter, srcChainID, dstChainID, path string) (string, error) {
+	before, err := r.GetConnections(ctx, eRep, srcChainID)
+	if err != nil {
+		return "", fmt.Errorf("failed to get connections on %s: %w", srcChainID, err)
+	}
+	existing := make(map[string]bool, len(before))
+	for _, connection := range before {
+		existing[connection.ID] = true
+	}
+
+	if err := r.GeneratePath(ctx, eRep, srcChainID, dstChainID, path); err != nil {
+		return "", fmt.Errorf("failed to generate path %s: %w", path, err)
+	}
+	if err := r.CreateClients(ctx, eRep, path, ibc.DefaultClientOpts()); err != nil {
+		return "", fmt.Errorf("failed to create clients on path %s: %w", path, err)
+	}
+	if err := r.CreateConnections(ctx, eRep, path); err != nil {
+		return "", fmt.Errorf("failed to create connections on path %s: %w", path, err)
+	}
+
+	after, err := r.GetConnections(ctx, eRep, srcChainID)
+	if err != nil {
+		return "", fmt.Errorf("failed to get connections on %s: %w", srcChainID, err)
+	}
+	for _, connection := range after {
+		if !existing[connection.ID] {
+			return connection.ID, nil
+		}
+	}
+	return "", fmt.Errorf("no new connection on %s after linking path %s", srcChainID, path)
+}
+
+// Opens an ICS-20 transfer channel on path, which must already have a
+// connection, for example one from `CreateConnection`. Returns the ID
+// of the new channel on chainID, which may be either end of path.
+func CreateTransferChannel(ctx context.Context, r ibc.Relayer, eRep *testreporter.RelayerExecReporter, chainID, path string) (string, error) {
+	before, err := ListChannels(ctx, r, eRep, chainID)
+	if err != nil {
+		return "", err
+	}
+	existing := make(map[string]bool, len(before))
+	for _, channel := range before {
+		existing[channel.ChannelID] = true
+	}
+
+	if err := r.CreateChannel(ctx, eRep, path, ibc.DefaultChannelOpts()); err != nil {
+		return "", fmt.Errorf("failed to create transfer channel on path %s: %w", path, err)
+	}
+
+	after, err := ListChannels(ctx, r, eRep, chainID)
+	if err != nil {
+		return "", err
+	}
+	for _, channel := range after {
+		if channel.PortID == "transfer" && !existing[channel.ChannelID] {
+			return channel.ChannelID, nil
+		}
+	}
+	return "", fmt.Errorf("no new transfer channel on %s after creating one on path %s", chainID, path)
+}
+
+// Returns the ID of the first client on chainID that tracks
+// trackedChainID.
+func clientTracking(ctx context.Context, r ibc.Relayer, eRep *testreporter.RelayerExecReporter, chainID, trackedChainID string) (string, error) {
+	clients, err := GetClients(ctx, r, eRep, chainID)
+	if err != nil {
+		return "", err
+	}
+	for _, client := range clients {
+		if client.ClientState.ChainID == trackedChainID {
+			return client.ClientID, nil
+		}
+	}
+	return "", fmt.Errorf("no client on %s tracks %s", chainID, trackedChainID)
+}
+
+// The least of each chain's native denom that the relayer should
+// hold before it starts. It pays the fees of every handshake and
+// packet it relays, and when it runs out, ICA handshakes stall
+// without any error reaching the test.
+const minRelayerBalance = 1_000_000
+
+// Returns the balance of the relayer's wallet on each of chains in
+// that chain's native denom, keyed by chain ID.
+func GetRelayerBalances(ctx context.Context, r ibc.Relayer, eRep *testreporter.RelayerExecReporter, chains ...*cosmos.CosmosChain) (map[string]int64, error) {
+	balances := make(map[string]int64, len(chains))
+	for _, chain := range chains {
+		chainID := chain.Config().ChainID
+		wallet, ok := r.GetWallet(chainID)
+		if !ok {
+			return nil, fmt.Errorf("relayer has no wallet on %s", chainID)
+		}
+		balance, err := queryBalance(ctx, chain, wallet.Address, chain.Config().Denom)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get relayer balance on %s: %w", chainID, err)
+		}
+		balances[chainID] = balance
+	}
+	return balances, nil
+}
+
+// Asserts that the relayer holds at least `minRelayerBalance` on
+// each of chains.
+func AssertRelayerFunded(t *testing.T, ctx context.Context, r ibc.Relayer, eRep *testreporter.RelayerExecReporter, chains ...*cosmos.CosmosChain) {
+	t.Helper()
+	balances, err := GetRelayerBalances(ctx, r, eRep, chains...)
+	require.NoError(t, err)
+	for _, chain := range chains {
+		chainID := chain.Config().ChainID
+		require.GreaterOrEqual(t, balances[chainID], int64(minRelayerBalance), "relayer underfunded on %s", chainID)
+	}
+}
+
+// Returns how much each chain's relayer wallet needs, keyed by chain
+// ID, to bring balances up to min. Chains already at min are left out.
+func relayerTopUps(balances map[string]int64, min int64) map[string]int64 {
+	topUps := make(map[string]int64)
+	for chainID, balance := range balances {
+		if balance < min {
+			topUps[chainID] = min - balance
+		}
+	}
+	return topUps
+}
+
+// Tops the relayer's wallet on each of chains up to min of the
+// chain's native denom from the faucet, if it holds less. Call this
+// before starting the relayer so that it doesn't run out of fees
+// part way through a handshake. Neutron rejects transfers until its
+// first VSC packet, so only pass it once one has arrived, as
+// `setupICSTestWithConfig` does. interchaintest funds the relayer in
+// genesis, so it normally needs none.
+func EnsureRelayerFunded(ctx context.Context, r ibc.Relayer, eRep *testreporter.RelayerExecReporter, chains []*cosmos.CosmosChain, min int64) error {
+	balances, err := GetRelayerBalances(ctx, r, eRep, chains...)
+	if err != nil {
+		return err
+	}
+	topUps := relayerTopUps(balances, min)
+	for _, chain := range chains {
+		chainID := chain.Config().ChainID
+		amount, ok := topUps[chainID]
+		if !ok {
+			continue
+		}
+		wallet, _ := r.GetWallet(chainID)
+		err := chain.SendFunds(ctx, faucetKeyName, ibc.WalletAmount{
+			Address: wallet.Address,
+			Denom:   chain.Config().Denom,
+			Amount:  amount,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to top up relayer on %s with %d%s: %w", chainID, amount, chain.Config().Denom, err)
+		}
+	}
+	return nil
+}
+
+// Lists every channel on chainID, in any state, with its port,
+// version, ordering, and connection.
+func ListChannels(ctx context.Context, r ibc.Relayer, eRep *testreporter.RelayerExecReporter, chainID string) ([]ibc.ChannelOutput, error) {
+	channels, err := r.GetChannels(ctx, eRep, chainID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get channels on %s: %w", chainID, err)
+	}
+	return channels, nil
+}
+
+// An ICS-27 channel version. This is metadata that the controller
+// proposes and the host may amend during the channel handshake.
+type icaChannelVersion struct {
+	Version                string `json:"version"`
+	ControllerConnectionId string `json:"controller_connection_id"`
+	HostConnectionId       string `json:"host_connection_id"`
+	// The interchain account's address, set by the host.
+	Address string `json:"address"`
+	// How messages in packets are encoded, "proto3" or
+	// "proto3json".
+	Encoding string `json:"encoding"`
+	TxType   string `json:"tx_type"`
+}
+
+// Parses the ICS-27 metadata in the version of an interchain account
+// channel.
+func parseICAChannelVersion(version string) (*icaChannelVersion, error) {
+	var parsed icaChannelVersion
+	if err := json.Unmarshal([]byte(version), &parsed); err != nil {
+		return nil, fmt.Errorf("invalid ICS-27 channel version %q: %w", version, err)
+	}
+	if parsed.Version != "ics27-1" {
+		return nil, fmt.Errorf("not an ICS-27 channel version: %q", version)
+	}
+	return &parsed, nil
+}
+
+// Returns the ICS-27 controller channels in channels. These are the
+// channels bound to an `icacontroller-` port whose version is ICS-27
+// metadata, which tells them apart from transfer and CCV channels.
+func icaControllerChannels(channels []ibc.ChannelOutput) []ibc.ChannelOutput {
+	var found []ibc.ChannelOutput
+	for _, channel := range channels {
+		if !strings.HasPrefix(channel.PortID, "icacontroller-") {
+			continue
+		}
+		if _, err := parseICAChannelVersion(channel.Version); err != nil {
+			continue
+		}
+		found = append(found, channel)
+	}
+	return found
+}
+
+// Finds the interchain account channel on chainID bound to portID.
+// Returns an error if there is none, which is the case until the
+// channel handshake starts.
+func FindICAChannel(ctx context.Context, r ibc.Relayer, eRep *testreporter.RelayerExecReporter, chainID, portID string) (*ibc.ChannelOutput, error) {
+	channels, err := ListChannels(ctx, r, eRep, chainID)
+	if err != nil {
+		return nil, err
+	}
+	for _, channel := range icaControllerChannels(channels) {
+		if channel.PortID == portID {
+			return &channel, nil
+		}
+	}
+	return nil, fmt.Errorf("no ICA channel on port %s on %s", portID, chainID)
+}
+
+// The port the host chain's interchain accounts module binds, which
+// every controller channel should be opened to.
+const icaHostPort = "icahost"
+
+// Returned by `AssertICACounterpartyPort` while the channel handshake
+// is still underway, so the host has not yet opened its end.
+var errICAHandshakePending = errors.New("ICA channel handshake pending")
+
+// Checks that the interchain account channel on chainID bound to
+// portID, a controller port, is opened to the host's interchain
+// accounts module. Returns `errICAHandshakePending`, wrapped, until
+// the host has its end of the channel.
+func AssertICACounterpartyPort(ctx context.Context, r ibc.Relayer, eRep *testreporter.RelayerExecReporter, chainID, portID string) error {
+	channel, err := FindICAChannel(ctx, r, eRep, chainID, portID)
+	if err != nil {
+		return err
+	}
+	return checkICACounterpartyPort(channel)
+}
+
+func checkICACounterpartyPort(channel *ibc.ChannelOutput) error {
+	if channel.Counterparty.ChannelID == "" {
+		return fmt.Errorf("%s/%s is %s with no counterparty channel: %w", channel.PortID, channel.ChannelID, channel.State, errICAHandshakePending)
+	}
+	if channel.Counterparty.PortID != icaHostPort {
+		return fmt.Errorf("%s/%s is opened to port %s, not %s", channel.PortID, channel.ChannelID, channel.Counterparty.PortID, icaHostPort)
+	}
+	return nil
+}
+
+// Finds the interchain account channel on chainID bound to portID, as
+// `FindICAChannel` does, and parses the ICS-27 metadata in its
+// version.
+func QueryICAChannelVersion(ctx context.Context, r ibc.Relayer, eRep *testreporter.RelayerExecReporter, chainID, portID string) (*icaChannelVersion, error) {
+	channel, err := FindICAChannel(ctx, r, eRep, chainID, portID)
+	if err != nil {
+		return nil, err
+	}
+	return parseICAChannelVersion(channel.Version)
+}
+
+// Returns the ID the counterparty chain has for connectionID, a
+// connection on chainID.
+func counterpartyConnectionID(ctx context.Context, r ibc.Relayer, eRep *testreporter.RelayerExecReporter, chainID, connectionID string) (string, error) {
+	connections, err := r.GetConnections(ctx, eRep, chainID)
+	if err != nil {
+		return "", err
+	}
+	for _, connection := range connections {
+		if connection.ID == connectionID && connection.Counterparty != nil && connection.Counterparty.ConnectionId != "" {
+			return connection.Counterparty.ConnectionId, nil
+		}
+	}
+	return "", fmt.Errorf("no counterparty for %s on %s", connectionID, chainID)
+}
+
+// Polls until the relayer reports channelID on chainID in state, for
+// example "STATE_CLOSED", or until timeout elapses.
+func WaitForChannelState(ctx context.Context, r ibc.Relayer, eRep *testreporter.RelayerExecReporter, chainID, channelID, state string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var last string
+	for {
+		channels, err := ListChannels(ctx, r, eRep, chainID)
+		if err == nil {
+			for _, channel := range channels {
+				if channel.ChannelID == channelID {
+					last = channel.State
+				}
+			}
+			if last == state {
+				return nil
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("timed out after %s waiting for channel %s on %s to be %s, last state: %q", timeout, channelID, chainID, state, last)
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+// Polls until the relayer reports an open, ordered, interchain
+// account channel on chainID over connectionID, and returns its ID,
+// or until timeout elapses. If there are several, the first the
+// relayer reports is returned, so callers with more than one account
+// on a connection should use `FindICAChannel` instead.
+func WaitForICAChannel(ctx context.Context, r ibc.Relayer, eRep *testreporter.RelayerExecReporter, chainID, connectionID string, timeout time.Duration) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var lastErr error
+	for {
+		channels, err := ListChannels(ctx, r, eRep, chainID)
+		if err == nil {
+			for _, channel := range icaControllerChannels(channels) {
+				if channel.State == "STATE_OPEN" && channel.Ordering == "ORDER_ORDERED" &&
+					len(channel.ConnectionHops) > 0 && channel.ConnectionHops[0] == connectionID {
+					return channel.ChannelID, nil
+				}
+			}
+		} else {
+			lastErr = err
+		}
+
+		select {
+		case <-ctx.Done():
+			if lastErr != nil {
+				return "", fmt.Errorf("timed out after %s waiting for an ICA channel on %s on %s: %w", timeout, connectionID, chainID, lastErr)
+			}
+			return "", fmt.Errorf("timed out after %s waiting for an ICA channel on %s on %s", timeout, connectionID, chainID)
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+// Counts the channels on chainID that the relayer reports as open.
+func openChannelCount(ctx context.Context, r ibc.Relayer, eRep *testreporter.RelayerExecReporter, chainID string) (int, error) {
+	channels, err := ListChannels(ctx, r, eRep, chainID)
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, channel := range channels {
+		if channel.State == "STATE_OPEN" {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// Blocks until the relayer reports at least count open channels on
+// chainID, or until timeout elapses. As ICA opens a channel per
+// account, this is a way to wait for a registration's channel
+// handshake to finish without guessing at how many blocks it takes.
+// Channels that other tests or setup open meanwhile only make the
+// wait end sooner, never hang it.
+func WaitForChannelCount(ctx context.Context, r ibc.Relayer, eRep *testreporter.RelayerExecReporter, chainID string, count int, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var lastCount int
+	var lastErr error
+	for {
+		open, err := openChannelCount(ctx, r, eRep, chainID)
+		if err == nil && open >= count {
+			return nil
+		}
+		if err != nil {
+			lastErr = err
+		} else {
+			lastCount = open
+		}
+
+		select {
+		case <-ctx.Done():
+			if lastErr != nil {
+				return fmt.Errorf("timed out after %s waiting for %d or more open channels on %s (last saw %d): %w", timeout, count, chainID, lastCount, lastErr)
+			}
+			return fmt.Errorf("timed out after %s waiting for %d or more open channels on %s (last saw %d)", timeout, count, chainID, lastCount)
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+// Stops relaying path, and keeps relaying the rest of paths, which
+// are the paths the relayer is running on. The relayer can only be
+// stopped as a whole, so this restarts it without path. Returns the
+// paths still relayed, to pass to `ResumeRelayerPath`.
+//
+// Pausing the path that an interchain account's connection is on,
+// but not the ICS path, stops the account's packets while Neutron
+// keeps receiving validator set changes.
+func PauseRelayerPath(ctx context.Context, r ibc.Relayer, eRep *testreporter.RelayerExecReporter, paths []string, path string) ([]string, error) {
+	remaining := make([]string, 0, len(paths))
+	for _, p := range paths {
+		if p != path {
+			remaining = append(remaining, p)
+		}
+	}
+	if len(remaining) == len(paths) {
+		return nil, fmt.Errorf("path %s is not being relayed", path)
+	}
+	if err := r.StopRelayer(ctx, eRep); err != nil {
+		return nil, fmt.Errorf("failed to stop relayer: %w", err)
+	}
+	if len(remaining) == 0 {
+		return remaining, nil
+	}
+	if err := r.StartRelayer(ctx, eRep, remaining...); err != nil {
+		return nil, fmt.Errorf("failed to restart relayer on %v: %w", remaining, err)
+	}
+	return remaining, nil
+}
+
+// Starts relaying path again after `PauseRelayerPath`, along with
+// paths, which are the paths the relayer is running on. Returns the
+// paths now relayed.
+func ResumeRelayerPath(ctx context.Context, r ibc.Relayer, eRep *testreporter.RelayerExecReporter, paths []string, path string) ([]string, error) {
+	for _, p := range paths {
+		if p == path {
+			return nil, fmt.Errorf("path %s is already being relayed", path)
+		}
+	}
+	if len(paths) > 0 {
+		if err := r.StopRelayer(ctx, eRep); err != nil {
+			return nil, fmt.Errorf("failed to stop relayer: %w", err)
+		}
+	}
+	resumed := append(append([]string{}, paths...), path)
+	if err := r.StartRelayer(ctx, eRep, resumed...); err != nil {
+		return nil, fmt.Errorf("failed to restart relayer on %v: %w", resumed, err)
+	}
+	return resumed, nil
+}
+
+// Runs the rly command args in the relayer's image, against its home
+// directory, and returns what it printed. Only the built-in Docker
+// relayer has a home directory to point rly at.
+func rlyExec(ctx context.Context, r ibc.Relayer, eRep *testreporter.RelayerExecReporter, args ...string) ([]byte, error) {
+	home, ok := r.(interface{ HomeDir() string })
+	if !ok {
+		return nil, fmt.Errorf("relayer %T has no home directory to run rly against", r)
+	}
+	cmd := append(append([]string{"rly"}, args...), "--home", home.HomeDir())
+	res := r.Exec(ctx, eRep, cmd, nil)
+	if res.Err != nil {
+		return nil, fmt.Errorf("failed to run rly %s: %w", strings.Join(args, " "), res.Err)
+	}
+	if res.ExitCode != 0 {
+		return nil, fmt.Errorf("rly %s exited with %d: %s", strings.Join(args, " "), res.ExitCode, strings.TrimSpace(string(res.Stderr)))
+	}
+	return res.Stdout, nil
+}
+
+// One end of a path as `rly paths list --json` prints it.
+type rlyPathEnd struct {
+	ChainID      string `json:"chain-id"`
+	ClientID     string `json:"client-id"`
+	ConnectionID string `json:"connection-id"`
+}
+
+type rlyPath struct {
+	Src rlyPathEnd `json:"src"`
+	Dst rlyPathEnd `json:"dst"`
+}
+
+// Returns the paths the relayer has configured, by name.
+func relayerPaths(ctx context.Context, r ibc.Relayer, eRep *testreporter.RelayerExecReporter) (map[string]*rlyPath, error) {
+	stdout, err := rlyExec(ctx, r, eRep, "paths", "list", "--json")
+	if err != nil {
+		return nil, err
+	}
+	var paths map[string]*rlyPath
+	if err := json.Unmarshal(stdout, &paths); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal relayer paths: %w", err)
+	}
+	return paths, nil
+}
+
+// Finds the relayer's path over connectionID on chainID. Returns the
+// path's name along with its ends.
+func findRelayerPath(ctx context.Context, r ibc.Relayer, eRep *testreporter.RelayerExecReporter, chainID, connectionID string) (string, *rlyPath, error) {
+	paths, err := relayerPaths(ctx, r, eRep)
+	if err != nil {
+		return "", nil, err
+	}
+	// Map order is random, so pick the first path by name for
+	// the same answer every time.
+	names := make([]string, 0, len(paths))
+	for name := range paths {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		path := paths[name]
+		if (path.Src.ChainID == chainID && path.Src.ConnectionID == connectionID) ||
+			(path.Dst.ChainID == chainID && path.Dst.ConnectionID == connectionID) {
+			return name, path, nil
+		}
+	}
+	return "", nil, fmt.Errorf("the relayer has no path over %s on %s", connectionID, chainID)
+}
+
+// Sequences that rly's `unrelayed-packets` and
+// `unrelayed-acknowledgements` queries report as not yet relayed,
+// from each end of a path.
+type unrelayedSequences struct {
+	Src []uint64 `json:"src"`
+	Dst []uint64 `json:"dst"`
+}
+
+// Describes the packets and acknowledgements on path that have not
+// been relayed, or returns nil if there are none. channelID is the
+// channel on the path's source.
+func pendingPacketsError(path *rlyPath, channelID string, packets, acks unrelayedSequences) error {
+	var pending []string
+	describe := func(sequences []uint64, what, chainID string) {
+		if len(sequences) > 0 {
+			pending = append(pending, fmt.Sprintf("%s %v on %s", what, sequences, chainID))
+		}
+	}
+	describe(packets.Src, "packets not received", path.Src.ChainID)
+	describe(packets.Dst, "packets not received", path.Dst.ChainID)
+	describe(acks.Src, "acknowledgements not relayed", path.Src.ChainID)
+	describe(acks.Dst, "acknowledgements not relayed", path.Dst.ChainID)
+	if len(pending) == 0 {
+		return nil
+	}
+	return fmt.Errorf("channel %s on %s has pending %s", channelID, path.Src.ChainID, strings.Join(pending, ", "))
+}
+
+// Checks that the relayer reports nothing left to relay on channelID,
+// a channel on chainID: every packet sent either way has been
+// received, and every acknowledgement written has been relayed back,
+// so neither chain holds a commitment for it any more. Use after an
+// acknowledgement to catch relayers that leave packets behind.
+func AssertNoUnreceivedPackets(ctx context.Context, r ibc.Relayer, eRep *testreporter.RelayerExecReporter, chainID, channelID string) error {
+	channels, err := ListChannels(ctx, r, eRep, chainID)
+	if err != nil {
+		return err
+	}
+	var channel *ibc.ChannelOutput
+	for i := range channels {
+		if channels[i].ChannelID == channelID {
+			channel = &channels[i]
+		}
+	}
+	if channel == nil || len(channel.ConnectionHops) == 0 {
+		return fmt.Errorf("no channel %s with a connection on %s", channelID, chainID)
+	}
+	name, path, err := findRelayerPath(ctx, r, eRep, chainID, channel.ConnectionHops[0])
+	if err != nil {
+		return err
+	}
+	// rly takes the channel on the path's source.
+	srcChannelID := channelID
+	if path.Src.ChainID != chainID {
+		srcChannelID = channel.Counterparty.ChannelID
+	}
+
+	var packets, acks unrelayedSequences
+	stdout, err := rlyExec(ctx, r, eRep, "query", "unrelayed-packets", name, srcChannelID)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(stdout, &packets); err != nil {
+		return fmt.Errorf("failed to unmarshal unrelayed packets: %w", err)
+	}
+	stdout, err = rlyExec(ctx, r, eRep, "query", "unrelayed-acknowledgements", name, srcChannelID)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(stdout, &acks); err != nil {
+		return fmt.Errorf("failed to unmarshal unrelayed acknowledgements: %w", err)
+	}
+	return pendingPacketsError(path, srcChannelID, packets, acks)
+}
+
+// Configures r, a newly built relayer, to take over paths from from:
+// r gets the same chains, the same keys, so that it pays fees from
+// the wallets already funded, and the same paths over the same
+// clients and connections. This is what an operator upgrading their
+// relayer keeps, so from should be stopped first.
+func ReplicateRelayer(ctx context.Context, r, from ibc.Relayer, eRep *testreporter.RelayerExecReporter, chains []*cosmos.CosmosChain, paths ...string) error {
+	for _, chain := range chains {
+		config := chain.Config()
+		wallet, ok := from.GetWallet(config.ChainID)
+		if !ok {
+			return fmt.Errorf("the relayer has no wallet on %s", config.ChainID)
+		}
+		if err := r.AddChainConfiguration(ctx, eRep, config, config.Name, chain.GetRPCAddress(), chain.GetGRPCAddress()); err != nil {
+			return fmt.Errorf("failed to configure relayer for %s: %w", config.ChainID, err)
+		}
+		if err := r.RestoreKey(ctx, eRep, config.ChainID, config.Name, config.CoinType, wallet.Mnemonic); err != nil {
+			return fmt.Errorf("failed to restore relayer key on %s: %w", config.ChainID, err)
+		}
+	}
+
+	configured, err := relayerPaths(ctx, from, eRep)
+	if err != nil {
+		return err
+	}
+	for _, name := range paths {
+		path, ok := configured[name]
+		if !ok {
+			return fmt.Errorf("the relayer has no path %s", name)
+		}
+		if err := r.GeneratePath(ctx, eRep, path.Src.ChainID, path.Dst.ChainID, name); err != nil {
+			return fmt.Errorf("failed to generate path %s: %w", name, err)
+		}
+		if err := r.UpdatePath(ctx, eRep, name, ibc.PathUpdateOptions{
+			SrcClientID: &path.Src.ClientID,
+			SrcConnID:   &path.Src.ConnectionID,
+			DstClientID: &path.Dst.ClientID,
+			DstConnID:   &path.Dst.ConnectionID,
+		}); err != nil {
+			return fmt.Errorf("failed to update path %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// A relayer that records which paths it is started on. Any other
+// method panics, as the embedded interface is nil.
+type recordingRelayer struct {
+	ibc.Relayer
+	running []string
+	stops   int
+}
+
+func (r *recordingRelayer) StartRelayer(ctx context.Context, rep ibc.RelayerExecReporter, pathNames ...string) error {
+	r.running = pathNames
+	return nil
+}
+
+func (r *recordingRelayer) StopRelayer(ctx context.Context, rep ibc.RelayerExecReporter) error {
+	r.running = nil
+	r.stops++
+	return nil
+}
+
+// A relayer that always reports the same channels. Any other method
+// panics, as the embedded interface is nil.
+type fixedChannelsRelayer struct {
+	ibc.Relayer
+	channels []ibc.ChannelOutput
+}
+
+func (r fixedChannelsRelayer) GetChannels(ctx context.Context, rep ibc.RelayerExecReporter, chainID string) ([]ibc.ChannelOutput, error) {
+	return r.channels, nil
+}
+
+func TestWaitForChannelCount(t *testing.T) {
+	ctx := context.Background()
+	eRep := testreporter.NewNopReporter().RelayerExecReporter(t)
+	r := fixedChannelsRelayer{channels: []ibc.ChannelOutput{
+		{State: "STATE_OPEN", PortID: "transfer", ChannelID: "channel-0"},
+		{State: "STATE_INIT", PortID: "icacontroller-test", ChannelID: "channel-1"},
+	}}
+
+	err := WaitForChannelCount(ctx, r, eRep, "neutron-2", 1, time.Second)
+	require.NoError(t, err, "channels that are not open should not be counted")
+	err = WaitForChannelCount(ctx, r, eRep, "neutron-2", 0, time.Second)
+	require.NoError(t, err, "more open channels than asked for should do")
+
+	// The channel in INIT never opens, so this should give up.
+	err = WaitForChannelCount(ctx, r, eRep, "neutron-2", 2, 3*time.Second)
+	require.ErrorContains(t, err, "timed out")
+	require.ErrorContains(t, err, "last saw 1")
+}
+
+func TestICAControllerChannels(t *testing.T) {
+	icaVersion := `{"version":"ics27-1","controller_connection_id":"connection-1","host_connection_id":"connection-1","address":"cosmos1hfxm6slsnrhfmcap6q66zl0uwaq8fy3t6xqfmfhfmp6eaupaphnq8yggam","encoding":"proto3","tx_type":"sdk_multi_msg"}`
+	channels := []ibc.ChannelOutput{
+		{State: "STATE_OPEN", Ordering: "ORDER_ORDERED", PortID: "consumer", ChannelID: "channel-0", Version: "1"},
+		{State: "STATE_OPEN", Ordering: "ORDER_UNORDERED", PortID: "transfer", ChannelID: "channel-1", Version: "ics20-1"},
+		{State: "STATE_OPEN", Ordering: "ORDER_ORDERED", PortID: "icacontroller-neutron1contract.test", ChannelID: "channel-2", Version: icaVersion},
+		// Not ICS-27, despite the port.
+		{State: "STATE_OPEN", Ordering: "ORDER_ORDERED", PortID: "icacontroller-neutron1contract.other", ChannelID: "channel-3", Version: "ics20-1"},
+	}
+
+	found := icaControllerChannels(channels)
+	require.Len(t, found, 1)
+	require.Equal(t, "channel-2", found[0].ChannelID)
+
+	ctx := context.Background()
+	eRep := testreporter.NewNopReporter().RelayerExecReporter(t)
+	r := fixedChannelsRelayer{channels: channels}
+	channel, err := FindICAChannel(ctx, r, eRep, "neutron-2", "icacontroller-neutron1contract.test")
+	require.NoError(t, err)
+	require.Equal(t, "channel-2", channel.ChannelID)
+
+	_, err = FindICAChannel(ctx, r, eRep, "neutron-2", "icacontroller-neutron1contract.other")
+	require.Error(t, err)
+}
+
+func TestAssertICACounterpartyPort(t *testing.T) {
+	icaVersion := `{"version":"ics27-1","controller_connection_id":"connection-1","host_connection_id":"connection-1","address":"","encoding":"proto3","tx_type":"sdk_multi_msg"}`
+	channel := func(port, state string, counterparty ibc.ChannelCounterparty) ibc.ChannelOutput {
+		return ibc.ChannelOutput{State: state, Ordering: "ORDER_ORDERED", PortID: port, ChannelID: "channel-2", Version: icaVersion, Counterparty: counterparty}
+	}
+	r := fixedChannelsRelayer{channels: []ibc.ChannelOutput{
+		channel("icacontroller-neutron1contract.open", "STATE_OPEN", ibc.ChannelCounterparty{PortID: "icahost", ChannelID: "channel-1"}),
+		channel("icacontroller-neutron1contract.pending", "STATE_INIT", ibc.ChannelCounterparty{PortID: "icahost"}),
+		channel("icacontroller-neutron1contract.wrong", "STATE_OPEN", ibc.ChannelCounterparty{PortID: "transfer", ChannelID: "channel-1"}),
+	}}
+	ctx := context.Background()
+	eRep := testreporter.NewNopReporter().RelayerExecReporter(t)
+
+	require.NoError(t, AssertICACounterpartyPort(ctx, r, eRep, "neutron-2", "icacontroller-neutron1contract.open"))
+
+	err := AssertICACounterpartyPort(ctx, r, eRep, "neutron-2", "icacontroller-neutron1contract.pending")
+	require.ErrorIs(t, err, errICAHandshakePending)
+	require.ErrorContains(t, err, "STATE_INIT")
+
+	err = AssertICACounterpartyPort(ctx, r, eRep, "neutron-2", "icacontroller-neutron1contract.wrong")
+	require.ErrorContains(t, err, "opened to port transfer, not icahost")
+	require.NotErrorIs(t, err, errICAHandshakePending)
+
+	require.Error(t, AssertICACounterpartyPort(ctx, r, eRep, "neutron-2", "icacontroller-neutron1contract.missing"))
+}
+
+func TestParseICAChannelVersion(t *testing.T) {
+	version, err := parseICAChannelVersion(`{"version":"ics27-1","controller_connection_id":"connection-1","host_connection_id":"connection-2","address":"cosmos1hfxm6slsnrhfmcap6q66zl0uwaq8fy3t6xqfmfhfmp6eaupaphnq8yggam","encoding":"proto3","tx_type":"sdk_multi_msg"}`)
+	require.NoError(t, err)
+	require.Equal(t, icaChannelVersion{
+		Version:                "ics27-1",
+		ControllerConnectionId: "connection-1",
+		HostConnectionId:       "connection-2",
+		Address:                "cosmos1hfxm6slsnrhfmcap6q66zl0uwaq8fy3t6xqfmfhfmp6eaupaphnq8yggam",
+		Encoding:               "proto3",
+		TxType:                 "sdk_multi_msg",
+	}, *version)
+
+	// Only the encoding differs for proto3json channels.
+	version, err = parseICAChannelVersion(`{"version":"ics27-1","controller_connection_id":"connection-1","host_connection_id":"connection-2","address":"","encoding":"proto3json","tx_type":"sdk_multi_msg"}`)
+	require.NoError(t, err)
+	require.Equal(t, "proto3json", version.Encoding)
+	require.Equal(t, "connection-2", version.HostConnectionId)
+
+	_, err = parseICAChannelVersion("ics20-1")
+	require.Error(t, err)
+	_, err = parseICAChannelVersion(`{"version":"ics29-1"}`)
+	require.Error(t, err)
+}
+
+func TestWaitForICAChannel(t *testing.T) {
+	icaVersion := `{"version":"ics27-1","controller_connection_id":"connection-1","host_connection_id":"connection-1","address":"","encoding":"proto3","tx_type":"sdk_multi_msg"}`
+	ctx := context.Background()
+	eRep := testreporter.NewNopReporter().RelayerExecReporter(t)
+	r := fixedChannelsRelayer{channels: []ibc.ChannelOutput{
+		{State: "STATE_OPEN", Ordering: "ORDER_ORDERED", PortID: "icacontroller-neutron1contract.a", ChannelID: "channel-1", Version: icaVersion, ConnectionHops: []string{"connection-0"}},
+		{State: "STATE_OPEN", Ordering: "ORDER_ORDERED", PortID: "icacontroller-neutron1contract.b", ChannelID: "channel-2", Version: icaVersion, ConnectionHops: []string{"connection-1"}},
+	}}
+
+	channelID, err := WaitForICAChannel(ctx, r, eRep, "neutron-2", "connection-1", time.Second)
+	require.NoError(t, err)
+	require.Equal(t, "channel-2", channelID)
+
+	// A channel still in its handshake never opens here.
+	r.channels[1].State = "STATE_TRYOPEN"
+	_, err = WaitForICAChannel(ctx, r, eRep, "neutron-2", "connection-1", 3*time.Second)
+	require.ErrorContains(t, err, "timed out")
+	require.ErrorContains(t, err, "connection-1")
+}
+
+func TestRelayerTopUps(t *testing.T) {
+	balances := map[string]int64{
+		"cosmoshub-4": minRelayerBalance * 10,
+		"neutron-2":   250_000,
+		"broke-1":     0,
+	}
+	require.Equal(t, map[string]int64{
+		"neutron-2": minRelayerBalance - 250_000,
+		"broke-1":   minRelayerBalance,
+	}, relayerTopUps(balances, minRelayerBalance))
+
+	require.Empty(t, relayerTopUps(map[string]int64{"neutron-2": minRelayerBalance}, minRelayerBalance))
+}
+
+func TestTransferChannel(t *testing.T) {
+	ctx := context.Background()
+	eRep := testreporter.NewNopReporter().RelayerExecReporter(t)
+	r := fixedChannelsRelayer{channels: []ibc.ChannelOutput{
+		{State: "STATE_OPEN", PortID: ccvConsumerPort, ChannelID: "channel-0", ConnectionHops: []string{"connection-0"}},
+		// The consumer's reward channel, on the ICS connection.
+		{State: "STATE_OPEN", PortID: "transfer", ChannelID: "channel-1", ConnectionHops: []string{"connection-0"}},
+		{State: "STATE_OPEN", PortID: "transfer", ChannelID: "channel-2", ConnectionHops: []string{"connection-1"}},
+	}}
+
+	channel, err := transferChannel(ctx, r, eRep, "neutron-2")
+	require.NoError(t, err)
+	require.Equal(t, "channel-2", channel.ChannelID)
+
+	r.channels = r.channels[:2]
+	_, err = transferChannel(ctx, r, eRep, "neutron-2")
+	require.Error(t, err, "the reward channel is not the transfer path")
+}
+
+func TestPauseRelayerPath(t *testing.T) {
+	ctx := context.Background()
+	eRep := testreporter.NewNopReporter().RelayerExecReporter(t)
+	r := &recordingRelayer{running: []string{icsPath, ibcPath}}
+
+	paused, err := PauseRelayerPath(ctx, r, eRep, r.running, ibcPath)
+	require.NoError(t, err)
+	require.Equal(t, []string{icsPath}, paused)
+	require.Equal(t, []string{icsPath}, r.running, "the ICS path should keep being relayed")
+
+	_, err = PauseRelayerPath(ctx, r, eRep, paused, ibcPath)
+	require.Error(t, err, "a paused path can not be paused again")
+	_, err = ResumeRelayerPath(ctx, r, eRep, paused, icsPath)
+	require.Error(t, err, "a running path can not be resumed")
+
+	resumed, err := ResumeRelayerPath(ctx, r, eRep, paused, ibcPath)
+	require.NoError(t, err)
+	require.Equal(t, []string{icsPath, ibcPath}, resumed)
+	require.Equal(t, resumed, r.running)
+
+	// Pausing the only path leaves the relayer stopped, and resuming
+	// it has nothing to stop first.
+	r = &recordingRelayer{running: []string{icsPath}}
+	paused, err = PauseRelayerPath(ctx, r, eRep, r.running, icsPath)
+	require.NoError(t, err)
+	require.Empty(t, paused)
+	require.Empty(t, r.running)
+	_, err = ResumeRelayerPath(ctx, r, eRep, paused, icsPath)
+	require.NoError(t, err)
+	require.Equal(t, []string{icsPath}, r.running)
+	require.Equal(t, 1, r.stops)
+}
+
+func TestPendingPacketsError(t *testing.T) {
+	path := &rlyPath{
+		Src: rlyPathEnd{ChainID: "neutron-2", ConnectionID: "connection-0"},
+		Dst: rlyPathEnd{ChainID: "gaia-1", ConnectionID: "connection-0"},
+	}
+	require.NoError(t, pendingPacketsError(path, "channel-2", unrelayedSequences{}, unrelayedSequences{Src: []uint64{}}))
+
+	err := pendingPacketsError(path, "channel-2", unrelayedSequences{Src: []uint64{3, 4}}, unrelayedSequences{Dst: []uint64{2}})
+	require.EqualError(t, err, "channel channel-2 on neutron-2 has pending packets not received [3 4] on neutron-2, acknowledgements not relayed [2] on gaia-1")
+}
+
+// A relayer that answers `rly paths list` with paths. Any other
+// method panics, as the embedded interface is nil.
+type fixedPathsRelayer struct {
+	ibc.Relayer
+	paths string
+	cmd   []string
+}
+
+func (r *fixedPathsRelayer) HomeDir() string {
+	return "/home/relayer"
+}
+
+func (r *fixedPathsRelayer) Exec(ctx context.Context, rep ibc.RelayerExecReporter, cmd []string, env []string) ibc.RelayerExecResult {
+	r.cmd = cmd
+	return ibc.RelayerExecResult{Stdout: []byte(r.paths)}
+}
+
+func TestFindRelayerPath(t *testing.T) {
+	ctx := context.Background()
+	eRep := testreporter.NewNopReporter().RelayerExecReporter(t)
+	r := &fixedPathsRelayer{paths: `{
+		"ibc-path": {"src": {"chain-id": "gaia-1", "client-id": "07-tendermint-1", "connection-id": "connection-1"}, "dst": {"chain-id": "neutron-2", "client-id": "07-tendermint-1", "connection-id": "connection-1"}, "src-channel-filter": {"rule": "", "channel-list": []}},
+		"ics-path": {"src": {"chain-id": "gaia-1", "client-id": "07-tendermint-0", "connection-id": "connection-0"}, "dst": {"chain-id": "neutron-2", "client-id": "07-tendermint-0", "connection-id": "connection-0"}, "src-channel-filter": {"rule": "", "channel-list": []}}
+	}`}
+
+	name, path, err := findRelayerPath(ctx, r, eRep, "neutron-2", "connection-1")
+	require.NoError(t, err)
+	require.Equal(t, "ibc-path", name)
+	require.Equal(t, "gaia-1", path.Src.ChainID)
+	require.Equal(t, []string{"rly", "paths", "list", "--json", "--home", "/home/relayer"}, r.cmd)
+
+	_, _, err = findRelayerPath(ctx, r, eRep, "neutron-2", "connection-2")
+	require.ErrorContains(t, err, "no path over connection-2")
+}
@@ -0,0 +1,100 @@
+package ibc_test
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/strangelove-ventures/interchaintest/v3/testreporter"
+	"github.com/stretchr/testify/require"
+)
+
+// Matches a line the relayer logged at error level, in either its
+// console or JSON log format.
+var relayerErrorLine = regexp.MustCompile(`\terror\t|"level":"error"`)
+
+// Relayer errors that don't mean anything is broken. The relayer
+// retries all of these.
+var benignRelayerErrors = []*regexp.Regexp{
+	// Two transactions from the relayer's wallet raced, and the
+	// relayer resubmits with the right sequence.
+	regexp.MustCompile(`account sequence mismatch`),
+	// A chain had not produced the block the relayer asked about
+	// yet.
+	regexp.MustCompile(`must be less than or equal to the current blockchain height`),
+}
+
+// Reads the reporter messages in r, and returns each error a relayer
+// command reported that doesn't match any of allow. A command that
+// failed is an error, as is any line it logged at error level.
+func relayerErrors(r io.Reader, allow []*regexp.Regexp) ([]string, error) {
+	var reported []string
+	report := func(command []string, message string) {
+		for _, pattern := range allow {
+			if pattern.MatchString(message) {
+				return
+			}
+		}
+		reported = append(reported, fmt.Sprintf("%s: %s", strings.Join(command, " "), message))
+	}
+
+	scanner := bufio.NewScanner(r)
+	// Relayer output is written on one line, and can be long.
+	scanner.Buffer(nil, 16*1024*1024)
+	for scanner.Scan() {
+		var wrapped testreporter.WrappedMessage
+		if err := wrapped.UnmarshalJSON(scanner.Bytes()); err != nil {
+			return nil, fmt.Errorf("failed to parse reporter message: %w", err)
+		}
+		exec, ok := wrapped.Message.(testreporter.RelayerExecMessage)
+		if !ok {
+			continue
+		}
+		if exec.Error != "" {
+			report(exec.Command, exec.Error)
+		}
+		for _, line := range strings.Split(exec.Stderr, "\n") {
+			if relayerErrorLine.MatchString(line) {
+				report(exec.Command, line)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read reporter messages: %w", err)
+	}
+	return reported, nil
+}
+
+// Fails t if the relayer commands recorded in the reporter log at
+// logPath reported any errors other than `benignRelayerErrors`. The
+// reporter writes messages asynchronously, so it must be closed
+// before calling this.
+func AssertNoRelayerErrors(t *testing.T, logPath string) {
+	t.Helper()
+	f, err := os.Open(logPath)
+	require.NoError(t, err, "failed to open reporter log")
+	defer f.Close()
+	reported, err := relayerErrors(f, benignRelayerErrors)
+	require.NoError(t, err)
+	require.Empty(t, reported, "relayer reported errors")
+}
+
+func TestRelayerErrors(t *testing.T) {
+	f, err := os.Open("testdata/reporter.json")
+	require.NoError(t, err)
+	defer f.Close()
+
+	reported, err := relayerErrors(f, benignRelayerErrors)
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		"rly tx connect ibc-path: exit code 1: Error: failed to create clients",
+		"rly tx connect ibc-path: 2023-06-01T10:00:02.000000Z\terror\tFailed to send messages\t{\"error\": \"out of gas\"}",
+	}, reported)
+
+	_, err = relayerErrors(strings.NewReader("not json\n"), nil)
+	require.Error(t, err)
+}
@@ -0,0 +1,77 @@
+package ibc_test
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	ibctest "github.com/strangelove-ventures/interchaintest/v3"
+	"github.com/strangelove-ventures/interchaintest/v3/chain/cosmos"
+	"github.com/strangelove-ventures/interchaintest/v3/ibc"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+)
+
+// Tests that the contract's record of an interchain account survives
+// restarting Neutron.
+func TestICASurvivesRestart(t *testing.T) {
+	env := setupICSTest(t)
+	ctx, neutron := env.ctx, env.neutron
+
+	contract := deployICAContract(t, env)
+	address := registerICA(t, env, contract, "test")
+
+	err := env.relayer.StopRelayer(ctx, env.eRep)
+	require.NoError(t, err, "failed to stop relayer")
+
+	err = RestartChain(ctx, neutron)
+	require.NoError(t, err, "failed to restart neutron")
+
+	err = env.relayer.StartRelayer(ctx, env.eRep, icsPath, ibcPath)
+	require.NoError(t, err, "failed to restart relayer")
+
+	restarted, err := QueryICAAddressFromContract(ctx, neutron, contract, "test")
+	require.NoError(t, err, "failed to query ICA account address after restart")
+	require.Equal(t, address, restarted, "the ICA should survive a restart")
+}
+
+// Set to a relayer version, such as v2.4.0, for
+// `TestICASurvivesRelayerUpgrade` to upgrade to. The test is skipped
+// if this is unset.
+const relayerUpgradeVersionEnv = "RELAYER_UPGRADE_VERSION"
+
+// Tests that an interchain account keeps working when the relayer is
+// upgraded: the account is registered with `relayerImage`, then that
+// relayer is replaced by one at RELAYER_UPGRADE_VERSION that takes
+// over its paths, which must relay a send through the account.
+func TestICASurvivesRelayerUpgrade(t *testing.T) {
+	version := os.Getenv(relayerUpgradeVersionEnv)
+	if version == "" {
+		t.Skipf("skipping as %s is unset", relayerUpgradeVersionEnv)
+	}
+	env := setupICSTest(t)
+	ctx, atom, neutron, eRep := env.ctx, env.atom, env.neutron, env.eRep
+
+	contract := deployICAContract(t, env)
+	icaAddress := registerICA(t, env, contract, "test")
+	fundICA(t, env, icaAddress, 1_000_000)
+
+	err := env.relayer.StopRelayer(ctx, eRep)
+	require.NoError(t, err, "failed to stop relayer")
+	upgradedImage := relayerImage
+	upgradedImage.Version = version
+	rf := ibctest.NewBuiltinRelayerFactory(ibc.CosmosRly, zaptest.NewLogger(t), relayerOptions(upgradedImage)...)
+	upgraded := rf.Build(t, env.dockerClient, env.networkID)
+	err = ReplicateRelayer(ctx, upgraded, env.relayer, eRep, []*cosmos.CosmosChain{atom, neutron}, icsPath, ibcPath)
+	require.NoError(t, err, "failed to configure relayer %s", version)
+	err = upgraded.StartRelayer(ctx, eRep, icsPath, ibcPath)
+	require.NoError(t, err, "failed to start relayer %s", version)
+	env.teardown.started("upgraded relayer", upgraded)
+
+	atomUserAddress := env.atomUser.Bech32Address(atom.Config().Bech32Prefix)
+	sequence, err := SubmitICASend(ctx, neutron, env.neutronUser.KeyName, contract, "test", atomUserAddress, 1_000, atom.Config().Denom, 0)
+	require.NoError(t, err, "failed to submit ICA send")
+	result, err := WaitForAcknowledgement(ctx, neutron, contract, "test", sequence, 2*time.Minute)
+	require.NoError(t, err, "relayer %s never relayed the send", version)
+	require.Equal(t, []string{"/cosmos.bank.v1beta1.MsgSend"}, result.Success)
+}
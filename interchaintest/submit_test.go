@@ -0,0 +1,946 @@
+package ibc_test
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+
+	codectypes "github.com/cosmos/cosmos-sdk/codec/types"
+	sdk "github.com/cosmos/cosmos-sdk/types"
+	"github.com/cosmos/cosmos-sdk/x/authz"
+	banktypes "github.com/cosmos/cosmos-sdk/x/bank/types"
+	distrtypes "github.com/cosmos/cosmos-sdk/x/distribution/types"
+	govtypes "github.com/cosmos/cosmos-sdk/x/gov/types"
+	stakingtypes "github.com/cosmos/cosmos-sdk/x/staking/types"
+	"github.com/gogo/protobuf/proto"
+	"github.com/strangelove-ventures/interchaintest/v3/chain/cosmos"
+	"github.com/strangelove-ventures/interchaintest/v3/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+// An execute message for the Neutron example contract. Like
+// `IcaExampleContractQuery`, only one field should be set at a time.
+type IcaExampleContractExecute struct {
+	Register *RegisterMsg `json:"register,omitempty"`
+	SubmitTx *SubmitTxMsg `json:"submit_tx,omitempty"`
+}
+
+// Registers an interchain account with ID InterchainAccountId on
+// ConnectionId. See `RegisterICA`.
+type RegisterMsg struct {
+	ConnectionId        string `json:"connection_id"`
+	InterchainAccountId string `json:"interchain_account_id"`
+}
+
+// Submits msgs to be executed by the interchain account with ID
+// InterchainAccountId. Each message is a `ProtobufAny`, as built by
+// `EncodeICAMessage`. Timeout is in seconds, and the contract defaults
+// it to two weeks when it is nil. Memo is sent to the host in the
+// packet, and is empty when unset.
+type SubmitTxMsg struct {
+	InterchainAccountId string            `json:"interchain_account_id"`
+	Msgs                []json.RawMessage `json:"msgs"`
+	Timeout             *uint64           `json:"timeout,omitempty"`
+	Memo                string            `json:"memo,omitempty"`
+}
+
+// Builds the message `SubmitICATxWithMemo` sends. No msgs are sent as
+// an empty list rather than a null, which the contract would fail to
+// parse before it could reject the submission itself. A timeout of 0
+// is left unset.
+func newSubmitTxMsg(accountId, memo string, timeout uint64, msgs ...json.RawMessage) *SubmitTxMsg {
+	if msgs == nil {
+		msgs = []json.RawMessage{}
+	}
+	submit := &SubmitTxMsg{
+		InterchainAccountId: accountId,
+		Msgs:                msgs,
+		Memo:                memo,
+	}
+	if timeout != 0 {
+		submit.Timeout = &timeout
+	}
+	return submit
+}
+
+// A protobuf `Any` in the form the contract (via neutron-sdk's
+// `ProtobufAny`) expects. Value is serialized as base64, matching
+// CosmWasm's `Binary` type.
+type ProtobufAny struct {
+	TypeUrl string `json:"type_url"`
+	Value   []byte `json:"value"`
+}
+
+// Encodes msg for submission through an interchain account. The
+// result is a JSON `{"type_url": ..., "value": ...}` object, where
+// value is the base64 of the protobuf encoded msg.
+//
+// Protobuf is the only encoding there is a choice of here. ICS-27's
+// proto3json encoding needs ibc-go v8 on both ends, while Neutron v1
+// and the Gaia these tests run are older, so every channel negotiates
+// "proto3" (see `TestICAChannelVersion`) and Neutron wraps msgs in a
+// protobuf `CosmosTx` itself.
+func EncodeICAMessage(typeUrl string, msg proto.Message) (json.RawMessage, error) {
+	value, err := proto.Marshal(msg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode %s: %w", typeUrl, err)
+	}
+	return json.Marshal(ProtobufAny{TypeUrl: typeUrl, Value: value})
+}
+
+// Submits already encoded msgs to be executed by the interchain
+// account with ID accountId, and returns the sequence number of the
+// packet carrying them. This returns once the messages have been
+// sent to the host chain, not once they have executed there; use
+// `WaitForAcknowledgement` with the sequence for that.
+//
+// If the packet is not relayed to the host chain within timeout
+// seconds, it times out and the ICA's channel closes. A timeout of 0
+// uses the contract's default.
+func SubmitICATx(ctx context.Context, chain *cosmos.CosmosChain, keyName, contract, accountId string, timeout uint64, msgs ...json.RawMessage) (uint64, error) {
+	return SubmitICATxWithMemo(ctx, chain, keyName, contract, accountId, "", timeout, msgs...)
+}
+
+// Submits msgs as `SubmitICATx` does, with memo in the packet that
+// carries them. See `QueryHostPacketMemo` for reading it back on the
+// host.
+func SubmitICATxWithMemo(ctx context.Context, chain *cosmos.CosmosChain, keyName, contract, accountId, memo string, timeout uint64, msgs ...json.RawMessage) (uint64, error) {
+	cmd, err := executeContractCommand(chain, keyName, contract, IcaExampleContractExecute{
+		SubmitTx: newSubmitTxMsg(accountId, memo, timeout, msgs...),
+	}, TxOptions{})
+	if err != nil {
+		return 0, err
+	}
+	return ExecuteAndGetSequence(ctx, chain, cmd)
+}
+
+// Sends amount of denom from the interchain account with ID accountId
+// to toAddress on the host chain. timeout is as for `SubmitICATx`.
+func SubmitICASend(ctx context.Context, chain *cosmos.CosmosChain, keyName, contract, accountId, toAddress string, amount int64, denom string, timeout uint64) (uint64, error) {
+	icaAddress, err := QueryICAAddressFromContract(ctx, chain, contract, accountId)
+	if err != nil {
+		return 0, err
+	}
+	msg, err := EncodeICAMessage("/cosmos.bank.v1beta1.MsgSend", &banktypes.MsgSend{
+		FromAddress: icaAddress,
+		ToAddress:   toAddress,
+		Amount:      sdk.NewCoins(sdk.NewInt64Coin(denom, amount)),
+	})
+	if err != nil {
+		return 0, err
+	}
+	return SubmitICATx(ctx, chain, keyName, contract, accountId, timeout, msg)
+}
+
+// Builds a `MsgMultiSend` of input of denom from the address from,
+// split between the addresses in outputs. Outputs are in address
+// order, so that the message is the same every time. The host
+// rejects the message unless input is the sum of outputs.
+func multiSendMsg(from string, input int64, outputs map[string]int64, denom string) *banktypes.MsgMultiSend {
+	addresses := make([]string, 0, len(outputs))
+	for address := range outputs {
+		addresses = append(addresses, address)
+	}
+	sort.Strings(addresses)
+
+	msg := &banktypes.MsgMultiSend{
+		Inputs: []banktypes.Input{{Address: from, Coins: sdk.NewCoins(sdk.NewInt64Coin(denom, input))}},
+	}
+	for _, address := range addresses {
+		msg.Outputs = append(msg.Outputs, banktypes.Output{
+			Address: address,
+			Coins:   sdk.NewCoins(sdk.NewInt64Coin(denom, outputs[address])),
+		})
+	}
+	return msg
+}
+
+// Sends each address in outputs its amount of denom from the
+// interchain account with ID accountId on the host chain, in a single
+// `MsgMultiSend`. timeout is as for `SubmitICATx`.
+func SubmitICAMultiSend(ctx context.Context, chain *cosmos.CosmosChain, keyName, contract, accountId string, outputs map[string]int64, denom string, timeout uint64) (uint64, error) {
+	icaAddress, err := QueryICAAddressFromContract(ctx, chain, contract, accountId)
+	if err != nil {
+		return 0, err
+	}
+	var total int64
+	for _, amount := range outputs {
+		total += amount
+	}
+	msg, err := EncodeICAMessage("/cosmos.bank.v1beta1.MsgMultiSend", multiSendMsg(icaAddress, total, outputs, denom))
+	if err != nil {
+		return 0, err
+	}
+	return SubmitICATx(ctx, chain, keyName, contract, accountId, timeout, msg)
+}
+
+// Delegates amount of denom from the interchain account with ID
+// accountId to validator on the host chain. timeout is as for
+// `SubmitICATx`.
+func SubmitICADelegate(ctx context.Context, chain *cosmos.CosmosChain, keyName, contract, accountId, validator string, amount int64, denom string, timeout uint64) (uint64, error) {
+	icaAddress, err := QueryICAAddressFromContract(ctx, chain, contract, accountId)
+	if err != nil {
+		return 0, err
+	}
+	msg, err := EncodeICAMessage("/cosmos.staking.v1beta1.MsgDelegate", &stakingtypes.MsgDelegate{
+		DelegatorAddress: icaAddress,
+		ValidatorAddress: validator,
+		Amount:           sdk.NewInt64Coin(denom, amount),
+	})
+	if err != nil {
+		return 0, err
+	}
+	return SubmitICATx(ctx, chain, keyName, contract, accountId, timeout, msg)
+}
+
+// Moves amount of denom that the interchain account with ID accountId
+// has delegated to src on the host chain over to dst, without
+// unbonding it. timeout is as for `SubmitICATx`.
+func SubmitICARedelegate(ctx context.Context, chain *cosmos.CosmosChain, keyName, contract, accountId, src, dst string, amount int64, denom string, timeout uint64) (uint64, error) {
+	icaAddress, err := QueryICAAddressFromContract(ctx, chain, contract, accountId)
+	if err != nil {
+		return 0, err
+	}
+	msg, err := EncodeICAMessage("/cosmos.staking.v1beta1.MsgBeginRedelegate", &stakingtypes.MsgBeginRedelegate{
+		DelegatorAddress:    icaAddress,
+		ValidatorSrcAddress: src,
+		ValidatorDstAddress: dst,
+		Amount:              sdk.NewInt64Coin(denom, amount),
+	})
+	if err != nil {
+		return 0, err
+	}
+	return SubmitICATx(ctx, chain, keyName, contract, accountId, timeout, msg)
+}
+
+// Withdraws the staking rewards the interchain account with ID
+// accountId has earned by delegating to validator on the host chain.
+// timeout is as for `SubmitICATx`.
+func SubmitICAWithdrawRewards(ctx context.Context, chain *cosmos.CosmosChain, keyName, contract, accountId, validator string, timeout uint64) (uint64, error) {
+	icaAddress, err := QueryICAAddressFromContract(ctx, chain, contract, accountId)
+	if err != nil {
+		return 0, err
+	}
+	msg, err := EncodeICAMessage("/cosmos.distribution.v1beta1.MsgWithdrawDelegatorReward", &distrtypes.MsgWithdrawDelegatorReward{
+		DelegatorAddress: icaAddress,
+		ValidatorAddress: validator,
+	})
+	if err != nil {
+		return 0, err
+	}
+	return SubmitICATx(ctx, chain, keyName, contract, accountId, timeout, msg)
+}
+
+// Votes option on the host chain governance proposal proposalId from
+// the interchain account with ID accountId. timeout is as for
+// `SubmitICATx`.
+func SubmitICAVote(ctx context.Context, chain *cosmos.CosmosChain, keyName, contract, accountId string, proposalId uint64, option govtypes.VoteOption, timeout uint64) (uint64, error) {
+	icaAddress, err := QueryICAAddressFromContract(ctx, chain, contract, accountId)
+	if err != nil {
+		return 0, err
+	}
+	msg, err := EncodeICAMessage("/cosmos.gov.v1beta1.MsgVote", &govtypes.MsgVote{
+		ProposalId: proposalId,
+		Voter:      icaAddress,
+		Option:     option,
+	})
+	if err != nil {
+		return 0, err
+	}
+	return SubmitICATx(ctx, chain, keyName, contract, accountId, timeout, msg)
+}
+
+// Builds a `MsgGrant` from granter to grantee of a send authorization
+// allowing grantee to spend up to spendLimit of denom of granter's
+// until expiration. Cosmos SDK v0.45 requires every grant to expire;
+// once it has, the host ignores the grant and prunes it the next time
+// grantee tries to use it.
+func sendGrantMsg(granter, grantee string, spendLimit int64, denom string, expiration time.Time) (*authz.MsgGrant, error) {
+	authorization, err := codectypes.NewAnyWithValue(banktypes.NewSendAuthorization(sdk.NewCoins(sdk.NewInt64Coin(denom, spendLimit))))
+	if err != nil {
+		return nil, err
+	}
+	return &authz.MsgGrant{
+		Granter: granter,
+		Grantee: grantee,
+		Grant: authz.Grant{
+			Authorization: authorization,
+			Expiration:    expiration,
+		},
+	}, nil
+}
+
+// Grants grantee the authorization to send up to spendLimit of denom
+// from the interchain account with ID accountId on the host chain,
+// until expiration. See `sendGrantMsg`. timeout is as for
+// `SubmitICATx`.
+func SubmitICAGrantSend(ctx context.Context, chain *cosmos.CosmosChain, keyName, contract, accountId, grantee string, spendLimit int64, denom string, expiration time.Time, timeout uint64) (uint64, error) {
+	icaAddress, err := QueryICAAddressFromContract(ctx, chain, contract, accountId)
+	if err != nil {
+		return 0, err
+	}
+	grant, err := sendGrantMsg(icaAddress, grantee, spendLimit, denom, expiration)
+	if err != nil {
+		return 0, err
+	}
+	msg, err := EncodeICAMessage("/cosmos.authz.v1beta1.MsgGrant", grant)
+	if err != nil {
+		return 0, err
+	}
+	return SubmitICATx(ctx, chain, keyName, contract, accountId, timeout, msg)
+}
+
+func TestEncodeICAMessage(t *testing.T) {
+	send := &banktypes.MsgSend{
+		FromAddress: "cosmos1from",
+		ToAddress:   "cosmos1to",
+		Amount:      sdk.NewCoins(sdk.NewInt64Coin("uatom", 100)),
+	}
+	encoded, err := EncodeICAMessage("/cosmos.bank.v1beta1.MsgSend", send)
+	require.NoError(t, err)
+
+	// Decode as plain JSON so this checks field names and the
+	// base64 encoding of value, rather than round tripping through
+	// the same struct that encoded it.
+	var decoded map[string]string
+	require.NoError(t, json.Unmarshal(encoded, &decoded))
+	require.Len(t, decoded, 2)
+	require.Equal(t, "/cosmos.bank.v1beta1.MsgSend", decoded["type_url"])
+
+	expected, err := proto.Marshal(send)
+	require.NoError(t, err)
+	require.Equal(t, base64.StdEncoding.EncodeToString(expected), decoded["value"])
+
+	var protoAny ProtobufAny
+	require.NoError(t, json.Unmarshal(encoded, &protoAny))
+	var roundTripped banktypes.MsgSend
+	require.NoError(t, proto.Unmarshal(protoAny.Value, &roundTripped))
+	require.Equal(t, *send, roundTripped)
+}
+
+// Tests that submitting through an interchain account returns the
+// sequence of the packet sent, and that the contract records the
+// acknowledgement of that packet under the same sequence.
+func TestSubmitReturnsSequence(t *testing.T) {
+	env := setupICSTest(t)
+	ctx, atom, neutron := env.ctx, env.atom, env.neutron
+
+	contract := deployICAContract(t, env)
+	icaAddress := registerICA(t, env, contract, "test")
+
+	fundICA(t, env, icaAddress, 1_000_000)
+
+	atomUserAddress := env.atomUser.Bech32Address(atom.Config().Bech32Prefix)
+	sequence, err := SubmitICASend(ctx, neutron, env.neutronUser.KeyName, contract, "test", atomUserAddress, 1_000, atom.Config().Denom, 0)
+	require.NoError(t, err, "failed to submit ICA send")
+	require.NotZero(t, sequence)
+
+	result, err := WaitForAcknowledgement(ctx, neutron, contract, "test", sequence, 2*time.Minute)
+	require.NoError(t, err)
+	require.Equal(t, []string{"/cosmos.bank.v1beta1.MsgSend"}, result.Success)
+}
+
+// Tests that once a send has been acknowledged, the relayer reports
+// nothing left to relay on the interchain account's channel.
+func TestNoPacketsPendingAfterAck(t *testing.T) {
+	env := setupICSTest(t)
+	ctx, atom, neutron := env.ctx, env.atom, env.neutron
+
+	contract := deployICAContract(t, env)
+	icaAddress := registerICA(t, env, contract, "test")
+	fundICA(t, env, icaAddress, 1_000_000)
+
+	atomUserAddress := env.atomUser.Bech32Address(atom.Config().Bech32Prefix)
+	sequence, err := SubmitICASend(ctx, neutron, env.neutronUser.KeyName, contract, "test", atomUserAddress, 1_000, atom.Config().Denom, 0)
+	require.NoError(t, err, "failed to submit ICA send")
+	_, err = WaitForAcknowledgement(ctx, neutron, contract, "test", sequence, 2*time.Minute)
+	require.NoError(t, err)
+
+	channel, err := QueryICAChannel(ctx, neutron, contract, "test")
+	require.NoError(t, err)
+	err = AssertNoUnreceivedPackets(ctx, env.relayer, env.eRep, neutron.Config().ChainID, channel.ChannelId)
+	require.NoError(t, err)
+}
+
+// Tests that a memo submitted with a send reaches the host in the
+// packet that carries the send, and that without one the memo is
+// empty.
+func TestSubmitWithMemo(t *testing.T) {
+	env := setupICSTest(t)
+	ctx, atom, neutron := env.ctx, env.atom, env.neutron
+
+	contract := deployICAContract(t, env)
+	icaAddress := registerICA(t, env, contract, "test")
+	fundICA(t, env, icaAddress, 1_000_000)
+	channel, err := QueryICAChannel(ctx, neutron, contract, "test")
+	require.NoError(t, err)
+	icaChannel, err := FindICAChannel(ctx, env.relayer, env.eRep, neutron.Config().ChainID, channel.PortId)
+	require.NoError(t, err)
+	hostChannelId := icaChannel.Counterparty.ChannelID
+
+	atomUserAddress := env.atomUser.Bech32Address(atom.Config().Bech32Prefix)
+	send, err := EncodeICAMessage("/cosmos.bank.v1beta1.MsgSend", &banktypes.MsgSend{
+		FromAddress: icaAddress,
+		ToAddress:   atomUserAddress,
+		Amount:      sdk.NewCoins(sdk.NewInt64Coin(atom.Config().Denom, 1_000)),
+	})
+	require.NoError(t, err)
+
+	for _, memo := range []string{"invoice-2023-0042", ""} {
+		sequence, err := SubmitICATxWithMemo(ctx, neutron, env.neutronUser.KeyName, contract, "test", memo, 0, send)
+		require.NoError(t, err, "failed to submit ICA send")
+		result, err := WaitForAcknowledgement(ctx, neutron, contract, "test", sequence, 2*time.Minute)
+		require.NoError(t, err)
+		require.Equal(t, []string{"/cosmos.bank.v1beta1.MsgSend"}, result.Success)
+
+		received, err := QueryHostPacketMemo(ctx, atom, hostChannelId, sequence)
+		require.NoError(t, err)
+		require.Equal(t, memo, received)
+	}
+}
+
+// Returns a new address on chain that nothing has ever used.
+func randomAddress(t *testing.T, chain *cosmos.CosmosChain) string {
+	t.Helper()
+	bz := make([]byte, 20)
+	_, err := rand.Read(bz)
+	require.NoError(t, err)
+	address, err := sdk.Bech32ifyAddressBytes(chain.Config().Bech32Prefix, bz)
+	require.NoError(t, err)
+	return address
+}
+
+// Tests that a multi-send splits a payment between its recipients,
+// and that one whose input doesn't match its outputs is rejected by
+// the host with an error acknowledgement.
+func TestSubmitMultiSend(t *testing.T) {
+	env := setupICSTest(t)
+	ctx, atom, neutron := env.ctx, env.atom, env.neutron
+	denom := atom.Config().Denom
+
+	contract := deployICAContract(t, env)
+	icaAddress := registerICA(t, env, contract, "test")
+	fundICA(t, env, icaAddress, 1_000_000)
+
+	outputs := map[string]int64{
+		randomAddress(t, atom): 1_000,
+		randomAddress(t, atom): 2_000,
+		randomAddress(t, atom): 3_000,
+	}
+	sequence, err := SubmitICAMultiSend(ctx, neutron, env.neutronUser.KeyName, contract, "test", outputs, denom, 0)
+	require.NoError(t, err, "failed to submit ICA multi-send")
+	result, err := WaitForAcknowledgement(ctx, neutron, contract, "test", sequence, 2*time.Minute)
+	require.NoError(t, err)
+	require.Equal(t, []string{"/cosmos.bank.v1beta1.MsgMultiSend"}, result.Success)
+	for address, amount := range outputs {
+		balance, err := queryBalance(ctx, atom, address, denom)
+		require.NoError(t, err)
+		require.Equal(t, amount, balance, "%s should have received its share", address)
+	}
+
+	t.Run("unbalanced", func(t *testing.T) {
+		msg, err := EncodeICAMessage("/cosmos.bank.v1beta1.MsgMultiSend", multiSendMsg(icaAddress, 1_000, outputs, denom))
+		require.NoError(t, err)
+		sequence, err := SubmitICATx(ctx, neutron, env.neutronUser.KeyName, contract, "test", 0, msg)
+		require.NoError(t, err, "the contract can't check the message, so should submit it")
+		result, err := WaitForAcknowledgement(ctx, neutron, contract, "test", sequence, 2*time.Minute)
+		require.NoError(t, err)
+		require.NotNil(t, result.Error, "expected an error, got %+v", result)
+		for address, amount := range outputs {
+			balance, err := queryBalance(ctx, atom, address, denom)
+			require.NoError(t, err)
+			require.Equal(t, amount, balance, "%s should have received nothing more", address)
+		}
+	})
+}
+
+func TestMultiSendMsg(t *testing.T) {
+	msg := multiSendMsg("cosmos1from", 6, map[string]int64{"cosmos1c": 3, "cosmos1a": 1, "cosmos1b": 2}, "uatom")
+	require.Equal(t, []banktypes.Input{{Address: "cosmos1from", Coins: sdk.NewCoins(sdk.NewInt64Coin("uatom", 6))}}, msg.Inputs)
+	require.Equal(t, []banktypes.Output{
+		{Address: "cosmos1a", Coins: sdk.NewCoins(sdk.NewInt64Coin("uatom", 1))},
+		{Address: "cosmos1b", Coins: sdk.NewCoins(sdk.NewInt64Coin("uatom", 2))},
+		{Address: "cosmos1c", Coins: sdk.NewCoins(sdk.NewInt64Coin("uatom", 3))},
+	}, msg.Outputs)
+}
+
+// Submits a packet with submit, which returns its sequence, and waits
+// for the contract to receive its acknowledgement. Fails t if that
+// took more than budget blocks of chain, naming how many it took.
+// The count is taken once the acknowledgement is seen, so may be a
+// block over.
+func requireAckWithinBlocks(t *testing.T, ctx context.Context, chain *cosmos.CosmosChain, contract, accountId string, budget int, submit func() (uint64, error)) *AcknowledgementResult {
+	t.Helper()
+	submitHeight, err := CurrentHeight(ctx, chain)
+	require.NoError(t, err)
+	sequence, err := submit()
+	require.NoError(t, err, "failed to submit packet")
+	result, err := WaitForAcknowledgement(ctx, chain, contract, accountId, sequence, 2*time.Minute)
+	require.NoError(t, err)
+	blocks, err := BlocksSince(ctx, chain, submitHeight)
+	require.NoError(t, err)
+	require.LessOrEqual(t, blocks, int64(budget), "acknowledgement of packet %d took %d blocks, over the budget of %d", sequence, blocks, budget)
+	return result
+}
+
+// Tests that the relayer brings acknowledgements back within
+// `Timing.ackBudgetBlocks`. A slower relayer config still passes the
+// other tests, which only wait on acknowledgements.
+func TestAckWithinBudget(t *testing.T) {
+	env := setupICSTest(t)
+	ctx, atom, neutron := env.ctx, env.atom, env.neutron
+
+	contract := deployICAContract(t, env)
+	icaAddress := registerICA(t, env, contract, "test")
+	fundICA(t, env, icaAddress, 1_000_000)
+
+	atomUserAddress := env.atomUser.Bech32Address(atom.Config().Bech32Prefix)
+	result := requireAckWithinBlocks(t, ctx, neutron, contract, "test", timing.ackBudgetBlocks, func() (uint64, error) {
+		return SubmitICASend(ctx, neutron, env.neutronUser.KeyName, contract, "test", atomUserAddress, 1_000, atom.Config().Denom, 0)
+	})
+	require.Equal(t, []string{"/cosmos.bank.v1beta1.MsgSend"}, result.Success)
+}
+
+// Tests that the contract refuses to submit messages for an account
+// whose channel handshake hasn't finished, and accepts them once it
+// has. The relayer is stopped while registering so that the
+// handshake can not finish early.
+func TestSubmitBeforeChannelOpen(t *testing.T) {
+	env := setupICSTest(t)
+	ctx, atom, neutron := env.ctx, env.atom, env.neutron
+	contract := deployICAContract(t, env)
+
+	err := env.relayer.StopRelayer(ctx, env.eRep)
+	require.NoError(t, err, "failed to stop relayer")
+	err = RegisterICA(ctx, neutron, env.neutronUser.KeyName, contract, env.connectionId, "test")
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		channel, err := QueryICAChannel(ctx, neutron, contract, "test")
+		return err == nil && channel.State == "PENDING"
+	}, time.Minute, pollInterval, "the registered account should be pending")
+
+	// The contract doesn't know the account's address yet, so send
+	// from nobody. The message is never executed.
+	atomUserAddress := env.atomUser.Bech32Address(atom.Config().Bech32Prefix)
+	msg, err := EncodeICAMessage("/cosmos.bank.v1beta1.MsgSend", &banktypes.MsgSend{
+		ToAddress: atomUserAddress,
+		Amount:    sdk.NewCoins(sdk.NewInt64Coin(atom.Config().Denom, 1_000)),
+	})
+	require.NoError(t, err)
+	_, err = SubmitICATx(ctx, neutron, env.neutronUser.KeyName, contract, "test", 0, msg)
+	require.ErrorContains(t, err, "Interchain account is not created yet")
+
+	err = env.relayer.StartRelayer(ctx, env.eRep, icsPath, ibcPath)
+	require.NoError(t, err, "failed to restart relayer")
+	icaAddress, err := WaitForICAAddress(ctx, neutron, contract, "test", env.connectionId, 2*time.Minute)
+	require.NoError(t, err)
+	fundICA(t, env, icaAddress, 1_000_000)
+
+	sequence, err := SubmitICASend(ctx, neutron, env.neutronUser.KeyName, contract, "test", atomUserAddress, 1_000, atom.Config().Denom, 0)
+	require.NoError(t, err, "submitting should succeed once the channel is open")
+	result, err := WaitForAcknowledgement(ctx, neutron, contract, "test", sequence, 2*time.Minute)
+	require.NoError(t, err)
+	require.NotNil(t, result.Success, "expected success, got %+v", result)
+}
+
+// Tests that a send the contract reports as successful really ran on
+// the host, by finding the bank transfer it emitted there. The
+// account was funded by the user, so it is only ever the sender of
+// this send.
+func TestSubmitSendHostEvent(t *testing.T) {
+	env := setupICSTest(t)
+	ctx, atom, neutron := env.ctx, env.atom, env.neutron
+
+	contract := deployICAContract(t, env)
+	icaAddress := registerICA(t, env, contract, "test")
+	fundICA(t, env, icaAddress, 1_000_000)
+
+	found, err := FindHostEvent(ctx, atom, "transfer", "sender", icaAddress)
+	require.NoError(t, err)
+	require.False(t, found, "the ICA has not sent anything yet")
+
+	atomUserAddress := env.atomUser.Bech32Address(atom.Config().Bech32Prefix)
+	sequence, err := SubmitICASend(ctx, neutron, env.neutronUser.KeyName, contract, "test", atomUserAddress, 1_000, atom.Config().Denom, 0)
+	require.NoError(t, err, "failed to submit ICA send")
+	result, err := WaitForAcknowledgement(ctx, neutron, contract, "test", sequence, 2*time.Minute)
+	require.NoError(t, err)
+	require.NotNil(t, result.Success, "expected success, got %+v", result)
+
+	found, err = FindHostEvent(ctx, atom, "transfer", "sender", icaAddress)
+	require.NoError(t, err)
+	require.True(t, found, "the host has no record of the send")
+}
+
+// Tests the lifecycle of an account's recorded errors: a send the
+// account can't afford is recorded as an error, and a later send that
+// succeeds supersedes it without erasing the first packet's result.
+func TestSubmitErrorSuperseded(t *testing.T) {
+	env := setupICSTest(t)
+	ctx, atom, neutron := env.ctx, env.atom, env.neutron
+	denom := atom.Config().Denom
+	atomUserAddress := env.atomUser.Bech32Address(atom.Config().Bech32Prefix)
+
+	contract := deployICAContract(t, env)
+	icaAddress := registerICA(t, env, contract, "test")
+
+	// The account has no funds yet.
+	failed, err := SubmitICASend(ctx, neutron, env.neutronUser.KeyName, contract, "test", atomUserAddress, 1_000, denom, 0)
+	require.NoError(t, err, "failed to submit ICA send")
+	result, err := WaitForAcknowledgement(ctx, neutron, contract, "test", failed, 2*time.Minute)
+	require.NoError(t, err)
+	require.NotNil(t, result.Error, "expected an error, got %+v", result)
+
+	lastError, err := QueryLastICAError(ctx, neutron, contract, "test")
+	require.NoError(t, err)
+	require.NotNil(t, lastError)
+	require.Equal(t, failed, lastError.Sequence)
+	require.NotEmpty(t, lastError.Details)
+
+	fundICA(t, env, icaAddress, 1_000_000)
+	succeeded, err := SubmitICASend(ctx, neutron, env.neutronUser.KeyName, contract, "test", atomUserAddress, 1_000, denom, 0)
+	require.NoError(t, err, "failed to submit ICA send")
+	result, err = WaitForAcknowledgement(ctx, neutron, contract, "test", succeeded, 2*time.Minute)
+	require.NoError(t, err)
+	require.Equal(t, []string{"/cosmos.bank.v1beta1.MsgSend"}, result.Success)
+
+	lastError, err = QueryLastICAError(ctx, neutron, contract, "test")
+	require.NoError(t, err)
+	require.Nil(t, lastError, "the successful send should supersede the error")
+
+	// Results are keyed by sequence, so the error is still there.
+	result, err = QueryAcknowledgementResult(ctx, neutron, contract, "test", failed)
+	require.NoError(t, err)
+	require.NotNil(t, result.Error)
+}
+
+// Tests that a message the host can't decode gets an error
+// acknowledgement, and that the channel, which only closes on a
+// timeout, goes on to carry a well-formed message. Neutron passes
+// messages to the host without decoding them, so the type URL is
+// first checked there.
+func TestSubmitMalformedMessage(t *testing.T) {
+	env := setupICSTest(t)
+	ctx, atom, neutron := env.ctx, env.atom, env.neutron
+	atomUserAddress := env.atomUser.Bech32Address(atom.Config().Bech32Prefix)
+
+	contract := deployICAContract(t, env)
+	icaAddress := registerICA(t, env, contract, "test")
+	fundICA(t, env, icaAddress, 1_000_000)
+	channel, err := QueryICAChannel(ctx, neutron, contract, "test")
+	require.NoError(t, err)
+	icaChannel, err := FindICAChannel(ctx, env.relayer, env.eRep, neutron.Config().ChainID, channel.PortId)
+	require.NoError(t, err)
+	hostChannelId := icaChannel.Counterparty.ChannelID
+
+	const badTypeUrl = "/cosmos.bank.v1beta1.MsgSendd"
+	malformed, err := EncodeICAMessage(badTypeUrl, &banktypes.MsgSend{
+		FromAddress: icaAddress,
+		ToAddress:   atomUserAddress,
+		Amount:      sdk.NewCoins(sdk.NewInt64Coin(atom.Config().Denom, 1_000)),
+	})
+	require.NoError(t, err)
+	failed, err := SubmitICATx(ctx, neutron, env.neutronUser.KeyName, contract, "test", 0, malformed)
+	require.NoError(t, err, "failed to submit malformed message")
+	result, err := WaitForAcknowledgement(ctx, neutron, contract, "test", failed, 2*time.Minute)
+	require.NoError(t, err)
+	require.Len(t, result.Error, 2, "expected an error, got %+v", result)
+	require.Contains(t, result.Error[1], "error handling packet on host chain")
+
+	ackError, err := QueryHostAckError(ctx, atom, hostChannelId, failed)
+	require.NoError(t, err)
+	require.Contains(t, ackError, "no concrete type registered for type URL "+badTypeUrl)
+
+	channel, err = QueryICAChannel(ctx, neutron, contract, "test")
+	require.NoError(t, err)
+	require.Equal(t, "OPEN", channel.State)
+	err = WaitForChannelState(ctx, env.relayer, env.eRep, neutron.Config().ChainID, channel.ChannelId, "STATE_OPEN", time.Minute)
+	require.NoError(t, err)
+
+	succeeded, err := SubmitICASend(ctx, neutron, env.neutronUser.KeyName, contract, "test", atomUserAddress, 1_000, atom.Config().Denom, 0)
+	require.NoError(t, err, "failed to submit ICA send")
+	require.Equal(t, failed+1, succeeded)
+	result, err = WaitForAcknowledgement(ctx, neutron, contract, "test", succeeded, 2*time.Minute)
+	require.NoError(t, err)
+	require.Equal(t, []string{"/cosmos.bank.v1beta1.MsgSend"}, result.Success)
+}
+
+// Tests that packets sent by an interchain account are acknowledged
+// in order, without skipping any sequence, as the channel is ordered.
+func TestOrderedAcknowledgements(t *testing.T) {
+	env := setupICSTest(t)
+	ctx, atom, neutron := env.ctx, env.atom, env.neutron
+	atomUserAddress := env.atomUser.Bech32Address(atom.Config().Bech32Prefix)
+
+	contract := deployICAContract(t, env)
+	icaAddress := registerICA(t, env, contract, "test")
+	fundICA(t, env, icaAddress, 1_000_000)
+
+	last, err := QueryLastAckedSequence(ctx, neutron, contract, "test")
+	require.NoError(t, err)
+	require.Zero(t, last, "nothing has been sent yet")
+
+	for i := 0; i < 3; i++ {
+		sequence, err := SubmitICASend(ctx, neutron, env.neutronUser.KeyName, contract, "test", atomUserAddress, 1_000, atom.Config().Denom, 0)
+		require.NoError(t, err, "failed to submit ICA send")
+		require.Equal(t, last+1, sequence, "sequences should not skip")
+
+		_, err = WaitForAcknowledgement(ctx, neutron, contract, "test", sequence, 2*time.Minute)
+		require.NoError(t, err)
+		last, err = QueryLastAckedSequence(ctx, neutron, contract, "test")
+		require.NoError(t, err)
+		require.Equal(t, sequence, last)
+	}
+	require.Equal(t, uint64(3), last)
+}
+
+// Tests that paging through an interchain account's acknowledgement
+// results returns each of them exactly once, including the last,
+// partial, page.
+func TestListAcknowledgementResults(t *testing.T) {
+	env := setupICSTest(t)
+	ctx, atom, neutron := env.ctx, env.atom, env.neutron
+	atomUserAddress := env.atomUser.Bech32Address(atom.Config().Bech32Prefix)
+
+	contract := deployICAContract(t, env)
+	icaAddress := registerICA(t, env, contract, "test")
+	fundICA(t, env, icaAddress, 1_000_000)
+
+	var sequences []uint64
+	for i := 0; i < 5; i++ {
+		sequence, err := SubmitICASend(ctx, neutron, env.neutronUser.KeyName, contract, "test", atomUserAddress, 1_000, atom.Config().Denom, 0)
+		require.NoError(t, err, "failed to submit ICA send")
+		sequences = append(sequences, sequence)
+	}
+	for _, sequence := range sequences {
+		_, err := WaitForAcknowledgement(ctx, neutron, contract, "test", sequence, 2*time.Minute)
+		require.NoError(t, err)
+	}
+
+	first, err := QueryAcknowledgementResults(ctx, neutron, contract, "test", nil, 2)
+	require.NoError(t, err)
+	require.Len(t, first, 2)
+	last, err := QueryAcknowledgementResults(ctx, neutron, contract, "test", &sequences[3], 2)
+	require.NoError(t, err)
+	require.Len(t, last, 1, "the last page should hold the one remaining result")
+
+	results, err := ListAcknowledgementResults(ctx, neutron, contract, "test", 2)
+	require.NoError(t, err)
+	listed := make([]uint64, 0, len(results))
+	for _, result := range results {
+		require.NotNil(t, result.Result.Success, "send %d should succeed", result.SequenceId)
+		listed = append(listed, result.SequenceId)
+	}
+	require.Equal(t, sequences, listed, "each result should be listed once, in order")
+}
+
+// Tests that an interchain account earns staking rewards by
+// delegating, and can withdraw them.
+func TestSubmitWithdrawRewards(t *testing.T) {
+	env := setupICSTest(t)
+	ctx, atom, neutron := env.ctx, env.atom, env.neutron
+	denom := atom.Config().Denom
+
+	contract := deployICAContract(t, env)
+	icaAddress := registerICA(t, env, contract, "test")
+	fundICA(t, env, icaAddress, 10_000_000)
+
+	validator, err := QueryLargestValidator(ctx, atom)
+	require.NoError(t, err)
+	sequence, err := SubmitICADelegate(ctx, neutron, env.neutronUser.KeyName, contract, "test", validator, 5_000_000, denom, 0)
+	require.NoError(t, err, "failed to submit ICA delegation")
+	result, err := WaitForAcknowledgement(ctx, neutron, contract, "test", sequence, 2*time.Minute)
+	require.NoError(t, err)
+	require.Equal(t, []string{"/cosmos.staking.v1beta1.MsgDelegate"}, result.Success)
+
+	_, err = WaitForDelegatorRewards(ctx, atom, icaAddress, validator, denom, 2*time.Minute)
+	require.NoError(t, err)
+
+	// Rewards keep accruing until the withdrawal executes, so
+	// measure the rate they accrue at to bound what it pays out.
+	firstHeight, err := CurrentHeight(ctx, atom)
+	require.NoError(t, err)
+	firstRewards, err := QueryICARewards(ctx, atom, icaAddress, validator)
+	require.NoError(t, err)
+	err = testutil.WaitForBlocks(ctx, 2, atom)
+	require.NoError(t, err)
+	queriedHeight, err := CurrentHeight(ctx, atom)
+	require.NoError(t, err)
+	queried, err := QueryICARewards(ctx, atom, icaAddress, validator)
+	require.NoError(t, err)
+	perBlock := float64(queried-firstRewards) / float64(queriedHeight-firstHeight)
+	before, err := atom.GetBalance(ctx, icaAddress, denom)
+	require.NoError(t, err)
+
+	sequence, err = SubmitICAWithdrawRewards(ctx, neutron, env.neutronUser.KeyName, contract, "test", validator, 0)
+	require.NoError(t, err, "failed to submit ICA reward withdrawal")
+	result, err = WaitForAcknowledgement(ctx, neutron, contract, "test", sequence, 2*time.Minute)
+	require.NoError(t, err)
+	require.Equal(t, []string{"/cosmos.distribution.v1beta1.MsgWithdrawDelegatorReward"}, result.Success)
+
+	after, err := atom.GetBalance(ctx, icaAddress, denom)
+	require.NoError(t, err)
+	blocks, err := BlocksSince(ctx, atom, queriedHeight)
+	require.NoError(t, err)
+	// Withdrawing truncates fractional rewards as the query does.
+	// Between the query and the withdrawal executing, rewards
+	// accrue at about perBlock, so allow for that over the blocks
+	// since, plus one for rounding.
+	gained := after - before
+	require.GreaterOrEqual(t, gained, queried, "withdrawing should pay out at least the queried rewards")
+	require.LessOrEqual(t, float64(gained), float64(queried)+perBlock*float64(blocks+1)+1, "paid %d, queried %d, %d blocks before", gained, queried, blocks)
+}
+
+// Tests that an interchain account can move its stake between
+// validators. Gaia runs two validators, but the test skips rather
+// than fails if there is only one to use.
+func TestSubmitRedelegate(t *testing.T) {
+	env := setupICSTest(t)
+	ctx, atom, neutron := env.ctx, env.atom, env.neutron
+	denom := atom.Config().Denom
+
+	src, dst, err := GetTwoBondedValidators(ctx, atom)
+	if errors.Is(err, errTooFewValidators) {
+		t.Skipf("skipping as redelegating needs two validators: %s", err)
+	}
+	require.NoError(t, err)
+
+	contract := deployICAContract(t, env)
+	icaAddress := registerICA(t, env, contract, "test")
+	fundICA(t, env, icaAddress, 10_000_000)
+
+	sequence, err := SubmitICADelegate(ctx, neutron, env.neutronUser.KeyName, contract, "test", src, 5_000_000, denom, 0)
+	require.NoError(t, err, "failed to submit ICA delegation")
+	result, err := WaitForAcknowledgement(ctx, neutron, contract, "test", sequence, 2*time.Minute)
+	require.NoError(t, err)
+	require.Equal(t, []string{"/cosmos.staking.v1beta1.MsgDelegate"}, result.Success)
+	delegations, err := QueryDelegations(ctx, atom, icaAddress)
+	require.NoError(t, err)
+	require.Equal(t, map[string]int64{src: 5_000_000}, delegations)
+
+	sequence, err = SubmitICARedelegate(ctx, neutron, env.neutronUser.KeyName, contract, "test", src, dst, 5_000_000, denom, 0)
+	require.NoError(t, err, "failed to submit ICA redelegation")
+	result, err = WaitForAcknowledgement(ctx, neutron, contract, "test", sequence, 2*time.Minute)
+	require.NoError(t, err)
+	require.Equal(t, []string{"/cosmos.staking.v1beta1.MsgBeginRedelegate"}, result.Success)
+	delegations, err = QueryDelegations(ctx, atom, icaAddress)
+	require.NoError(t, err)
+	require.Equal(t, map[string]int64{dst: 5_000_000}, delegations, "the delegation should have moved to %s", dst)
+}
+
+// Submits a send from the interchain account with ID accountId that
+// times out, and returns the packet's sequence once the timeout has
+// been relayed back to Neutron. As the ICA's channel is ordered, this
+// also closes the channel.
+//
+// To time the packet out deterministically:
+//
+//  1. Stop the relayer, so nothing can deliver the packet.
+//  2. Submit with a short timeout. The timeout is a timestamp, the
+//     submitting block's time plus the timeout.
+//  3. Wait for Atom to produce a block with a later time. Wall-clock
+//     time passing is not enough, as the relayer proves timeouts
+//     with the host chain's block time.
+//  4. Start the relayer, which finds the packet can no longer be
+//     delivered and relays the timeout back to Neutron.
+func timeOutICAPacket(t *testing.T, env *icsTestEnv, contract, accountId string) uint64 {
+	t.Helper()
+	const timeout = 10
+	ctx, atom, neutron := env.ctx, env.atom, env.neutron
+
+	err := env.relayer.StopRelayer(ctx, env.eRep)
+	require.NoError(t, err, "failed to stop relayer")
+
+	atomUserAddress := env.atomUser.Bech32Address(atom.Config().Bech32Prefix)
+	sequence, err := SubmitICASend(ctx, neutron, env.neutronUser.KeyName, contract, accountId, atomUserAddress, 1_000, atom.Config().Denom, timeout)
+	require.NoError(t, err, "failed to submit ICA send")
+	submittedAt, err := currentBlockTime(ctx, neutron)
+	require.NoError(t, err)
+
+	err = WaitForBlockTimeAfter(ctx, atom, submittedAt.Add(timeout*time.Second), time.Minute)
+	require.NoError(t, err)
+	err = testutil.WaitForBlocks(ctx, timing.ackBlocks, atom)
+	require.NoError(t, err, "failed to wait for blocks")
+
+	err = env.relayer.StartRelayer(ctx, env.eRep, icsPath, ibcPath)
+	require.NoError(t, err, "failed to restart relayer")
+
+	result, err := WaitForAcknowledgement(ctx, neutron, contract, accountId, sequence, 2*time.Minute)
+	require.NoError(t, err)
+	require.NotNil(t, result.Timeout, "expected a timeout, got %+v", result)
+	return sequence
+}
+
+// Tests that pausing only the path an interchain account's connection
+// is on times out its packets, while the ICS path keeps running, and
+// that the timeout is relayed once the path resumes.
+func TestPauseICAPath(t *testing.T) {
+	const timeout = 10
+	env := setupICSTest(t)
+	ctx, atom, neutron := env.ctx, env.atom, env.neutron
+
+	// Put the account on the transfer path's connection, so that it
+	// can be paused without pausing the ICS path.
+	connection, err := transferConnection(ctx, env.relayer, env.eRep, neutron.Config().ChainID)
+	require.NoError(t, err)
+	contract := deployICAContract(t, env)
+	err = RegisterICA(ctx, neutron, env.neutronUser.KeyName, contract, connection.ID, "test")
+	require.NoError(t, err)
+	_, err = WaitForICAAddress(ctx, neutron, contract, "test", connection.ID, 2*time.Minute)
+	require.NoError(t, err)
+
+	paths, err := PauseRelayerPath(ctx, env.relayer, env.eRep, []string{icsPath, ibcPath}, ibcPath)
+	require.NoError(t, err)
+
+	atomUserAddress := env.atomUser.Bech32Address(atom.Config().Bech32Prefix)
+	sequence, err := SubmitICASend(ctx, neutron, env.neutronUser.KeyName, contract, "test", atomUserAddress, 1_000, atom.Config().Denom, timeout)
+	require.NoError(t, err, "failed to submit ICA send")
+	submittedAt, err := currentBlockTime(ctx, neutron)
+	require.NoError(t, err)
+
+	// Wait for Atom to pass the packet's timeout, as
+	// `timeOutICAPacket` does, and for at least as long as an ack
+	// would take to be relayed.
+	err = WaitForBlockTimeAfter(ctx, atom, submittedAt.Add(timeout*time.Second), time.Minute)
+	require.NoError(t, err)
+	err = testutil.WaitForBlocks(ctx, timing.ackBlocks, atom, neutron)
+	require.NoError(t, err, "both chains should keep producing blocks while the path is paused")
+	result, err := QueryAcknowledgementResult(ctx, neutron, contract, "test", sequence)
+	require.NoError(t, err)
+	require.Nil(t, result, "nothing should be relayed while the path is paused")
+	require.NoError(t, AssertCCVHealthy(ctx, neutron), "pausing the transfer path should not affect the ICS path")
+
+	_, err = ResumeRelayerPath(ctx, env.relayer, env.eRep, paths, ibcPath)
+	require.NoError(t, err)
+	result, err = WaitForAcknowledgement(ctx, neutron, contract, "test", sequence, 2*time.Minute)
+	require.NoError(t, err)
+	require.NotNil(t, result.Timeout, "expected a timeout, got %+v", result)
+}
+
+// Tests that a packet that isn't relayed before its timeout is
+// recorded by the contract as timed out. See `timeOutICAPacket`.
+func TestSubmitTimeout(t *testing.T) {
+	env := setupICSTest(t)
+	ctx, atom, neutron := env.ctx, env.atom, env.neutron
+
+	contract := deployICAContract(t, env)
+	icaAddress := registerICA(t, env, contract, "test")
+	fundICA(t, env, icaAddress, 1_000_000)
+
+	sequence := timeOutICAPacket(t, env, contract, "test")
+
+	result, err := QueryAcknowledgementResult(ctx, neutron, contract, "test", sequence)
+	require.NoError(t, err)
+	require.Nil(t, result.Success)
+	require.Nil(t, result.Error)
+
+	// The send never happened.
+	balance, err := atom.GetBalance(ctx, icaAddress, atom.Config().Denom)
+	require.NoError(t, err)
+	require.Equal(t, int64(1_000_000), balance)
+}
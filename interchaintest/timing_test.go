@@ -0,0 +1,369 @@
+package ibc_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/strangelove-ventures/interchaintest/v3/chain/cosmos"
+	"github.com/strangelove-ventures/interchaintest/v3/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+// How many blocks tests wait for chains to make progress that they
+// can't poll for, and how long those blocks take. Each can be
+// overridden with an environment variable, for environments where
+// blocks come faster or slower than on a typical laptop.
+type Timing struct {
+	// How long validators wait after committing a block before
+	// proposing the next, their consensus `timeout_commit`, on both
+	// chains. Blocks take this long plus however long it takes to
+	// agree on them. See `blockTimeConfig`. ICA_BLOCK_TIME, as a Go
+	// duration, for example "1s".
+	blockTime time.Duration
+	// Blocks to wait for an ICA channel handshake to complete
+	// after registering. ICA_HANDSHAKE_BLOCKS.
+	handshakeBlocks int
+	// Blocks to wait for the chains to settle after the
+	// interchain is built, before the relayer starts. See
+	// `waitForInterchainSettle`. ICA_SETTLE_BLOCKS.
+	settleBlocks int
+	// Blocks to wait for a validator set change on the provider
+	// to reach the consumer. ICA_VSC_BLOCKS.
+	vscBlocks int
+	// Blocks to wait for the host to move past a packet before its
+	// acknowledgement, or timeout, is relayed. ICA_ACK_BLOCKS.
+	ackBlocks int
+	// The most blocks an acknowledgement may take to arrive back
+	// on Neutron after its packet is submitted, in tests that hold
+	// the relayer to it. ICA_ACK_BUDGET_BLOCKS.
+	ackBudgetBlocks int
+}
+
+var defaultTiming = Timing{
+	// interchaintest's own default.
+	blockTime:       2 * time.Second,
+	handshakeBlocks: 10,
+	settleBlocks:    10,
+	vscBlocks:       10,
+	ackBlocks:       2,
+	ackBudgetBlocks: 8,
+}
+
+// The shortest `Timing.blockTime` that still gives steady blocks.
+// Validators also wait this long for a proposal, and below about half
+// a second those on a busy Docker host start missing proposals, so
+// rounds fail and blocks come slower and less regularly, not faster.
+const minBlockTime = 500 * time.Millisecond
+
+// The block counts tests use. Set up by `TestMain`.
+var timing = defaultTiming
+
+// Reads `Timing` overrides from getenv, keeping the defaults for
+// those that are unset.
+func parseTiming(getenv func(string) string) (Timing, error) {
+	parsed := defaultTiming
+	for env, field := range map[string]*int{
+		"ICA_HANDSHAKE_BLOCKS":  &parsed.handshakeBlocks,
+		"ICA_SETTLE_BLOCKS":     &parsed.settleBlocks,
+		"ICA_VSC_BLOCKS":        &parsed.vscBlocks,
+		"ICA_ACK_BLOCKS":        &parsed.ackBlocks,
+		"ICA_ACK_BUDGET_BLOCKS": &parsed.ackBudgetBlocks,
+	} {
+		value := getenv(env)
+		if value == "" {
+			continue
+		}
+		blocks, err := strconv.Atoi(value)
+		if err != nil || blocks <= 0 {
+			return Timing{}, fmt.Errorf("%s must be a positive integer, got %q", env, value)
+		}
+		*field = blocks
+	}
+	if value := getenv("ICA_BLOCK_TIME"); value != "" {
+		blockTime, err := time.ParseDuration(value)
+		if err != nil || blockTime < minBlockTime {
+			return Timing{}, fmt.Errorf("ICA_BLOCK_TIME must be a duration of at least %s, got %q", minBlockTime, value)
+		}
+		parsed.blockTime = blockTime
+	}
+	return parsed, nil
+}
+
+// Returns the `ChainConfig.ConfigFileOverrides` that give a chain's
+// nodes blocks blockTime apart. The proposal timeout is set to match,
+// as interchaintest does, so that a missed proposal doesn't stall the
+// chain for longer than a block.
+func blockTimeConfig(blockTime time.Duration) map[string]any {
+	return map[string]any{
+		"config/config.toml": testutil.Toml{
+			"consensus": testutil.Toml{
+				"timeout_commit":  blockTime.String(),
+				"timeout_propose": blockTime.String(),
+			},
+		},
+	}
+}
+
+// Waits timing's settle blocks on each of chains, once the interchain
+// is built and before the relayer starts relaying. Building returns
+// as soon as the clients, connections, and channels it creates are
+// committed, but the relayer queries them, and proves them to the
+// other chain, at a height it takes from its own view of the chain.
+// A relayer started straight away often sees a height whose state
+// doesn't have them yet and fails the CCV handshake or its first
+// client update. Ten blocks gets every node past the height the last
+// of them was created at on a typical laptop, with room to spare.
+//
+// This used to wait the VSC blocks, so it waits those instead if they
+// are the higher, keeping older overrides of ICA_VSC_BLOCKS working.
+func waitForInterchainSettle(ctx context.Context, timing Timing, chains ...testutil.ChainHeighter) error {
+	blocks := timing.settleBlocks
+	if timing.vscBlocks > blocks {
+		blocks = timing.vscBlocks
+	}
+	if err := testutil.WaitForBlocks(ctx, blocks, chains...); err != nil {
+		return fmt.Errorf("failed to wait %d blocks for the interchain to settle: %w", blocks, err)
+	}
+	return nil
+}
+
+// Reads the `Timing` overrides from the environment and sets up
+// `timing` to match.
+func configureTiming() error {
+	parsed, err := parseTiming(os.Getenv)
+	if err != nil {
+		return err
+	}
+	timing = parsed
+	return nil
+}
+
+func TestParseTiming(t *testing.T) {
+	parsed, err := parseTiming(func(string) string { return "" })
+	require.NoError(t, err)
+	require.Equal(t, defaultTiming, parsed, "unset should mean the defaults")
+
+	env := map[string]string{"ICA_HANDSHAKE_BLOCKS": "20", "ICA_ACK_BLOCKS": "5", "ICA_ACK_BUDGET_BLOCKS": "12"}
+	parsed, err = parseTiming(func(key string) string { return env[key] })
+	require.NoError(t, err)
+	require.Equal(t, Timing{
+		blockTime:       defaultTiming.blockTime,
+		handshakeBlocks: 20,
+		settleBlocks:    defaultTiming.settleBlocks,
+		vscBlocks:       defaultTiming.vscBlocks,
+		ackBlocks:       5,
+		ackBudgetBlocks: 12,
+	}, parsed)
+
+	for _, value := range []string{"0", "-1", "ten"} {
+		_, err := parseTiming(func(key string) string {
+			if key == "ICA_VSC_BLOCKS" {
+				return value
+			}
+			return ""
+		})
+		require.Error(t, err, value)
+	}
+
+	parsed, err = parseTiming(func(key string) string {
+		if key == "ICA_BLOCK_TIME" {
+			return "750ms"
+		}
+		return ""
+	})
+	require.NoError(t, err)
+	require.Equal(t, 750*time.Millisecond, parsed.blockTime)
+	for _, value := range []string{"100ms", "1", "-1s"} {
+		_, err := parseTiming(func(key string) string {
+			if key == "ICA_BLOCK_TIME" {
+				return value
+			}
+			return ""
+		})
+		require.ErrorContains(t, err, "at least 500ms", value)
+	}
+}
+
+// Not parallel, as it replaces `timing`.
+func TestConfigureTiming(t *testing.T) {
+	previous := timing
+	t.Cleanup(func() { timing = previous })
+
+	t.Setenv("ICA_VSC_BLOCKS", "30")
+	require.NoError(t, configureTiming())
+	require.Equal(t, 30, timing.vscBlocks)
+	require.Equal(t, defaultTiming.handshakeBlocks, timing.handshakeBlocks)
+}
+
+// A chain that is a block further along every time its height is
+// asked for.
+type countingHeighter struct {
+	height uint64
+}
+
+func (c *countingHeighter) Height(ctx context.Context) (uint64, error) {
+	c.height++
+	return c.height, nil
+}
+
+func TestWaitForInterchainSettle(t *testing.T) {
+	ctx := context.Background()
+	configured := defaultTiming
+	configured.settleBlocks = 3
+	configured.vscBlocks = 2
+
+	atom, neutron := &countingHeighter{}, &countingHeighter{height: 100}
+	require.NoError(t, waitForInterchainSettle(ctx, configured, atom, neutron))
+	// The first height seen is the starting one.
+	require.Equal(t, uint64(1+3), atom.height)
+	require.Equal(t, uint64(101+3), neutron.height)
+
+	// A higher VSC wait wins.
+	configured.vscBlocks = 5
+	atom = &countingHeighter{}
+	require.NoError(t, waitForInterchainSettle(ctx, configured, atom))
+	require.Equal(t, uint64(1+5), atom.height)
+}
+
+func TestBlockTimeConfig(t *testing.T) {
+	config := blockTimeConfig(750 * time.Millisecond)
+	require.Equal(t, testutil.Toml{
+		"consensus": testutil.Toml{"timeout_commit": "750ms", "timeout_propose": "750ms"},
+	}, config["config/config.toml"])
+}
+
+// The parts of the response to `query block` that say when it was
+// made.
+type blockQueryResponse struct {
+	Block struct {
+		Header struct {
+			Height string    `json:"height"`
+			Time   time.Time `json:"time"`
+		} `json:"header"`
+	} `json:"block"`
+}
+
+// Queries when the block at height on chain was made, by its header.
+func QueryBlockTime(ctx context.Context, chain *cosmos.CosmosChain, height int64) (time.Time, error) {
+	var response blockQueryResponse
+	if err := QueryHostJSON(ctx, chain, []string{"block", strconv.FormatInt(height, 10)}, &response); err != nil {
+		return time.Time{}, err
+	}
+	if response.Block.Header.Height != strconv.FormatInt(height, 10) {
+		return time.Time{}, fmt.Errorf("asked for block %d, got %q", height, response.Block.Header.Height)
+	}
+	return response.Block.Header.Time, nil
+}
+
+// Polls until the latest block on chain was made after deadline, or
+// until timeout elapses. A packet with a timeout timestamp can only
+// be timed out once the host has a block later than it, as that is
+// what the relayer proves, however much wall-clock time has passed.
+func WaitForBlockTimeAfter(ctx context.Context, chain *cosmos.CosmosChain, deadline time.Time, timeout time.Duration) error {
+	return waitForBlockTimeAfter(ctx, func(ctx context.Context) (time.Time, error) {
+		return currentBlockTime(ctx, chain)
+	}, chain.Config().ChainID, deadline, timeout)
+}
+
+func waitForBlockTimeAfter(ctx context.Context, latest func(context.Context) (time.Time, error), chainID string, deadline time.Time, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var last time.Time
+	var lastErr error
+	for {
+		blockTime, err := latest(ctx)
+		if err == nil && blockTime.After(deadline) {
+			return nil
+		}
+		if err != nil {
+			lastErr = err
+		} else {
+			last = blockTime
+		}
+
+		select {
+		case <-ctx.Done():
+			if lastErr != nil {
+				return fmt.Errorf("timed out after %s waiting for a block on %s after %s (last saw %s): %w", timeout, chainID, deadline, last, lastErr)
+			}
+			return fmt.Errorf("timed out after %s waiting for a block on %s after %s (last saw %s)", timeout, chainID, deadline, last)
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+// Returns when the block on chain that is current now was made. Call
+// this right after a transaction broadcast in block mode, to get a
+// time no earlier than the block that included it.
+func currentBlockTime(ctx context.Context, chain *cosmos.CosmosChain) (time.Time, error) {
+	height, err := CurrentHeight(ctx, chain)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return QueryBlockTime(ctx, chain, height)
+}
+
+// Tests that both chains make blocks about as far apart as they are
+// configured to. A block takes at least the block time, and
+// agreeing on it adds a little more.
+func TestBlockTime(t *testing.T) {
+	const (
+		blockTime = time.Second
+		blocks    = 10
+	)
+	env := setupICSTestWithConfig(t, icsTestConfig{blockTime: blockTime})
+
+	for _, chain := range []*cosmos.CosmosChain{env.atom, env.neutron} {
+		start, err := CurrentHeight(env.ctx, chain)
+		require.NoError(t, err)
+		require.NoError(t, testutil.WaitForBlocks(env.ctx, blocks, chain))
+
+		first, err := QueryBlockTime(env.ctx, chain, start)
+		require.NoError(t, err)
+		last, err := QueryBlockTime(env.ctx, chain, start+blocks)
+		require.NoError(t, err)
+		interval := last.Sub(first) / blocks
+		t.Logf("%s made a block every %s", chain.Config().ChainID, interval)
+		require.GreaterOrEqual(t, interval, blockTime*9/10, "%s makes blocks faster than configured", chain.Config().ChainID)
+		require.Less(t, interval, 2*blockTime, "%s makes blocks much slower than configured", chain.Config().ChainID)
+	}
+}
+
+func TestParseBlockQuery(t *testing.T) {
+	var response blockQueryResponse
+	err := json.Unmarshal([]byte(`{"block_id":{"hash":"AB12"},"block":{"header":{"chain_id":"neutron-2","height":"42","time":"2023-06-01T12:00:01.5Z"},"data":{"txs":[]}}}`), &response)
+	require.NoError(t, err)
+	require.Equal(t, "42", response.Block.Header.Height)
+	require.Equal(t, time.Date(2023, 6, 1, 12, 0, 1, 500_000_000, time.UTC), response.Block.Header.Time)
+}
+
+func TestWaitForBlockTimeAfter(t *testing.T) {
+	ctx := context.Background()
+	deadline := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	// Blocks a second apart, starting a second before the
+	// deadline: the one at the deadline itself is not later.
+	calls := 0
+	latest := func(context.Context) (time.Time, error) {
+		calls++
+		return deadline.Add(time.Duration(calls-2) * time.Second), nil
+	}
+	require.NoError(t, waitForBlockTimeAfter(ctx, latest, "cosmoshub-test", deadline, 10*time.Second))
+	require.Equal(t, 3, calls)
+
+	stuck := func(context.Context) (time.Time, error) { return deadline, nil }
+	err := waitForBlockTimeAfter(ctx, stuck, "cosmoshub-test", deadline, 500*time.Millisecond)
+	require.ErrorContains(t, err, "timed out")
+	require.ErrorContains(t, err, "cosmoshub-test")
+
+	failing := func(context.Context) (time.Time, error) { return time.Time{}, errors.New("rpc error") }
+	err = waitForBlockTimeAfter(ctx, failing, "cosmoshub-test", deadline, 500*time.Millisecond)
+	require.ErrorContains(t, err, "rpc error")
+}
@@ -0,0 +1,118 @@
+package ibc_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/strangelove-ventures/interchaintest/v3/chain/cosmos"
+	"github.com/strangelove-ventures/interchaintest/v3/ibc"
+	"github.com/strangelove-ventures/interchaintest/v3/testreporter"
+	"github.com/stretchr/testify/require"
+)
+
+// The response to `query ibc connection connections`.
+type connectionsQueryResponse struct {
+	Connections []*ibc.ConnectionOutput `json:"connections"`
+}
+
+// Logs every connection and channel on chains if t fails, both as
+// each chain reports them and as the relayer sees them. ICA tests that
+// fail mid-handshake usually do so because a channel is stuck in INIT
+// or TRYOPEN, or went over the wrong connection, which this shows
+// without re-running the test with more logging. Nothing is logged if
+// t passes.
+//
+// This must be registered after the teardown in setup, so that it
+// runs while the chains and relayer are still up.
+func dumpTopology(t *testing.T, ctx context.Context, r ibc.Relayer, eRep *testreporter.RelayerExecReporter, chains ...*cosmos.CosmosChain) {
+	t.Cleanup(func() {
+		if !t.Failed() {
+			return
+		}
+		for _, chain := range chains {
+			chainID := chain.Config().ChainID
+
+			var connections connectionsQueryResponse
+			var channels channelsQueryResponse
+			err := QueryHostJSON(ctx, chain, []string{"ibc", "connection", "connections"}, &connections)
+			if err == nil {
+				err = QueryHostJSON(ctx, chain, []string{"ibc", "channel", "channels"}, &channels)
+			}
+			if err != nil {
+				t.Logf("failed to query topology of %s: %s", chainID, err)
+			} else {
+				logLines(t, describeTopology(chainID, connections.Connections, channels.Channels))
+			}
+
+			relayerConnections, err := r.GetConnections(ctx, eRep, chainID)
+			if err != nil {
+				t.Logf("failed to get relayer's connections on %s: %s", chainID, err)
+				continue
+			}
+			relayerChannels, err := ListChannels(ctx, r, eRep, chainID)
+			if err != nil {
+				t.Logf("failed to get relayer's channels on %s: %s", chainID, err)
+				continue
+			}
+			logLines(t, describeTopology(chainID+", as the relayer sees it", relayerConnections, relayerChannels))
+		}
+	})
+}
+
+func logLines(t *testing.T, lines []string) {
+	for _, line := range lines {
+		t.Log(line)
+	}
+}
+
+// Describes connections and channels, one per line, under a heading
+// naming whose view they are.
+func describeTopology(view string, connections []*ibc.ConnectionOutput, channels []ibc.ChannelOutput) []string {
+	lines := []string{fmt.Sprintf("topology of %s: %d connections, %d channels", view, len(connections), len(channels))}
+	for _, connection := range connections {
+		counterparty := "?"
+		if connection.Counterparty != nil {
+			counterparty = connection.Counterparty.ConnectionId
+			if counterparty == "" {
+				counterparty = "?"
+			}
+		}
+		lines = append(lines, fmt.Sprintf("  %s (%s) on client %s, counterparty %s", connection.ID, connection.State, connection.ClientID, counterparty))
+	}
+	for _, channel := range channels {
+		counterparty := channel.Counterparty.ChannelID
+		if counterparty == "" {
+			counterparty = "?"
+		}
+		lines = append(lines, fmt.Sprintf("  %s/%s (%s, %s) over %s, counterparty %s/%s, version %s",
+			channel.PortID, channel.ChannelID, channel.State, channel.Ordering,
+			strings.Join(channel.ConnectionHops, ","),
+			channel.Counterparty.PortID, counterparty, channel.Version))
+	}
+	return lines
+}
+
+func TestDescribeTopology(t *testing.T) {
+	stdout, err := os.ReadFile("testdata/ibc_connection_channels.json")
+	require.NoError(t, err)
+	var channels channelsQueryResponse
+	require.NoError(t, json.Unmarshal(stdout, &channels))
+
+	var connections connectionsQueryResponse
+	err = json.Unmarshal([]byte(`{"connections":[
+		{"id":"connection-0","client_id":"07-tendermint-0","versions":[{"identifier":"1","features":["ORDER_ORDERED","ORDER_UNORDERED"]}],"state":"STATE_OPEN","counterparty":{"client_id":"07-tendermint-0","connection_id":"connection-0","prefix":{"key_prefix":"aWJj"}},"delay_period":"0"},
+		{"id":"connection-1","client_id":"07-tendermint-1","versions":[{"identifier":"1","features":["ORDER_ORDERED","ORDER_UNORDERED"]}],"state":"STATE_INIT","counterparty":{"client_id":"07-tendermint-1","connection_id":"","prefix":{"key_prefix":"aWJj"}},"delay_period":"0"}
+	],"pagination":{"next_key":null,"total":"0"}}`), &connections)
+	require.NoError(t, err)
+
+	lines := describeTopology("neutron-2", connections.Connections, channels.Channels)
+	require.Len(t, lines, 1+len(connections.Connections)+len(channels.Channels))
+	require.Equal(t, fmt.Sprintf("topology of neutron-2: 2 connections, %d channels", len(channels.Channels)), lines[0])
+	require.Equal(t, "  connection-0 (STATE_OPEN) on client 07-tendermint-0, counterparty connection-0", lines[1])
+	require.Equal(t, "  connection-1 (STATE_INIT) on client 07-tendermint-1, counterparty ?", lines[2])
+	require.Equal(t, "  transfer/channel-1 (STATE_OPEN, ORDER_UNORDERED) over connection-1, counterparty transfer/channel-1, version ics20-1", lines[3])
+}
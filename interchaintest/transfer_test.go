@@ -0,0 +1,200 @@
+package ibc_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	sdk "github.com/cosmos/cosmos-sdk/types"
+	"github.com/strangelove-ventures/interchaintest/v3/chain/cosmos"
+	"github.com/strangelove-ventures/interchaintest/v3/ibc"
+	"github.com/stretchr/testify/require"
+)
+
+// The key interchaintest creates in each chain's genesis with a
+// balance of the chain's native denom.
+const faucetKeyName = "faucet"
+
+// Sends each of coins, keyed by denom, from the faucet to keyName's
+// account on chain. `ibctest.GetAndFundTestUsers` only funds users
+// with the native denom, so this is how tests give users other
+// denoms, which must first be sent to the faucet.
+func FundUserWithDenoms(ctx context.Context, chain *cosmos.CosmosChain, keyName string, coins map[string]int64) error {
+	address, err := chain.GetAddress(ctx, keyName)
+	if err != nil {
+		return fmt.Errorf("failed to get address of %s: %w", keyName, err)
+	}
+	bech32Address, err := sdk.Bech32ifyAddressBytes(chain.Config().Bech32Prefix, address)
+	if err != nil {
+		return err
+	}
+
+	// Fund in a fixed order, so that failures are reproducible.
+	denoms := make([]string, 0, len(coins))
+	for denom := range coins {
+		denoms = append(denoms, denom)
+	}
+	sort.Strings(denoms)
+	for _, denom := range denoms {
+		err := chain.SendFunds(ctx, faucetKeyName, ibc.WalletAmount{
+			Address: bech32Address,
+			Denom:   denom,
+			Amount:  coins[denom],
+		})
+		if err != nil {
+			return fmt.Errorf("failed to fund %s with %d%s: %w", keyName, coins[denom], denom, err)
+		}
+	}
+	return nil
+}
+
+// The denom that baseDenom, a native denom of the sending chain, has
+// on the receiving chain once transferred over ICS-20. portID and
+// channelID are the receiving chain's end of the channel.
+func IBCDenom(portID, channelID, baseDenom string) string {
+	hash := sha256.Sum256([]byte(portID + "/" + channelID + "/" + baseDenom))
+	return "ibc/" + strings.ToUpper(hex.EncodeToString(hash[:]))
+}
+
+// Waits for address, which must hold no IBC denoms beforehand, to
+// receive one, and returns that denom.
+func waitForIBCDenom(t *testing.T, ctx context.Context, chain *cosmos.CosmosChain, address string) string {
+	t.Helper()
+	var ibcDenom string
+	require.Eventually(t, func() bool {
+		balances, err := queryBalances(ctx, chain, address)
+		if err != nil {
+			return false
+		}
+		for denom := range balances {
+			if strings.HasPrefix(denom, "ibc/") {
+				ibcDenom = denom
+			}
+		}
+		return ibcDenom != ""
+	}, 2*time.Minute, pollInterval, "no IBC denom arrived at %s", address)
+	return ibcDenom
+}
+
+// Tests that a user can be funded with the native denom and with Atom
+// transferred to Neutron over IBC.
+func TestFundUserWithDenoms(t *testing.T) {
+	env := setupICSTest(t)
+	ctx, atom, neutron := env.ctx, env.atom, env.neutron
+
+	channel, err := transferChannel(ctx, env.relayer, env.eRep, atom.Config().ChainID)
+	require.NoError(t, err)
+	faucet, err := neutron.GetAddress(ctx, faucetKeyName)
+	require.NoError(t, err)
+	faucetAddress, err := sdk.Bech32ifyAddressBytes(neutron.Config().Bech32Prefix, faucet)
+	require.NoError(t, err)
+	_, err = atom.SendIBCTransfer(ctx, channel.ChannelID, env.atomUser.KeyName, ibc.WalletAmount{
+		Address: faucetAddress,
+		Denom:   atom.Config().Denom,
+		Amount:  1_000_000,
+	}, ibc.TransferOptions{})
+	require.NoError(t, err, "failed to transfer atom to neutron")
+
+	ibcDenom := IBCDenom(channel.Counterparty.PortID, channel.Counterparty.ChannelID, atom.Config().Denom)
+	_, err = WaitForBalance(ctx, neutron, faucetAddress, ibcDenom, 1_000_000, 2*time.Minute)
+	require.NoError(t, err, "transferred atom never arrived")
+
+	err = FundUserWithDenoms(ctx, neutron, env.neutronUser.KeyName, map[string]int64{
+		neutron.Config().Denom: 1_000,
+		ibcDenom:               500_000,
+	})
+	require.NoError(t, err)
+
+	balances, err := queryBalances(ctx, neutron, env.neutronUser.Bech32Address(neutron.Config().Bech32Prefix))
+	require.NoError(t, err)
+	require.Equal(t, int64(100_001_000), balances[neutron.Config().Denom])
+	require.Equal(t, int64(500_000), balances[ibcDenom])
+}
+
+// Tests the IBC transfer path by sending atom to Neutron and back to
+// an interchain account on Atom, which then spends it.
+func TestTransferBeforeICA(t *testing.T) {
+	const amount = 5_000_000
+	env := setupICSTest(t)
+	ctx, atom, neutron := env.ctx, env.atom, env.neutron
+	denom := atom.Config().Denom
+	neutronUserAddress := env.neutronUser.Bech32Address(neutron.Config().Bech32Prefix)
+
+	atomChannel, err := transferChannel(ctx, env.relayer, env.eRep, atom.Config().ChainID)
+	require.NoError(t, err)
+	_, err = atom.SendIBCTransfer(ctx, atomChannel.ChannelID, env.atomUser.KeyName, ibc.WalletAmount{
+		Address: neutronUserAddress,
+		Denom:   denom,
+		Amount:  amount,
+	}, ibc.TransferOptions{})
+	require.NoError(t, err, "failed to transfer atom to neutron")
+
+	ibcDenom := waitForIBCDenom(t, ctx, neutron, neutronUserAddress)
+	require.Equal(t, IBCDenom(atomChannel.Counterparty.PortID, atomChannel.Counterparty.ChannelID, denom), ibcDenom)
+	balance, err := WaitForBalance(ctx, neutron, neutronUserAddress, ibcDenom, amount, time.Minute)
+	require.NoError(t, err)
+	require.Equal(t, int64(amount), balance)
+
+	// Sending the atom back unwraps it to the native denom.
+	contract := deployICAContract(t, env)
+	icaAddress := registerICA(t, env, contract, "test")
+	_, err = neutron.SendIBCTransfer(ctx, atomChannel.Counterparty.ChannelID, env.neutronUser.KeyName, ibc.WalletAmount{
+		Address: icaAddress,
+		Denom:   ibcDenom,
+		Amount:  amount,
+	}, ibc.TransferOptions{})
+	require.NoError(t, err, "failed to transfer atom to the ICA")
+	_, err = WaitForBalance(ctx, atom, icaAddress, denom, amount, 2*time.Minute)
+	require.NoError(t, err)
+
+	atomUserAddress := env.atomUser.Bech32Address(atom.Config().Bech32Prefix)
+	sequence, err := SubmitICASend(ctx, neutron, env.neutronUser.KeyName, contract, "test", atomUserAddress, 1_000, denom, 0)
+	require.NoError(t, err, "failed to submit ICA send")
+	result, err := WaitForAcknowledgement(ctx, neutron, contract, "test", sequence, 2*time.Minute)
+	require.NoError(t, err)
+	require.Equal(t, []string{"/cosmos.bank.v1beta1.MsgSend"}, result.Success)
+
+	balance, err = atom.GetBalance(ctx, icaAddress, denom)
+	require.NoError(t, err)
+	require.Equal(t, int64(amount-1_000), balance)
+}
+
+func TestIBCDenom(t *testing.T) {
+	// Atom on Osmosis.
+	require.Equal(t, "ibc/27394FB092D2ECCD56123C74F36E4C1F926001CEADA9CA97EA622B25F41E5EB2", IBCDenom("transfer", "channel-0", "uatom"))
+	require.NotEqual(t, IBCDenom("transfer", "channel-0", "uatom"), IBCDenom("transfer", "channel-1", "uatom"))
+}
+
+// Tests that with a second relayer on the transfer path, transfers
+// and interchain account handshakes both complete while the other is
+// in flight.
+func TestSeparateTransferRelayer(t *testing.T) {
+	const amount = 1_000_000
+	env := setupICSTestWithConfig(t, icsTestConfig{transferRelayer: true})
+	ctx, atom, neutron := env.ctx, env.atom, env.neutron
+	require.NotSame(t, env.relayer, env.transferRelayer)
+
+	channel, err := transferChannel(ctx, env.transferRelayer, env.eRep, atom.Config().ChainID)
+	require.NoError(t, err)
+	ibcDenom := IBCDenom(channel.Counterparty.PortID, channel.Counterparty.ChannelID, atom.Config().Denom)
+	neutronUserAddress := env.neutronUser.Bech32Address(neutron.Config().Bech32Prefix)
+
+	// Register an account while the transfer is relayed, so that
+	// both relayers have work at once.
+	_, err = atom.SendIBCTransfer(ctx, channel.ChannelID, env.atomUser.KeyName, ibc.WalletAmount{
+		Address: neutronUserAddress,
+		Denom:   atom.Config().Denom,
+		Amount:  amount,
+	}, ibc.TransferOptions{})
+	require.NoError(t, err, "failed to transfer atom to neutron")
+	contract := deployICAContract(t, env)
+	registerICA(t, env, contract, "test")
+
+	_, err = WaitForBalance(ctx, neutron, neutronUserAddress, ibcDenom, amount, 2*time.Minute)
+	require.NoError(t, err, "the transfer relayer should deliver transfers")
+}
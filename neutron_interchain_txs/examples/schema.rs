@@ -1,5 +1,8 @@
 use cosmwasm_schema::{export_schema, remove_schemas, schema_for};
-use neutron_interchain_txs::msg::{ExecuteMsg, InstantiateMsg, MigrateMsg, QueryMsg};
+use neutron_interchain_txs::msg::{
+    ExecuteMsg, InstantiateMsg, InterchainAccountChannelResponse, MigrateMsg, QueryMsg,
+    SequencedAcknowledgementResult,
+};
 use neutron_sdk::bindings::query::QueryInterchainAccountAddressResponse;
 use neutron_sdk::sudo::msg::SudoMsg;
 use std::env::current_dir;
@@ -20,4 +23,6 @@ fn main() {
         &schema_for!(QueryInterchainAccountAddressResponse),
         &out_dir,
     );
+    export_schema(&schema_for!(InterchainAccountChannelResponse), &out_dir);
+    export_schema(&schema_for!(SequencedAcknowledgementResult), &out_dir);
 }
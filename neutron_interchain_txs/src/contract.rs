@@ -5,15 +5,19 @@ use cosmos_sdk_proto::cosmos::staking::v1beta1::{
 #[cfg(not(feature = "library"))]
 use cosmwasm_std::entry_point;
 use cosmwasm_std::{
-    to_binary, Binary, CosmosMsg, CustomQuery, Deps, DepsMut, Env, MessageInfo, Reply, Response,
-    StdError, StdResult, SubMsg,
+    to_binary, Binary, CosmosMsg, CustomQuery, Deps, DepsMut, Env, MessageInfo, Order, Reply,
+    Response, StdError, StdResult, SubMsg,
 };
 use cw2::set_contract_version;
+use cw_storage_plus::Bound;
 use prost::Message;
 use schemars::JsonSchema;
 use serde::{Deserialize, Serialize};
 
-use crate::msg::{ExecuteMsg, InstantiateMsg, MigrateMsg, QueryMsg};
+use crate::msg::{
+    ExecuteMsg, InstantiateMsg, InterchainAccountChannelResponse, MigrateMsg, QueryMsg,
+    SequencedAcknowledgementResult,
+};
 use neutron_sdk::bindings::msg::IbcFee;
 use neutron_sdk::{
     bindings::{
@@ -31,14 +35,19 @@ use neutron_sdk::{
 
 use crate::storage::{
     add_error_to_queue, read_errors_from_queue, read_reply_payload, read_sudo_payload,
-    save_reply_payload, save_sudo_payload, AcknowledgementResult, SudoPayload,
-    ACKNOWLEDGEMENT_RESULTS, INTERCHAIN_ACCOUNTS, SUDO_PAYLOAD_REPLY_ID,
+    save_last_acked_sequence, save_reply_payload, save_sudo_payload, AcknowledgementResult,
+    SudoPayload, ACKNOWLEDGEMENT_RESULTS, CLOSED_CHANNELS, INTERCHAIN_ACCOUNTS,
+    INTERCHAIN_CHANNELS, LAST_ACKED_SEQUENCES, SUDO_PAYLOAD_REPLY_ID,
 };
 
 // Default timeout for SubmitTX is two weeks
 const DEFAULT_TIMEOUT_SECONDS: u64 = 60 * 60 * 24 * 7 * 2;
 const FEE_DENOM: &str = "untrn";
 
+// Page sizes of the AcknowledgementResults query
+const DEFAULT_ACKNOWLEDGEMENT_RESULTS_LIMIT: u32 = 10;
+const MAX_ACKNOWLEDGEMENT_RESULTS_LIMIT: u32 = 30;
+
 const CONTRACT_NAME: &str = concat!("crates.io:neutron-sdk__", env!("CARGO_PKG_NAME"));
 const CONTRACT_VERSION: &str = env!("CARGO_PKG_VERSION");
 
@@ -109,6 +118,12 @@ pub fn execute(
             denom,
             timeout,
         ),
+        ExecuteMsg::SubmitTx {
+            interchain_account_id,
+            msgs,
+            timeout,
+            memo,
+        } => execute_submit_tx(deps, env, interchain_account_id, msgs, timeout, memo),
     }
 }
 
@@ -127,6 +142,17 @@ pub fn query(deps: Deps<NeutronQuery>, env: Env, msg: QueryMsg) -> NeutronResult
             sequence_id,
         } => query_acknowledgement_result(deps, env, interchain_account_id, sequence_id),
         QueryMsg::ErrorsQueue {} => query_errors_queue(deps),
+        QueryMsg::InterchainAccountChannel {
+            interchain_account_id,
+        } => query_interchain_channel(deps, env, interchain_account_id),
+        QueryMsg::LastAcknowledgedSequence {
+            interchain_account_id,
+        } => query_last_acked_sequence(deps, env, interchain_account_id),
+        QueryMsg::AcknowledgementResults {
+            interchain_account_id,
+            start_after,
+            limit,
+        } => query_acknowledgement_results(deps, env, interchain_account_id, start_after, limit),
     }
 }
 
@@ -173,6 +199,81 @@ pub fn query_errors_queue(deps: Deps<NeutronQuery>) -> NeutronResult<Binary> {
     Ok(to_binary(&res)?)
 }
 
+// returns the port and channel of an ICA from the contract storage. The channel was saved in sudo_open_ack method
+// and is reported closed once sudo_timeout saw a packet on it time out
+pub fn query_interchain_channel(
+    deps: Deps<NeutronQuery>,
+    env: Env,
+    interchain_account_id: String,
+) -> NeutronResult<Binary> {
+    let port_id = get_port_id(env.contract.address.as_str(), &interchain_account_id);
+    if !INTERCHAIN_ACCOUNTS.has(deps.storage, port_id.clone()) {
+        return Err(StdError::generic_err("Interchain account is not registered").into());
+    }
+    let res = match INTERCHAIN_CHANNELS.may_load(deps.storage, port_id.clone())? {
+        Some(channel_id) => {
+            let state = if CLOSED_CHANNELS.has(deps.storage, channel_id.clone()) {
+                "CLOSED"
+            } else {
+                "OPEN"
+            };
+            InterchainAccountChannelResponse {
+                port_id,
+                channel_id,
+                state: state.to_string(),
+            }
+        }
+        None => InterchainAccountChannelResponse {
+            port_id,
+            channel_id: "".to_string(),
+            state: "PENDING".to_string(),
+        },
+    };
+    Ok(to_binary(&res)?)
+}
+
+// returns the highest sequence id of the ICA's interchain transactions that got an ack/err/timeout
+pub fn query_last_acked_sequence(
+    deps: Deps<NeutronQuery>,
+    env: Env,
+    interchain_account_id: String,
+) -> NeutronResult<Binary> {
+    let port_id = get_port_id(env.contract.address.as_str(), &interchain_account_id);
+    let res = LAST_ACKED_SEQUENCES.may_load(deps.storage, port_id)?;
+    Ok(to_binary(&res)?)
+}
+
+// returns up to limit acknowledgement results of the ICA with sequence ids after start_after, in ascending order
+pub fn query_acknowledgement_results(
+    deps: Deps<NeutronQuery>,
+    env: Env,
+    interchain_account_id: String,
+    start_after: Option<u64>,
+    limit: Option<u32>,
+) -> NeutronResult<Binary> {
+    let port_id = get_port_id(env.contract.address.as_str(), &interchain_account_id);
+    let limit = limit
+        .unwrap_or(DEFAULT_ACKNOWLEDGEMENT_RESULTS_LIMIT)
+        .min(MAX_ACKNOWLEDGEMENT_RESULTS_LIMIT) as usize;
+    let res = ACKNOWLEDGEMENT_RESULTS
+        .prefix(port_id)
+        .range(
+            deps.storage,
+            start_after.map(Bound::exclusive),
+            None,
+            Order::Ascending,
+        )
+        .take(limit)
+        .map(|item| {
+            item.map(|(sequence_id, result)| SequencedAcknowledgementResult {
+                sequence_id,
+                result,
+            })
+        })
+        .collect::<StdResult<Vec<_>>>()?;
+    Ok(to_binary(&res)?)
+}
+
 // saves payload to process later to the storage and returns a SubmitTX Cosmos SubMsg with necessary reply id
 fn msg_with_sudo_callback<C: Into<CosmosMsg<T>>, T>(
     deps: DepsMut<NeutronQuery>,
@@ -315,6 +416,44 @@ fn execute_undelegate(
     Ok(Response::default().add_submessages(vec![submsg]))
 }
 
+fn execute_submit_tx(
+    mut deps: DepsMut<NeutronQuery>,
+    env: Env,
+    interchain_account_id: String,
+    msgs: Vec<ProtobufAny>,
+    timeout: Option<u64>,
+    memo: Option<String>,
+) -> NeutronResult<Response<NeutronMsg>> {
+    // contract must pay for relaying of acknowledgements
+    // See more info here: https://docs.neutron.org/neutron/feerefunder/overview
+    let fee = min_ntrn_ibc_fee(query_min_ibc_fee(deps.as_ref())?.min_fee);
+    let (_, connection_id) = get_ica(deps.as_ref(), &env, &interchain_account_id)?;
+
+    // The messages are already encoded by the caller, so unlike delegate and undelegate
+    // there is nothing to build here. The host chain rejects messages it can't decode.
+    let cosmos_msg = NeutronMsg::submit_tx(
+        connection_id,
+        interchain_account_id.clone(),
+        msgs,
+        memo.unwrap_or_default(),
+        timeout.unwrap_or(DEFAULT_TIMEOUT_SECONDS),
+        fee,
+    );
+
+    // We use a submessage here because we need the process message reply to save
+    // the outgoing IBC packet identifier for later.
+    let submsg = msg_with_sudo_callback(
+        deps.branch(),
+        cosmos_msg,
+        SudoPayload {
+            port_id: get_port_id(env.contract.address.as_str(), &interchain_account_id),
+            message: "message".to_string(),
+        },
+    )?;
+
+    Ok(Response::default().add_submessages(vec![submsg]))
+}
+
 #[cfg_attr(not(feature = "library"), entry_point)]
 pub fn sudo(deps: DepsMut, env: Env, msg: SudoMsg) -> StdResult<Response> {
     deps.api
@@ -359,7 +498,7 @@ fn sudo_open_ack(
     deps: DepsMut,
     _env: Env,
     port_id: String,
-    _channel_id: String,
+    channel_id: String,
     _counterparty_channel_id: String,
     counterparty_version: String,
 ) -> StdResult<Response> {
@@ -370,6 +509,7 @@ fn sudo_open_ack(
 
     // Update the storage record associated with the interchain account.
     if let Ok(parsed_version) = parsed_version {
+        INTERCHAIN_CHANNELS.save(deps.storage, port_id.clone(), &channel_id)?;
         INTERCHAIN_ACCOUNTS.save(
             deps.storage,
             port_id,
@@ -485,7 +625,7 @@ fn sudo_response(deps: DepsMut, request: RequestPacket, data: Binary) -> StdResu
         // update but also check that we don't update same seq_id twice
         ACKNOWLEDGEMENT_RESULTS.update(
             deps.storage,
-            (payload.port_id, seq_id),
+            (payload.port_id.clone(), seq_id),
             |maybe_ack| -> StdResult<AcknowledgementResult> {
                 match maybe_ack {
                     Some(_ack) => Err(StdError::generic_err("trying to update same seq_id")),
@@ -493,6 +633,7 @@ fn sudo_response(deps: DepsMut, request: RequestPacket, data: Binary) -> StdResu
                 }
             },
         )?;
+        save_last_acked_sequence(deps.storage, payload.port_id, seq_id)?;
     }
 
     Ok(Response::default())
@@ -520,6 +661,9 @@ fn sudo_timeout(deps: DepsMut, _env: Env, request: RequestPacket) -> StdResult<R
         .source_channel
         .ok_or_else(|| StdError::generic_err("channel_id not found"))?;
 
+    // a timeout closes the ordered channel the packet was sent over
+    CLOSED_CHANNELS.save(deps.storage, channel_id.clone(), &true)?;
+
     // update but also check that we don't update same seq_id twice
     // NOTE: NO ERROR IS RETURNED HERE. THE CHANNEL LIVES ON.
     // In this particular example, this is a matter of developer's choice. Not being able to read
@@ -534,7 +678,7 @@ fn sudo_timeout(deps: DepsMut, _env: Env, request: RequestPacket) -> StdResult<R
         // update but also check that we don't update same seq_id twice
         ACKNOWLEDGEMENT_RESULTS.update(
             deps.storage,
-            (payload.port_id, seq_id),
+            (payload.port_id.clone(), seq_id),
             |maybe_ack| -> StdResult<AcknowledgementResult> {
                 match maybe_ack {
                     Some(_ack) => Err(StdError::generic_err("trying to update same seq_id")),
@@ -542,6 +686,7 @@ fn sudo_timeout(deps: DepsMut, _env: Env, request: RequestPacket) -> StdResult<R
                 }
             },
         )?;
+        save_last_acked_sequence(deps.storage, payload.port_id, seq_id)?;
     } else {
         let error_msg = "WASMDEBUG: Error: Unable to read sudo payload";
         deps.api.debug(error_msg);
@@ -580,7 +725,7 @@ fn sudo_error(deps: DepsMut, request: RequestPacket, details: String) -> StdResu
         // update but also check that we don't update same seq_id twice
         ACKNOWLEDGEMENT_RESULTS.update(
             deps.storage,
-            (payload.port_id, seq_id),
+            (payload.port_id.clone(), seq_id),
             |maybe_ack| -> StdResult<AcknowledgementResult> {
                 match maybe_ack {
                     Some(_ack) => Err(StdError::generic_err("trying to update same seq_id")),
@@ -588,6 +733,7 @@ fn sudo_error(deps: DepsMut, request: RequestPacket, details: String) -> StdResu
                 }
             },
         )?;
+        save_last_acked_sequence(deps.storage, payload.port_id, seq_id)?;
     } else {
         let error_msg = "WASMDEBUG: Error: Unable to read sudo payload";
         deps.api.debug(error_msg);
@@ -1,3 +1,5 @@
+use crate::storage::AcknowledgementResult;
+use neutron_sdk::bindings::types::ProtobufAny;
 use schemars::JsonSchema;
 use serde::{Deserialize, Serialize};
 
@@ -20,6 +22,42 @@ pub enum QueryMsg {
     },
     // this query returns non-critical errors list
     ErrorsQueue {},
+    // this query returns the port and channel of an ICA from contract store
+    InterchainAccountChannel {
+        interchain_account_id: String,
+    },
+    // this query returns the highest sequence id of an ICA's interchain transactions that got a response
+    LastAcknowledgedSequence {
+        interchain_account_id: String,
+    },
+    // this query returns acknowledgement results of an ICA in ascending order of sequence id, a page at a time
+    AcknowledgementResults {
+        interchain_account_id: String,
+        /// the last sequence id of the previous page, unset for the first page
+        start_after: Option<u64>,
+        /// the most results to return, 10 if unset and at most 30
+        limit: Option<u32>,
+    },
+}
+
+/// The channel an interchain account's transactions are sent over.
+#[derive(Serialize, Deserialize, Clone, Debug, PartialEq, Eq, JsonSchema)]
+#[serde(rename_all = "snake_case")]
+pub struct InterchainAccountChannelResponse {
+    pub port_id: String,
+    /// empty until the channel handshake completes
+    pub channel_id: String,
+    /// `PENDING` until the channel handshake completes, then `OPEN`,
+    /// and `CLOSED` once a packet on the channel times out
+    pub state: String,
+}
+
+/// The acknowledgement result of one of an interchain account's transactions.
+#[derive(Serialize, Deserialize, Clone, Debug, PartialEq, Eq, JsonSchema)]
+#[serde(rename_all = "snake_case")]
+pub struct SequencedAcknowledgementResult {
+    pub sequence_id: u64,
+    pub result: AcknowledgementResult,
 }
 
 #[derive(Serialize, Deserialize, Clone, Debug, PartialEq, Eq, JsonSchema)]
@@ -49,4 +87,12 @@ pub enum ExecuteMsg {
         denom: String,
         timeout: Option<u64>,
     },
+    /// submits arbitrary protobuf encoded messages to be executed by the interchain account
+    SubmitTx {
+        interchain_account_id: String,
+        msgs: Vec<ProtobufAny>,
+        timeout: Option<u64>,
+        /// sent to the host in the interchain account packet, empty if unset
+        memo: Option<String>,
+    },
 }
@@ -18,11 +18,18 @@ pub const REPLY_ID_STORAGE: Item<Vec<u8>> = Item::new("reply_queue_id");
 pub const SUDO_PAYLOAD: Map<(String, u64), Vec<u8>> = Map::new("sudo_payload");
 pub const INTERCHAIN_ACCOUNTS: Map<String, Option<(String, String)>> =
     Map::new("interchain_accounts");
+// channel ids of interchain accounts, keyed by port id. Saved in sudo_open_ack
+pub const INTERCHAIN_CHANNELS: Map<String, String> = Map::new("interchain_channels");
+// channel ids of interchain account channels that a timeout closed. Saved in sudo_timeout
+pub const CLOSED_CHANNELS: Map<String, bool> = Map::new("closed_channels");
 
 // interchain transaction responses - ack/err/timeout state to query later
 pub const ACKNOWLEDGEMENT_RESULTS: Map<(String, u64), AcknowledgementResult> =
     Map::new("acknowledgement_results");
 
+// highest sequence id of an interchain transaction that got an ack/err/timeout, keyed by port id
+pub const LAST_ACKED_SEQUENCES: Map<String, u64> = Map::new("last_acked_sequences");
+
 pub const ERRORS_QUEUE: Map<u32, String> = Map::new("errors_queue");
 
 /// Serves for storing acknowledgement calls for interchain transactions
@@ -63,6 +70,18 @@ pub fn read_errors_from_queue(store: &dyn Storage) -> StdResult<Vec<(Vec<u8>, St
         .collect()
 }
 
+// saves seq_id as the last acknowledged sequence of port_id, unless a later one was already saved
+pub fn save_last_acked_sequence(
+    store: &mut dyn Storage,
+    port_id: String,
+    seq_id: u64,
+) -> StdResult<()> {
+    LAST_ACKED_SEQUENCES.update(store, port_id, |last| -> StdResult<u64> {
+        Ok(last.map_or(seq_id, |last| last.max(seq_id)))
+    })?;
+    Ok(())
+}
+
 pub fn read_sudo_payload(
     store: &mut dyn Storage,
     channel_id: String,
@@ -1,17 +1,25 @@
 use std::marker::PhantomData;
 
 use crate::{
-    contract::query_errors_queue,
-    storage::{add_error_to_queue, read_errors_from_queue, ERRORS_QUEUE},
+    contract::{
+        query_acknowledgement_results, query_errors_queue, query_interchain_channel,
+        query_last_acked_sequence,
+    },
+    msg::{InterchainAccountChannelResponse, SequencedAcknowledgementResult},
+    storage::{
+        add_error_to_queue, read_errors_from_queue, save_last_acked_sequence,
+        AcknowledgementResult, ACKNOWLEDGEMENT_RESULTS, CLOSED_CHANNELS, ERRORS_QUEUE,
+        INTERCHAIN_ACCOUNTS, INTERCHAIN_CHANNELS,
+    },
 };
 
 use cosmwasm_std::{
     from_binary,
-    testing::{MockApi, MockQuerier, MockStorage},
+    testing::{mock_env, MockApi, MockQuerier, MockStorage},
     OwnedDeps,
 };
 
-use neutron_sdk::bindings::query::NeutronQuery;
+use neutron_sdk::{bindings::query::NeutronQuery, interchain_txs::helpers::get_port_id};
 
 pub fn mock_dependencies() -> OwnedDeps<MockStorage, MockApi, MockQuerier, NeutronQuery> {
     OwnedDeps {
@@ -78,3 +86,152 @@ fn test_errors_queue() {
         ]
     );
 }
+
+#[test]
+fn test_query_interchain_channel() {
+    let mut deps = mock_dependencies();
+    let env = mock_env();
+    let port_id = get_port_id(env.contract.address.as_str(), "test");
+
+    // not registered
+    query_interchain_channel(deps.as_ref(), env.clone(), "test".to_string()).unwrap_err();
+
+    // registered, but the channel is not open yet
+    INTERCHAIN_ACCOUNTS
+        .save(&mut deps.storage, port_id.clone(), &None)
+        .unwrap();
+    let result = query_interchain_channel(deps.as_ref(), env.clone(), "test".to_string()).unwrap();
+    let result: InterchainAccountChannelResponse = from_binary(&result).unwrap();
+    assert_eq!(
+        InterchainAccountChannelResponse {
+            port_id: port_id.clone(),
+            channel_id: "".to_string(),
+            state: "PENDING".to_string(),
+        },
+        result
+    );
+
+    INTERCHAIN_CHANNELS
+        .save(&mut deps.storage, port_id.clone(), &"channel-1".to_string())
+        .unwrap();
+    let result = query_interchain_channel(deps.as_ref(), env.clone(), "test".to_string()).unwrap();
+    let result: InterchainAccountChannelResponse = from_binary(&result).unwrap();
+    assert_eq!(
+        InterchainAccountChannelResponse {
+            port_id: port_id.clone(),
+            channel_id: "channel-1".to_string(),
+            state: "OPEN".to_string(),
+        },
+        result
+    );
+
+    CLOSED_CHANNELS
+        .save(&mut deps.storage, "channel-1".to_string(), &true)
+        .unwrap();
+    let result = query_interchain_channel(deps.as_ref(), env.clone(), "test".to_string()).unwrap();
+    let result: InterchainAccountChannelResponse = from_binary(&result).unwrap();
+    assert_eq!(
+        InterchainAccountChannelResponse {
+            port_id: port_id.clone(),
+            channel_id: "channel-1".to_string(),
+            state: "CLOSED".to_string(),
+        },
+        result
+    );
+
+    // registering again saves the new channel, which is open
+    INTERCHAIN_CHANNELS
+        .save(&mut deps.storage, port_id.clone(), &"channel-2".to_string())
+        .unwrap();
+    let result = query_interchain_channel(deps.as_ref(), env, "test".to_string()).unwrap();
+    let result: InterchainAccountChannelResponse = from_binary(&result).unwrap();
+    assert_eq!(
+        InterchainAccountChannelResponse {
+            port_id,
+            channel_id: "channel-2".to_string(),
+            state: "OPEN".to_string(),
+        },
+        result
+    );
+}
+
+#[test]
+fn test_query_last_acked_sequence() {
+    let mut deps = mock_dependencies();
+    let env = mock_env();
+    let port_id = get_port_id(env.contract.address.as_str(), "test");
+
+    // nothing acknowledged yet
+    let result = query_last_acked_sequence(deps.as_ref(), env.clone(), "test".to_string()).unwrap();
+    let result: Option<u64> = from_binary(&result).unwrap();
+    assert_eq!(None, result);
+
+    save_last_acked_sequence(&mut deps.storage, port_id.clone(), 2).unwrap();
+    // an older sequence acknowledged later does not move it back
+    save_last_acked_sequence(&mut deps.storage, port_id, 1).unwrap();
+    let result = query_last_acked_sequence(deps.as_ref(), env.clone(), "test".to_string()).unwrap();
+    let result: Option<u64> = from_binary(&result).unwrap();
+    assert_eq!(Some(2), result);
+
+    // other accounts are tracked separately
+    let result = query_last_acked_sequence(deps.as_ref(), env, "other".to_string()).unwrap();
+    let result: Option<u64> = from_binary(&result).unwrap();
+    assert_eq!(None, result);
+}
+
+#[test]
+fn test_query_acknowledgement_results() {
+    let mut deps = mock_dependencies();
+    let env = mock_env();
+    let port_id = get_port_id(env.contract.address.as_str(), "test");
+
+    for sequence_id in 1..=5u64 {
+        ACKNOWLEDGEMENT_RESULTS
+            .save(
+                &mut deps.storage,
+                (port_id.clone(), sequence_id),
+                &AcknowledgementResult::Success(vec![format!("msg-{}", sequence_id)]),
+            )
+            .unwrap();
+    }
+    // results of other accounts are not listed
+    ACKNOWLEDGEMENT_RESULTS
+        .save(
+            &mut deps.storage,
+            (get_port_id(env.contract.address.as_str(), "other"), 3),
+            &AcknowledgementResult::Timeout("payload".to_string()),
+        )
+        .unwrap();
+
+    let page = |start_after: Option<u64>, limit: Option<u32>| -> Vec<u64> {
+        let result = query_acknowledgement_results(
+            deps.as_ref(),
+            env.clone(),
+            "test".to_string(),
+            start_after,
+            limit,
+        )
+        .unwrap();
+        let result: Vec<SequencedAcknowledgementResult> = from_binary(&result).unwrap();
+        result.into_iter().map(|r| r.sequence_id).collect()
+    };
+
+    assert_eq!(vec![1, 2], page(None, Some(2)));
+    assert_eq!(vec![3, 4], page(Some(2), Some(2)));
+    // the last page is short
+    assert_eq!(vec![5], page(Some(4), Some(2)));
+    assert_eq!(Vec::<u64>::new(), page(Some(5), Some(2)));
+    assert_eq!(vec![1, 2, 3, 4, 5], page(None, None));
+
+    let result =
+        query_acknowledgement_results(deps.as_ref(), env, "test".to_string(), Some(4), None)
+            .unwrap();
+    let result: Vec<SequencedAcknowledgementResult> = from_binary(&result).unwrap();
+    assert_eq!(
+        vec![SequencedAcknowledgementResult {
+            sequence_id: 5,
+            result: AcknowledgementResult::Success(vec!["msg-5".to_string()]),
+        }],
+        result
+    );
+}